@@ -0,0 +1,94 @@
+// Command backup takes and restores backups of a Spines instance. It reads
+// the same settings as the server (DATABASE_PATH, BACKUP_PATH, BACKUP_KEEP).
+//
+//	backup create          back up the database and uploads, safe while the server runs
+//	backup list            list the backups in BACKUP_PATH
+//	backup restore FILE    restore a backup; stop the server first
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/nuuner/spines/internal/backup"
+	"github.com/nuuner/spines/internal/config"
+	"github.com/nuuner/spines/internal/database"
+	"github.com/nuuner/spines/internal/models"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  backup create          back up the database and uploads, safe while the server runs")
+	fmt.Fprintln(os.Stderr, "  backup list            list the backups in BACKUP_PATH")
+	fmt.Fprintln(os.Stderr, "  backup restore FILE    restore a backup; stop the server first")
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	cfg := config.Load()
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "create":
+		// Connecting would create an empty database rather than fail
+		if _, err := os.Stat(cfg.DatabasePath); err != nil {
+			log.Fatalf("Database not found: %v", err)
+		}
+		if err := database.Connect(cfg.DatabasePath); err != nil {
+			log.Fatalf("Failed to connect to database: %v", err)
+		}
+		defer database.Close()
+
+		archive, err := backup.Create(cfg.BackupPath, models.UploadsDir, cfg.BackupKeep)
+		if err != nil {
+			log.Fatalf("Backup failed: %v", err)
+		}
+		fmt.Printf("Created %s (%s)\n", filepath.Join(cfg.BackupPath, archive.Name), archive.SizeDisplay())
+
+	case "list":
+		archives, err := backup.List(cfg.BackupPath)
+		if err != nil {
+			log.Fatalf("Failed to list backups: %v", err)
+		}
+		if len(archives) == 0 {
+			fmt.Println("No backups in " + cfg.BackupPath)
+		}
+		for _, a := range archives {
+			fmt.Printf("%s\t%s\t%s\n", a.Name, a.CreatedAtDisplay(), a.SizeDisplay())
+		}
+
+	case "restore":
+		if len(os.Args) != 3 {
+			usage()
+		}
+		// A bare name refers to a backup in BACKUP_PATH
+		path := os.Args[2]
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if p, ok := backup.Path(cfg.BackupPath, path); ok {
+				path = p
+			}
+		}
+
+		previous, err := backup.Restore(path, cfg.DatabasePath, models.UploadsDir)
+		if previous != nil {
+			if previous.Database != "" {
+				fmt.Printf("The previous database was kept as %s\n", previous.Database)
+			}
+			if previous.Uploads != "" {
+				fmt.Printf("Uploads the backup replaced were kept in %s\n", previous.Uploads)
+			}
+		}
+		if err != nil {
+			log.Fatalf("Restore failed: %v", err)
+		}
+		fmt.Printf("Restored %s from %s\n", cfg.DatabasePath, path)
+
+	default:
+		usage()
+	}
+}
@@ -6,9 +6,12 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/template/html/v2"
+	"github.com/nuuner/spines/internal/activitypub"
+	"github.com/nuuner/spines/internal/archive"
 	"github.com/nuuner/spines/internal/config"
 	"github.com/nuuner/spines/internal/database"
 	"github.com/nuuner/spines/internal/handlers"
+	"github.com/nuuner/spines/internal/importer"
 	"github.com/nuuner/spines/internal/middleware"
 	"github.com/nuuner/spines/internal/models"
 )
@@ -29,6 +32,28 @@ func main() {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}
 
+	models.SetRatingScale(cfg.RatingScale)
+
+	// Books added before genres were recorded get them in the background
+	go models.BackfillBookCategories(cfg.GoogleBooksAPIKey)
+
+	// Pick up imports and data exports interrupted by a restart
+	importer.ResumeJobs(cfg.GoogleBooksAPIKey)
+	archive.SetDir(cfg.ExportPath)
+	archive.ResumeExports()
+	if err := archive.RemoveExpired(); err != nil {
+		log.Printf("Warning: Failed to remove expired data exports: %v", err)
+	}
+
+	// Feeds and catalogs link to the public address, and users are federated
+	// over ActivityPub once the instance has one
+	handlers.SetPublicURL(cfg.PublicURL)
+	if cfg.PublicURL != "" {
+		activitypub.SetBaseURL(cfg.PublicURL)
+		activitypub.SetAllowLocal(cfg.FederateLocal)
+		activitypub.StartDelivery()
+	}
+
 	// Clean up expired sessions on startup
 	if err := models.DeleteExpiredSessions(); err != nil {
 		log.Printf("Warning: Failed to clean up expired sessions: %v", err)
@@ -41,6 +66,19 @@ func main() {
 		"subtract": func(a, b int) int {
 			return a - b
 		},
+		"add": func(a, b int) int {
+			return a + b
+		},
+		"ratingOptions": models.RatingOptions,
+		"ratingScale": func() int {
+			return models.RatingScale
+		},
+		"sortOptions": func() []models.SortOption {
+			return models.ShelfSorts
+		},
+		"formatOptions": func() []models.FormatOption {
+			return models.BookFormats
+		},
 	})
 
 	app := fiber.New(fiber.Config{
@@ -61,6 +99,7 @@ func main() {
 	authHandler := handlers.NewAuthHandler(cfg)
 	booksHandler := handlers.NewBooksHandler(cfg)
 	userBooksHandler := handlers.NewUserBooksHandler(cfg)
+	backupHandler := handlers.NewBackupHandler(cfg)
 
 	// Public routes
 	app.Get("/", handlers.Dashboard)
@@ -68,8 +107,33 @@ func main() {
 	app.Get("/api/events", handlers.GetLatestEvents)
 	app.Get("/api/events/recent", handlers.GetRecentEvents)
 	app.Get("/api/events/user/:username", handlers.GetUserEvents)
-	app.Get("/u/:username", handlers.UserPage)
+	app.Get("/feed.:format", handlers.ActivityFeed)
+	app.Get("/.well-known/webfinger", handlers.WebFinger)
+	app.Get("/u/:username", handlers.ActivityPubActor, handlers.UserPage)
+	app.Get("/u/:username/outbox", handlers.ActivityPubOutbox)
+	app.Get("/u/:username/outbox/:event_id", handlers.ActivityPubActivity)
+	app.Get("/u/:username/followers", handlers.ActivityPubFollowers)
+	app.Post("/u/:username/inbox", handlers.ActivityPubInbox)
+	app.Get("/u/:username/feed.:format", handlers.UserActivityFeed)
 	app.Get("/u/:username/shelf/:shelf", handlers.GetPublicShelfBooks)
+	app.Get("/u/:username/shelf/:shelf/feed.:format", handlers.ShelfActivityFeed)
+	app.Get("/u/:username/stats", handlers.UserStatsPage)
+	app.Get("/u/:username/stats.json", handlers.UserStatsJSON)
+	app.Get("/u/:username/activity/:date", handlers.ActivityDay)
+	app.Get("/u/:username/year/:year", handlers.YearReviewPage)
+	app.Get("/u/:username/year/:year/share.png", handlers.YearReviewImage)
+	app.Get("/u/:username/opds", handlers.OPDSCatalog)
+	app.Get("/u/:username/opds/:shelf", handlers.OPDSShelf)
+	app.Get("/u/:username/opds2", handlers.OPDS2Catalog)
+	app.Get("/u/:username/opds2/:shelf", handlers.OPDS2Shelf)
+	app.Post("/u/:username/follow", middleware.UserAuth, handlers.FollowUser)
+	app.Post("/u/:username/unfollow", middleware.UserAuth, handlers.UnfollowUser)
+	app.Get("/feed/following", middleware.UserAuth, handlers.FollowingFeedPage)
+	app.Get("/events/:event_id", handlers.EventPage)
+	app.Post("/events/:event_id/react", middleware.UserAuth, handlers.ReactToEvent)
+	app.Post("/events/:event_id/comments", middleware.UserAuth, handlers.AddEventComment)
+	app.Post("/events/:event_id/comments/:comment_id/delete", middleware.UserAuth, handlers.DeleteEventComment)
+	app.Get("/notifications", middleware.UserAuth, handlers.NotificationsPage)
 
 	// User auth routes (with rate limiting on login)
 	app.Get("/login", handlers.UserLoginPage)
@@ -83,6 +147,10 @@ func main() {
 	app.Post("/profile/avatar", middleware.UserAuth, handlers.UploadAvatar)
 	app.Post("/profile/avatar/remove", middleware.UserAuth, handlers.RemoveAvatar)
 	app.Post("/profile/theme", middleware.UserAuth, handlers.UpdateTheme)
+	app.Post("/profile/catalog", middleware.UserAuth, handlers.UpdateCatalogVisibility)
+	app.Post("/profile/export", middleware.UserAuth, handlers.RequestDataExport)
+	app.Get("/profile/exports", middleware.UserAuth, handlers.DataExportsList)
+	app.Get("/profile/export/:export_id/download", middleware.UserAuth, handlers.DownloadDataExport)
 
 	// User routes (protected) - my books
 	myBooks := app.Group("/my-books", middleware.UserAuth)
@@ -90,10 +158,44 @@ func main() {
 	myBooks.Get("/search", userBooksHandler.SearchBooks)
 	myBooks.Get("/add", userBooksHandler.AddBookPage)
 	myBooks.Get("/shelf/:shelf", userBooksHandler.GetShelfBooks)
+	myBooks.Get("/quotes", userBooksHandler.QuotesPage)
+	myBooks.Get("/quotes/import", userBooksHandler.ClippingsPage)
+	myBooks.Post("/quotes/import", userBooksHandler.ImportClippings)
+	myBooks.Post("/quotes/import/:pending_id/save", userBooksHandler.AssignClippings)
+	myBooks.Post("/quotes/import/:pending_id/discard", userBooksHandler.DiscardClippings)
+	myBooks.Get("/stats", userBooksHandler.StatsPage)
+	myBooks.Get("/stats.json", userBooksHandler.StatsJSON)
+	myBooks.Get("/export", userBooksHandler.ExportLibrary)
+	myBooks.Get("/import", userBooksHandler.ImportPage)
+	myBooks.Post("/import", userBooksHandler.StartImport)
+	myBooks.Get("/import/:job_id", userBooksHandler.ImportJobPage)
+	myBooks.Get("/import/:job_id/progress", userBooksHandler.ImportProgress)
+	myBooks.Get("/import/:job_id/report.csv", userBooksHandler.ImportReport)
+	myBooks.Get("/import/:job_id/rows/:row_id/candidates", userBooksHandler.ImportCandidates)
+	myBooks.Get("/import/:job_id/rows/:row_id/cover", userBooksHandler.ImportCover)
+	myBooks.Post("/import/:job_id/rows/:row_id/match", userBooksHandler.MatchImportRow)
+	myBooks.Post("/import/:job_id/rows/:row_id/skip", userBooksHandler.SkipImportRow)
+	myBooks.Post("/import/:job_id/mapping", userBooksHandler.MapImportColumns)
+	myBooks.Post("/import/:job_id/confirm", userBooksHandler.ConfirmImport)
+	myBooks.Post("/import/:job_id/delete", userBooksHandler.DeleteImport)
+	myBooks.Post("/quotes/:quote_id/public", userBooksHandler.SetQuotePublic)
+	myBooks.Post("/quotes/:quote_id/delete", userBooksHandler.DeleteQuote)
 	myBooks.Post("/", userBooksHandler.AddBook)
+	myBooks.Post("/queue", userBooksHandler.ReorderQueue)
+	myBooks.Post("/bulk", userBooksHandler.BulkUpdate)
+	myBooks.Post("/goal", userBooksHandler.SaveGoal)
 	myBooks.Post("/:book_id", userBooksHandler.UpdateBook)
 	myBooks.Post("/:book_id/dates", userBooksHandler.UpdateBookDates)
+	myBooks.Post("/:book_id/ownership", userBooksHandler.UpdateOwnership)
+	myBooks.Post("/:book_id/queue/top", userBooksHandler.MoveToTopOfQueue)
+	myBooks.Post("/:book_id/tags", userBooksHandler.UpdateTags)
 	myBooks.Post("/:book_id/delete", userBooksHandler.RemoveBook)
+	myBooks.Get("/:book_id/review", userBooksHandler.ReviewPage)
+	myBooks.Post("/:book_id/review", userBooksHandler.SaveReview)
+	myBooks.Post("/:book_id/review/preview", userBooksHandler.PreviewReview)
+	myBooks.Get("/:book_id/notes", userBooksHandler.NotesPage)
+	myBooks.Post("/:book_id/notes", userBooksHandler.SaveNotes)
+	myBooks.Post("/:book_id/quotes", userBooksHandler.AddQuote)
 
 	// Admin auth routes (with rate limiting on login)
 	app.Get("/admin/login", authHandler.LoginPage)
@@ -110,6 +212,13 @@ func main() {
 	admin.Post("/users/:id/delete", handlers.AdminDeleteUser)
 	admin.Post("/users/:id/password", handlers.AdminSetPassword)
 	admin.Post("/users/:id/password/clear", handlers.AdminClearPassword)
+	admin.Post("/users/:id/export", handlers.AdminRequestDataExport)
+	admin.Get("/users/:id/exports/:export_id/download", handlers.AdminDownloadDataExport)
+	admin.Get("/comments", handlers.AdminCommentsPage)
+	admin.Post("/comments/:id/remove", handlers.AdminRemoveComment)
+	admin.Get("/backups", backupHandler.BackupsPage)
+	admin.Post("/backups", backupHandler.CreateBackup)
+	admin.Get("/backups/:name", backupHandler.DownloadBackup)
 	admin.Get("/users/:id/books", booksHandler.ManageUserBooks)
 	admin.Get("/users/:id/books/search", booksHandler.SearchBooks)
 	admin.Post("/users/:id/books", booksHandler.AddBook)
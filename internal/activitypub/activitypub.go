@@ -0,0 +1,227 @@
+// Package activitypub makes each user a federated ActivityPub actor. Remote
+// servers find users through WebFinger, read their actor document and the
+// outbox of their reading events, and follow them through their inbox; new
+// events are then delivered to followers' inboxes. Requests both ways are
+// signed with HTTP signatures using a key pair kept for each user.
+//
+// Federation needs a stable public address to name actors by, so it is off
+// until one is set with SetBaseURL.
+package activitypub
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+// ContentType is the media type of ActivityPub documents
+const ContentType = "application/activity+json"
+
+// Public addresses an activity to everyone
+const Public = "https://www.w3.org/ns/activitystreams#Public"
+
+const activityStreams = "https://www.w3.org/ns/activitystreams"
+
+// baseURL is the instance's public address, see SetBaseURL
+var baseURL string
+
+// SetBaseURL sets the public address actors are named by, such as
+// https://books.example.com, turning federation on
+func SetBaseURL(url string) {
+	baseURL = strings.TrimSuffix(url, "/")
+}
+
+// Enabled reports whether federation is on
+func Enabled() bool {
+	return baseURL != ""
+}
+
+// Host returns the host of the instance's public address, as used in
+// WebFinger account names
+func Host() string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// ActorID returns the ID of a user's actor, which is also their public page
+func ActorID(username string) string {
+	return baseURL + "/u/" + username
+}
+
+// keyID names the key a user's requests are signed with
+func keyID(username string) string {
+	return ActorID(username) + "#main-key"
+}
+
+// Actor is a user's actor document
+type Actor struct {
+	Context           []string  `json:"@context"`
+	ID                string    `json:"id"`
+	Type              string    `json:"type"`
+	PreferredUsername string    `json:"preferredUsername"`
+	Name              string    `json:"name"`
+	Summary           string    `json:"summary,omitempty"`
+	URL               string    `json:"url"`
+	Inbox             string    `json:"inbox"`
+	Outbox            string    `json:"outbox"`
+	Followers         string    `json:"followers"`
+	Icon              *Image    `json:"icon,omitempty"`
+	Published         string    `json:"published"`
+	PublicKey         PublicKey `json:"publicKey"`
+}
+
+// PublicKey is the key an actor's requests are verified with
+type PublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+// Image is a picture such as an avatar or a book cover
+type Image struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// NewActor returns the actor document of a user
+func NewActor(user *models.User) (*Actor, error) {
+	key, err := userKey(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	id := ActorID(user.Username)
+	return &Actor{
+		Context:           []string{activityStreams, "https://w3id.org/security/v1"},
+		ID:                id,
+		Type:              "Person",
+		PreferredUsername: user.Username,
+		Name:              user.DisplayName,
+		Summary:           user.Description,
+		URL:               id,
+		Inbox:             id + "/inbox",
+		Outbox:            id + "/outbox",
+		Followers:         id + "/followers",
+		Icon:              &Image{Type: "Image", URL: baseURL + user.GetProfilePictureURL()},
+		Published:         user.CreatedAt.UTC().Format(time.RFC3339),
+		PublicKey: PublicKey{
+			ID:           keyID(user.Username),
+			Owner:        id,
+			PublicKeyPem: key.PublicKey,
+		},
+	}, nil
+}
+
+// Collection is an ordered collection, or a page of one
+type Collection struct {
+	Context      string        `json:"@context,omitempty"`
+	ID           string        `json:"id"`
+	Type         string        `json:"type"`
+	TotalItems   *int          `json:"totalItems,omitempty"`
+	First        string        `json:"first,omitempty"`
+	PartOf       string        `json:"partOf,omitempty"`
+	Next         string        `json:"next,omitempty"`
+	OrderedItems []interface{} `json:"orderedItems,omitempty"`
+}
+
+// NewCollection returns an ordered collection of total items whose first page
+// is at first, if any
+func NewCollection(id string, total int, first string) *Collection {
+	return &Collection{Context: activityStreams, ID: id, Type: "OrderedCollection", TotalItems: &total, First: first}
+}
+
+// NewCollectionPage returns an empty page of the collection partOf
+func NewCollectionPage(id, partOf string) *Collection {
+	return &Collection{Context: activityStreams, ID: id, Type: "OrderedCollectionPage", PartOf: partOf}
+}
+
+// Activity is an activity about a book, translated from an event
+type Activity struct {
+	Context   string      `json:"@context,omitempty"`
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Actor     string      `json:"actor"`
+	Published string      `json:"published"`
+	To        []string    `json:"to"`
+	Cc        []string    `json:"cc,omitempty"`
+	Summary   string      `json:"summary"`
+	Object    interface{} `json:"object"`
+	Target    *Shelf      `json:"target,omitempty"`
+	Origin    *Shelf      `json:"origin,omitempty"`
+}
+
+// Book describes a book in activities
+type Book struct {
+	Type   string   `json:"type"`
+	Name   string   `json:"name"`
+	Author []string `json:"author,omitempty"`
+	ISBN13 string   `json:"isbn13,omitempty"`
+	ISBN10 string   `json:"isbn10,omitempty"`
+	URL    string   `json:"url,omitempty"`
+	Image  *Image   `json:"image,omitempty"`
+}
+
+// Shelf is the collection a book is added to or removed from
+type Shelf struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// NewActivity translates an event about a book into an activity: adding the
+// book to a shelf, removing it, or updating how its reading is going. Events
+// without a book have no activity and return nil.
+func NewActivity(ev models.Event) *Activity {
+	if ev.Book == nil || ev.User == nil {
+		return nil
+	}
+	actor := ActorID(ev.User.Username)
+	a := &Activity{
+		Context:   activityStreams,
+		ID:        ActivityID(ev.User.Username, ev.ID),
+		Actor:     actor,
+		Published: ev.CreatedAt.UTC().Format(time.RFC3339),
+		To:        []string{Public},
+		Cc:        []string{actor + "/followers"},
+		Summary:   ev.User.DisplayName + " " + ev.EventDescription(),
+		Object:    newBook(ev.Book),
+	}
+	switch ev.EventType {
+	case models.EventBookAdded:
+		a.Type = "Add"
+		a.Target = &Shelf{Type: "Collection", Name: ev.ShelfDisplay()}
+	case models.EventBookMoved:
+		a.Type = "Add"
+		a.Target = &Shelf{Type: "Collection", Name: ev.NewValueDisplay()}
+		a.Origin = &Shelf{Type: "Collection", Name: ev.OldValueDisplay()}
+	case models.EventBookRemoved:
+		a.Type = "Remove"
+		a.Origin = &Shelf{Type: "Collection", Name: ev.ShelfDisplay()}
+	default:
+		a.Type = "Update"
+	}
+	return a
+}
+
+// ActivityID returns the ID of the activity translated from an event
+func ActivityID(username string, eventID int64) string {
+	return ActorID(username) + "/outbox/" + strconv.FormatInt(eventID, 10)
+}
+
+func newBook(b *models.Book) Book {
+	book := Book{Type: "Book", Name: b.Title, ISBN13: b.ISBN13.String, ISBN10: b.ISBN10.String}
+	for _, name := range strings.Split(b.Authors, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			book.Author = append(book.Author, name)
+		}
+	}
+	if b.GoogleBooksID != "" {
+		book.URL = "https://books.google.com/books?id=" + b.GoogleBooksID
+		book.Image = &Image{Type: "Image", URL: baseURL + "/api/images/book/" + b.GoogleBooksID}
+	}
+	return book
+}
@@ -0,0 +1,282 @@
+package activitypub
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+// deliveryInterval is how often new events are picked up for remote followers
+const deliveryInterval = 30 * time.Second
+
+// deliveryBatch limits how many events are picked up in one round
+const deliveryBatch = 100
+
+// deliveryWorkers is how many inboxes are delivered to at once, so a slow
+// server only holds up its own followers
+const deliveryWorkers = 4
+
+// maxQueuedActivities limits what is kept for an inbox that can't be
+// reached; the oldest activities are dropped beyond it
+const maxQueuedActivities = 200
+
+// Inboxes that fail are retried after a delay doubling from minRetryDelay up
+// to maxRetryDelay, and their followers dropped after maxInboxFailures
+// failures in a row, about a week of trying
+const (
+	minRetryDelay    = time.Minute
+	maxRetryDelay    = 12 * time.Hour
+	maxInboxFailures = 20
+)
+
+// delivery is an activity to send to an inbox, signed as its user. id is its
+// row in the outbox, removed once it is sent.
+type delivery struct {
+	id       int64
+	userID   int64
+	username string
+	activity json.RawMessage
+}
+
+// inboxQueue holds the activities waiting for one inbox
+type inboxQueue struct {
+	pending  []delivery
+	busy     bool
+	failures int
+	retryAt  time.Time
+}
+
+// deliveryResult is how far a worker got through an inbox's activities
+type deliveryResult struct {
+	inbox string
+	sent  int
+	err   error
+}
+
+// deliverer sends activities to remote inboxes. Its state is only touched
+// by the goroutine started in StartDelivery; workers report back on results.
+type deliverer struct {
+	queues  map[string]*inboxQueue
+	active  int
+	results chan deliveryResult
+}
+
+// StartDelivery sends new events to remote followers in the background.
+// Activities are kept in the database until sent, so after a restart
+// delivery carries on with what was still waiting. Each inbox gets its
+// activities in order; one that can't be reached is retried with backoff,
+// and while it fails only its own queue waits.
+func StartDelivery() {
+	d := &deliverer{
+		queues:  make(map[string]*inboxQueue),
+		results: make(chan deliveryResult),
+	}
+	go d.run()
+}
+
+func (d *deliverer) run() {
+	ticker := time.NewTicker(deliveryInterval)
+	defer ticker.Stop()
+
+	d.loadQueued()
+	d.queueNew()
+	d.dispatch()
+	for {
+		select {
+		case <-ticker.C:
+			d.queueNew()
+		case r := <-d.results:
+			d.finish(r)
+		}
+		d.dispatch()
+	}
+}
+
+// loadQueued picks up the activities still waiting from before a restart
+func (d *deliverer) loadQueued() {
+	queued, err := models.GetOutgoingActivities()
+	if err != nil {
+		log.Printf("ActivityPub: failed to load queued deliveries: %v", err)
+		return
+	}
+	for _, a := range queued {
+		d.enqueue(a.Inbox, delivery{id: a.ID, userID: a.UserID, username: a.Username, activity: json.RawMessage(a.Activity)})
+	}
+}
+
+// queueNew queues the events since the last round for the followers of the
+// users they belong to
+func (d *deliverer) queueNew() {
+	if err := d.queueEvents(); err != nil {
+		log.Printf("ActivityPub delivery failed: %v", err)
+	}
+}
+
+func (d *deliverer) queueEvents() error {
+	last, err := models.GetLastDeliveredEvent()
+	if err != nil {
+		return err
+	}
+	for {
+		events, err := models.GetEventsAfter(last, deliveryBatch)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		followers := make(map[int64][]string)
+		var outgoing []models.OutgoingActivity
+		for _, ev := range events {
+			last = ev.ID
+			activity := NewActivity(ev)
+			if activity == nil {
+				continue
+			}
+			inboxes, found := followers[ev.UserID]
+			if !found {
+				if inboxes, err = followerInboxes(ev.UserID); err != nil {
+					return err
+				}
+				followers[ev.UserID] = inboxes
+			}
+			if len(inboxes) == 0 {
+				continue
+			}
+			data, err := json.Marshal(activity)
+			if err != nil {
+				return err
+			}
+			for _, inbox := range inboxes {
+				outgoing = append(outgoing, models.OutgoingActivity{
+					Inbox:    inbox,
+					UserID:   ev.UserID,
+					Username: ev.User.Username,
+					Activity: string(data),
+				})
+			}
+		}
+
+		// The activities are stored as the cursor moves past their events,
+		// and only queued in memory once stored
+		queued, err := models.QueueActivities(outgoing, last)
+		if err != nil {
+			return err
+		}
+		for _, a := range queued {
+			d.enqueue(a.Inbox, delivery{id: a.ID, userID: a.UserID, username: a.Username, activity: json.RawMessage(a.Activity)})
+		}
+	}
+}
+
+func (d *deliverer) enqueue(inbox string, item delivery) {
+	q, found := d.queues[inbox]
+	if !found {
+		q = &inboxQueue{}
+		d.queues[inbox] = q
+	}
+	q.pending = append(q.pending, item)
+	// A worker may be sending the front of the queue, so the oldest
+	// activity is only dropped while none is
+	if len(q.pending) > maxQueuedActivities && !q.busy {
+		log.Printf("ActivityPub delivery to %s is behind; dropping oldest activity", inbox)
+		if err := models.DeleteOutgoingActivities([]int64{q.pending[0].id}); err != nil {
+			log.Printf("ActivityPub: failed to drop queued delivery to %s: %v", inbox, err)
+		}
+		q.pending = q.pending[1:]
+	}
+}
+
+// dispatch starts workers on the inboxes with activities waiting, as many
+// as deliveryWorkers allows
+func (d *deliverer) dispatch() {
+	now := time.Now()
+	for inbox, q := range d.queues {
+		if d.active >= deliveryWorkers {
+			return
+		}
+		if q.busy || len(q.pending) == 0 || now.Before(q.retryAt) {
+			continue
+		}
+		q.busy = true
+		d.active++
+		go d.send(inbox, append([]delivery(nil), q.pending...))
+	}
+}
+
+// send delivers activities to an inbox in order, stopping at the first
+// failure so the rest wait for the retry
+func (d *deliverer) send(inbox string, items []delivery) {
+	r := deliveryResult{inbox: inbox}
+	for _, item := range items {
+		if r.err = post(item.userID, item.username, inbox, item.activity); r.err != nil {
+			break
+		}
+		r.sent++
+	}
+	d.results <- r
+}
+
+// finish records a worker's progress, backing off from an inbox that
+// failed and giving up on its followers once it keeps failing
+func (d *deliverer) finish(r deliveryResult) {
+	d.active--
+	q := d.queues[r.inbox]
+	q.busy = false
+	if r.sent > 0 {
+		sent := make([]int64, r.sent)
+		for i, item := range q.pending[:r.sent] {
+			sent[i] = item.id
+		}
+		// Failing here only means these are sent again after a restart
+		if err := models.DeleteOutgoingActivities(sent); err != nil {
+			log.Printf("ActivityPub: failed to clear delivered activities for %s: %v", r.inbox, err)
+		}
+	}
+	q.pending = q.pending[r.sent:]
+
+	if r.err == nil {
+		q.failures = 0
+		if len(q.pending) == 0 {
+			delete(d.queues, r.inbox)
+		}
+		return
+	}
+
+	q.failures++
+	if q.failures >= maxInboxFailures {
+		log.Printf("ActivityPub delivery to %s failed %d times; removing its followers: %v", r.inbox, q.failures, r.err)
+		if err := models.RemoveRemoteFollowersByInbox(r.inbox); err != nil {
+			log.Printf("ActivityPub: failed to remove followers at %s: %v", r.inbox, err)
+		}
+		if err := models.DeleteOutgoingActivitiesForInbox(r.inbox); err != nil {
+			log.Printf("ActivityPub: failed to drop queued deliveries to %s: %v", r.inbox, err)
+		}
+		delete(d.queues, r.inbox)
+		return
+	}
+	delay := min(minRetryDelay<<(q.failures-1), maxRetryDelay)
+	q.retryAt = time.Now().Add(delay)
+	log.Printf("ActivityPub delivery to %s failed, retrying in %s: %v", r.inbox, delay, r.err)
+}
+
+// followerInboxes returns the inboxes a user's activities go to, sending to
+// a shared inbox once for all followers on a server
+func followerInboxes(userID int64) ([]string, error) {
+	followers, err := models.GetRemoteFollowers(userID)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var inboxes []string
+	for _, f := range followers {
+		if !seen[f.Inbox] {
+			seen[f.Inbox] = true
+			inboxes = append(inboxes, f.Inbox)
+		}
+	}
+	return inboxes, nil
+}
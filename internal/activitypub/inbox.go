@@ -0,0 +1,88 @@
+package activitypub
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+// ErrWrongActor is returned for activities not sent by the actor who signed them
+var ErrWrongActor = errors.New("activity actor does not match signature")
+
+// incoming is an activity received in an inbox. Its object is either a
+// reference or, for Undo, the activity being undone.
+type incoming struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor"`
+	Object json.RawMessage `json:"object"`
+}
+
+// objectID returns the ID of an activity's object, however it is given
+func (a incoming) objectID() string {
+	var id string
+	if json.Unmarshal(a.Object, &id) == nil {
+		return id
+	}
+	var object struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(a.Object, &object)
+	return object.ID
+}
+
+// HandleInbox acts on an activity sent to a user's inbox by a verified
+// remote actor. Follows are accepted at once and undoing one unfollows;
+// anything else is ignored.
+func HandleInbox(user *models.User, sender *RemoteActor, body []byte) error {
+	var activity incoming
+	if err := json.Unmarshal(body, &activity); err != nil {
+		return err
+	}
+	if activity.Actor != sender.ID {
+		return ErrWrongActor
+	}
+
+	switch activity.Type {
+	case "Follow":
+		if activity.objectID() != ActorID(user.Username) {
+			return nil
+		}
+		inbox, err := followerInbox(sender)
+		if err != nil {
+			return err
+		}
+		if err := models.AddRemoteFollower(user.ID, sender.ID, inbox); err != nil {
+			return err
+		}
+		go acceptFollow(user, sender, inbox, body)
+	case "Undo":
+		var undone incoming
+		if err := json.Unmarshal(activity.Object, &undone); err != nil {
+			return nil
+		}
+		if undone.Type == "Follow" && undone.objectID() == ActorID(user.Username) {
+			return models.RemoveRemoteFollower(user.ID, sender.ID)
+		}
+	}
+	return nil
+}
+
+// acceptFollow tells a new follower their follow was accepted
+func acceptFollow(user *models.User, follower *RemoteActor, inbox string, follow json.RawMessage) {
+	actor := ActorID(user.Username)
+	accept := struct {
+		Context string          `json:"@context"`
+		ID      string          `json:"id"`
+		Type    string          `json:"type"`
+		Actor   string          `json:"actor"`
+		Object  json.RawMessage `json:"object"`
+	}{activityStreams, actor + "#accepts/" + url.QueryEscape(follower.ID), "Accept", actor, follow}
+
+	if err := post(user.ID, user.Username, inbox, accept); err != nil {
+		log.Printf("ActivityPub accept to %s failed: %v", inbox, err)
+	}
+}
@@ -0,0 +1,53 @@
+package activitypub
+
+import (
+	"testing"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+func TestHandleInboxWrongActor(t *testing.T) {
+	SetBaseURL("https://spines.example")
+	defer SetBaseURL("")
+
+	user := &models.User{ID: 1, Username: "alice"}
+	sender := &RemoteActor{ID: "https://remote.example/users/bob", Inbox: "https://remote.example/users/bob/inbox"}
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr error
+	}{
+		{
+			name:    "follow on behalf of another actor",
+			body:    `{"type":"Follow","actor":"https://remote.example/users/carol","object":"https://spines.example/u/alice"}`,
+			wantErr: ErrWrongActor,
+		},
+		{
+			name:    "undo on behalf of another actor",
+			body:    `{"type":"Undo","actor":"https://remote.example/users/carol","object":{"type":"Follow","object":"https://spines.example/u/alice"}}`,
+			wantErr: ErrWrongActor,
+		},
+		{
+			name:    "no actor",
+			body:    `{"type":"Follow","object":"https://spines.example/u/alice"}`,
+			wantErr: ErrWrongActor,
+		},
+		{
+			name: "follow of another user is ignored",
+			body: `{"type":"Follow","actor":"https://remote.example/users/bob","object":"https://spines.example/u/carol"}`,
+		},
+		{
+			name: "other activities are ignored",
+			body: `{"type":"Like","actor":"https://remote.example/users/bob","object":"https://spines.example/u/alice"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := HandleInbox(user, sender, []byte(tt.body)); err != tt.wantErr {
+				t.Errorf("HandleInbox() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
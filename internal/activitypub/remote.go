@@ -0,0 +1,256 @@
+package activitypub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nuuner/spines/internal/cache"
+	"github.com/nuuner/spines/internal/middleware"
+)
+
+// maxDocumentSize limits documents read from other servers
+const maxDocumentSize = 1 << 20
+
+// refetchAfter is how old a cached actor must be before a failed signature
+// check fetches it again, so bad requests can't make Spines fetch on demand
+const refetchAfter = 5 * time.Minute
+
+// allowLocal lets federation use plain http and private addresses, see
+// SetAllowLocal
+var allowLocal bool
+
+// SetAllowLocal lets federation reach servers over plain http and on
+// private, loopback and link-local addresses, which is only wanted when
+// testing instances on one machine or network
+func SetAllowLocal(allow bool) {
+	allowLocal = allow
+}
+
+// errForbiddenAddress is returned for requests to addresses other servers
+// could use to reach into the instance's own network
+var errForbiddenAddress = errors.New("address not allowed for federation")
+
+// client talks to other servers. Anyone can make Spines fetch a URL by
+// naming it as a key or inbox, so it only connects to public addresses,
+// checked after DNS resolution, and checks every redirect the same way.
+var client = &http.Client{
+	Timeout: 15 * time.Second,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: checkDialAddress,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		return checkURL(req.URL.String())
+	},
+}
+
+// checkDialAddress refuses connections to non-public addresses
+func checkDialAddress(network, address string, _ syscall.RawConn) error {
+	if allowLocal {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return errForbiddenAddress
+	}
+	return nil
+}
+
+// checkURL checks a URL is one Spines may send requests to: https, or http
+// when local federation is allowed, and not naming a non-public address
+func checkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" || u.User != nil || (u.Scheme != "https" && !(allowLocal && u.Scheme == "http")) {
+		return fmt.Errorf("invalid federation URL %q", raw)
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		return checkDialAddress("tcp", net.JoinHostPort(ip.String(), "443"), nil)
+	}
+	return nil
+}
+
+// sameHost reports whether two URLs are on the same host
+func sameHost(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	return errA == nil && errB == nil && ua.Host != "" && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// actorCache keeps remote actors for an hour, so every request from a server
+// doesn't refetch its key
+var actorCache = cache.New(1 * time.Hour)
+
+// failedActorCache remembers actors that couldn't be fetched, so requests
+// naming them don't make Spines fetch them again until refetchAfter
+var failedActorCache = cache.New(refetchAfter)
+
+// actorFetchLimiter limits how often actors are fetched from any one host,
+// as anyone can name new actors there in signed requests
+var actorFetchLimiter = middleware.NewRateLimiter(time.Minute, 30)
+
+// errFetchLimited is returned when a host's actors were fetched too often
+var errFetchLimited = errors.New("too many actor fetches from this host")
+
+// errKeyNotOwned is returned for keys their actor's document doesn't publish
+var errKeyNotOwned = errors.New("key not published by its actor")
+
+// cachedActor is a remote actor and when it was fetched
+type cachedActor struct {
+	actor   *RemoteActor
+	fetched time.Time
+}
+
+// RemoteActor is an actor on another server, as far as Spines needs it
+type RemoteActor struct {
+	ID                string    `json:"id"`
+	Type              string    `json:"type"`
+	PreferredUsername string    `json:"preferredUsername"`
+	Inbox             string    `json:"inbox"`
+	PublicKey         PublicKey `json:"publicKey"`
+	Endpoints         struct {
+		SharedInbox string `json:"sharedInbox"`
+	} `json:"endpoints"`
+}
+
+// fetchActor returns a remote actor, from the cache unless refresh is set
+// and the cached copy is old enough to fetch again. A failed fetch is
+// remembered and returned again until it is old enough to retry.
+func fetchActor(id string, refresh bool) (*RemoteActor, error) {
+	if cached, found := actorCache.Get(id); found {
+		entry := cached.(cachedActor)
+		if !refresh || time.Since(entry.fetched) < refetchAfter {
+			return entry.actor, nil
+		}
+	}
+	if failed, found := failedActorCache.Get(id); found {
+		return nil, failed.(error)
+	}
+
+	if err := checkURL(id); err != nil {
+		return nil, err
+	}
+	u, _ := url.Parse(id)
+	if !actorFetchLimiter.IsAllowed(strings.ToLower(u.Host)) {
+		return nil, errFetchLimited
+	}
+
+	actor, err := requestActor(id)
+	if err != nil {
+		failedActorCache.Set(id, err)
+		return nil, err
+	}
+	actorCache.Set(id, cachedActor{actor: actor, fetched: time.Now()})
+	return actor, nil
+}
+
+// requestActor fetches an actor document from its server
+func requestActor(id string) (*RemoteActor, error) {
+	req, err := http.NewRequest("GET", id, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", ContentType+`, application/ld+json; profile="https://www.w3.org/ns/activitystreams"`)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching actor %s: %s", id, resp.Status)
+	}
+
+	var actor RemoteActor
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDocumentSize)).Decode(&actor); err != nil {
+		return nil, fmt.Errorf("reading actor %s: %w", id, err)
+	}
+	if actor.ID != id || actor.Inbox == "" {
+		return nil, fmt.Errorf("invalid actor document at %s", id)
+	}
+	return &actor, nil
+}
+
+// followerInbox returns the inbox a remote follower's activities go to,
+// preferring their server's shared inbox. Inboxes must be on the actor's own
+// server, so an actor can't point Spines at someone else's.
+func followerInbox(actor *RemoteActor) (string, error) {
+	for _, inbox := range []string{actor.Endpoints.SharedInbox, actor.Inbox} {
+		if inbox != "" && sameHost(inbox, actor.ID) && checkURL(inbox) == nil {
+			return inbox, nil
+		}
+	}
+	return "", fmt.Errorf("actor %s has no usable inbox", actor.ID)
+}
+
+// keyActor returns the actor owning a key; Spines only trusts keys published
+// in their owner's actor document
+func keyActor(keyID string, refresh bool) (*RemoteActor, error) {
+	actorID, _, _ := strings.Cut(keyID, "#")
+	actor, err := fetchActor(actorID, refresh)
+	if err != nil {
+		return nil, err
+	}
+	if actor.PublicKey.ID != keyID || actor.PublicKey.Owner != actor.ID {
+		return nil, errKeyNotOwned
+	}
+	return actor, nil
+}
+
+// post sends an activity to an inbox, signed as the given user
+func post(userID int64, username, inbox string, activity interface{}) error {
+	if err := checkURL(inbox); err != nil {
+		return err
+	}
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	key, err := privateKey(userID)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", inbox, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	if err := sign(req, body, keyID(username), key); err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxDocumentSize))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("delivering to %s: %s", inbox, resp.Status)
+	}
+	return nil
+}
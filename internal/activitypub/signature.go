@@ -0,0 +1,170 @@
+package activitypub
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTP signatures follow draft-cavage-http-signatures-12, as other
+// ActivityPub servers do, using RSA with SHA-256
+
+// maxClockSkew is how far a signed request's Date may be from now
+const maxClockSkew = 12 * time.Hour
+
+// ErrBadSignature is returned for requests whose signature doesn't check out
+var ErrBadSignature = errors.New("invalid HTTP signature")
+
+// sign adds Date, Digest and Signature headers to an outgoing request
+func sign(req *http.Request, body []byte, keyID string, key *rsa.PrivateKey) error {
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Digest", digest(body))
+
+	headers := []string{"(request-target)", "host", "date", "digest"}
+	signed := signingString(strings.ToLower(req.Method), req.URL.RequestURI(), headers, func(name string) string {
+		if name == "host" {
+			return req.URL.Host
+		}
+		return req.Header.Get(name)
+	})
+	hashed := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Signature", `keyId="`+keyID+`",algorithm="rsa-sha256",headers="`+strings.Join(headers, " ")+
+		`",signature="`+base64.StdEncoding.EncodeToString(signature)+`"`)
+	return nil
+}
+
+// digest returns the Digest header value of a body
+func digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// signingString builds the text a signature covers from the named headers
+func signingString(method, target string, headers []string, header func(name string) string) string {
+	lines := make([]string, len(headers))
+	for i, name := range headers {
+		if name == "(request-target)" {
+			lines[i] = name + ": " + method + " " + target
+		} else {
+			lines[i] = name + ": " + header(name)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SignedRequest is an incoming request whose signature is to be checked
+type SignedRequest struct {
+	Method string
+	// Path and query, as requested
+	Target string
+	Header func(name string) string
+	Body   []byte
+}
+
+// Verify checks a request's signature with the signer's public key, fetched
+// from their server, and returns the signer. The signature must cover the
+// request target, host and date, and for a body also its digest.
+func Verify(r SignedRequest) (*RemoteActor, error) {
+	params := parseSignatureHeader(r.Header("Signature"))
+	keyID, signature := params["keyId"], params["signature"]
+	if keyID == "" || signature == "" {
+		return nil, ErrBadSignature
+	}
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, ErrBadSignature
+	}
+	if alg := params["algorithm"]; alg != "" && alg != "rsa-sha256" && alg != "hs2019" {
+		return nil, ErrBadSignature
+	}
+
+	headers := strings.Fields(strings.ToLower(params["headers"]))
+	covered := make(map[string]bool, len(headers))
+	for _, h := range headers {
+		covered[h] = true
+	}
+	if !covered["(request-target)"] || !covered["host"] || !covered["date"] || (len(r.Body) > 0 && !covered["digest"]) {
+		return nil, ErrBadSignature
+	}
+	date, err := http.ParseTime(r.Header("Date"))
+	if err != nil || time.Since(date) > maxClockSkew || time.Until(date) > maxClockSkew {
+		return nil, ErrBadSignature
+	}
+	if covered["digest"] && !digestMatches(r.Header("Digest"), r.Body) {
+		return nil, ErrBadSignature
+	}
+
+	signed := signingString(strings.ToLower(r.Method), r.Target, headers, r.Header)
+	hashed := sha256.Sum256([]byte(signed))
+
+	// A cached key may have been replaced since, so a failure is retried
+	// with the actor fetched again, unless it was fetched only recently
+	var tried *RemoteActor
+	for _, refresh := range []bool{false, true} {
+		actor, err := keyActor(keyID, refresh)
+		if err != nil {
+			return nil, err
+		}
+		if actor == tried {
+			break
+		}
+		tried = actor
+		key, err := parsePublicKey(actor.PublicKey.PublicKeyPem)
+		if err != nil {
+			return nil, err
+		}
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], decoded) == nil {
+			return actor, nil
+		}
+	}
+	return nil, ErrBadSignature
+}
+
+// digestMatches checks a Digest header, which may list several digests, for
+// the body's SHA-256
+func digestMatches(header string, body []byte) bool {
+	want := digest(body)
+	for _, d := range strings.Split(header, ",") {
+		algorithm, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+		if strings.EqualFold(algorithm, "SHA-256") && "SHA-256="+value == want {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSignatureHeader reads the key="value" pairs of a Signature header
+func parseSignatureHeader(header string) map[string]string {
+	params := make(map[string]string)
+	for header != "" {
+		var pair string
+		// Values are quoted and may contain commas
+		key, rest, found := strings.Cut(header, "=")
+		if !found {
+			break
+		}
+		key = strings.TrimSpace(key)
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				break
+			}
+			pair, header = rest[1:end+1], rest[end+2:]
+		} else {
+			pair, header, _ = strings.Cut(rest, ",")
+		}
+		params[key] = pair
+		header = strings.TrimPrefix(strings.TrimSpace(header), ",")
+	}
+	return params
+}
@@ -0,0 +1,226 @@
+package activitypub
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestKey generates an RSA key for signing test requests
+func newTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// cacheTestActor puts a remote actor in the cache as if just fetched, so
+// Verify finds their key without a request
+func cacheTestActor(t *testing.T, id, keyID, owner string, key *rsa.PrivateKey) {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actor := &RemoteActor{
+		ID:    id,
+		Type:  "Person",
+		Inbox: id + "/inbox",
+		PublicKey: PublicKey{
+			ID:           keyID,
+			Owner:        owner,
+			PublicKeyPem: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		},
+	}
+	actorCache.Set(id, cachedActor{actor: actor, fetched: time.Now()})
+}
+
+// testRequest describes a request to sign for Verify
+type testRequest struct {
+	method    string
+	body      string
+	headers   []string
+	keyID     string
+	key       *rsa.PrivateKey
+	algorithm string
+	date      time.Time
+	// Body the Digest header is computed from, when not the request's own
+	digestOf *string
+}
+
+// signed builds the request, signing the listed headers
+func (tr testRequest) signed(t *testing.T) SignedRequest {
+	t.Helper()
+	digestOf := tr.body
+	if tr.digestOf != nil {
+		digestOf = *tr.digestOf
+	}
+	values := map[string]string{
+		"host":   "spines.example",
+		"date":   tr.date.UTC().Format(http.TimeFormat),
+		"digest": digest([]byte(digestOf)),
+	}
+	header := func(name string) string { return values[strings.ToLower(name)] }
+
+	const target = "/u/alice/inbox"
+	signed := signingString(strings.ToLower(tr.method), target, tr.headers, header)
+	hashed := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, tr.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	values["signature"] = `keyId="` + tr.keyID + `",algorithm="` + tr.algorithm + `",headers="` +
+		strings.Join(tr.headers, " ") + `",signature="` + base64.StdEncoding.EncodeToString(signature) + `"`
+
+	return SignedRequest{Method: tr.method, Target: target, Header: header, Body: []byte(tr.body)}
+}
+
+func TestVerify(t *testing.T) {
+	const bob = "https://remote.example/users/bob"
+	const mallory = "https://remote.example/users/mallory"
+	bobKey, malloryKey := newTestKey(t), newTestKey(t)
+	cacheTestActor(t, bob, bob+"#main-key", bob, bobKey)
+	// Mallory's document lists a key claiming to be owned by Bob
+	cacheTestActor(t, mallory, mallory+"#main-key", bob, malloryKey)
+
+	other := `{"type":"Undo"}`
+	allHeaders := []string{"(request-target)", "host", "date", "digest"}
+	valid := func(change func(*testRequest)) testRequest {
+		tr := testRequest{
+			method:    "POST",
+			body:      `{"type":"Follow"}`,
+			headers:   allHeaders,
+			keyID:     bob + "#main-key",
+			key:       bobKey,
+			algorithm: "rsa-sha256",
+			date:      time.Now(),
+		}
+		if change != nil {
+			change(&tr)
+		}
+		return tr
+	}
+
+	tests := []struct {
+		name    string
+		req     testRequest
+		wantErr error
+	}{
+		{name: "valid", req: valid(nil)},
+		{name: "hs2019 algorithm", req: valid(func(tr *testRequest) { tr.algorithm = "hs2019" })},
+		{name: "slightly old date", req: valid(func(tr *testRequest) { tr.date = time.Now().Add(-time.Hour) })},
+		{
+			name: "no body needs no digest",
+			req: valid(func(tr *testRequest) {
+				tr.method, tr.body, tr.headers = "GET", "", []string{"(request-target)", "host", "date"}
+			}),
+		},
+		{
+			name:    "request target not signed",
+			req:     valid(func(tr *testRequest) { tr.headers = []string{"host", "date", "digest"} }),
+			wantErr: ErrBadSignature,
+		},
+		{
+			name:    "host not signed",
+			req:     valid(func(tr *testRequest) { tr.headers = []string{"(request-target)", "date", "digest"} }),
+			wantErr: ErrBadSignature,
+		},
+		{
+			name:    "date not signed",
+			req:     valid(func(tr *testRequest) { tr.headers = []string{"(request-target)", "host", "digest"} }),
+			wantErr: ErrBadSignature,
+		},
+		{
+			name:    "digest not signed",
+			req:     valid(func(tr *testRequest) { tr.headers = []string{"(request-target)", "host", "date"} }),
+			wantErr: ErrBadSignature,
+		},
+		{
+			name:    "stale date",
+			req:     valid(func(tr *testRequest) { tr.date = time.Now().Add(-maxClockSkew - time.Minute) }),
+			wantErr: ErrBadSignature,
+		},
+		{
+			name:    "future date",
+			req:     valid(func(tr *testRequest) { tr.date = time.Now().Add(maxClockSkew + time.Minute) }),
+			wantErr: ErrBadSignature,
+		},
+		{
+			name:    "digest of another body",
+			req:     valid(func(tr *testRequest) { tr.digestOf = &other }),
+			wantErr: ErrBadSignature,
+		},
+		{
+			name:    "unsupported algorithm",
+			req:     valid(func(tr *testRequest) { tr.algorithm = "hmac-sha256" }),
+			wantErr: ErrBadSignature,
+		},
+		{
+			name:    "no key ID",
+			req:     valid(func(tr *testRequest) { tr.keyID = "" }),
+			wantErr: ErrBadSignature,
+		},
+		{
+			name:    "signed with another key",
+			req:     valid(func(tr *testRequest) { tr.key = malloryKey }),
+			wantErr: ErrBadSignature,
+		},
+		{
+			name:    "key not the one the actor publishes",
+			req:     valid(func(tr *testRequest) { tr.keyID = bob + "#other-key" }),
+			wantErr: errKeyNotOwned,
+		},
+		{
+			name:    "key owned by another actor",
+			req:     valid(func(tr *testRequest) { tr.keyID, tr.key = mallory+"#main-key", malloryKey }),
+			wantErr: errKeyNotOwned,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actor, err := Verify(tt.req.signed(t))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && actor.ID != bob {
+				t.Errorf("Verify() actor = %s, want %s", actor.ID, bob)
+			}
+			if tt.wantErr != nil && actor != nil {
+				t.Errorf("Verify() returned actor %s with an error", actor.ID)
+			}
+		})
+	}
+}
+
+func TestVerifyTamperedBody(t *testing.T) {
+	const bob = "https://remote.example/users/bob"
+	key := newTestKey(t)
+	cacheTestActor(t, bob, bob+"#main-key", bob, key)
+
+	r := testRequest{
+		method:    "POST",
+		body:      `{"type":"Follow"}`,
+		headers:   []string{"(request-target)", "host", "date", "digest"},
+		keyID:     bob + "#main-key",
+		key:       key,
+		algorithm: "rsa-sha256",
+		date:      time.Now(),
+	}.signed(t)
+	r.Body = []byte(`{"type":"Undo"}`)
+
+	if _, err := Verify(r); err != ErrBadSignature {
+		t.Errorf("Verify() error = %v, want ErrBadSignature", err)
+	}
+}
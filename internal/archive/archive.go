@@ -0,0 +1,135 @@
+// Package archive builds the downloadable ZIP of everything stored about a
+// user: their profile and avatar, shelves, reviews, notes, quotes, goals,
+// activity events, imports and session metadata, described by a manifest.
+// Archives are built in the background and kept on disk for
+// models.DataExportLifetime.
+package archive
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+var (
+	// dir is where finished archives are kept, see SetDir
+	dir = "./data/exports"
+
+	// workMu builds one archive at a time
+	workMu sync.Mutex
+
+	startedMu sync.Mutex
+	started   = make(map[int64]bool)
+)
+
+// SetDir sets the directory archives are written to. It should be outside
+// the static files, as archives are only served to their owner and admins.
+func SetDir(path string) {
+	dir = path
+}
+
+// Path returns where an export's archive is stored
+func Path(export *models.DataExport) string {
+	return filepath.Join(dir, export.Filename.String)
+}
+
+// Start builds an export in the background, unless it is already being built
+func Start(exportID int64) {
+	startedMu.Lock()
+	if started[exportID] {
+		startedMu.Unlock()
+		return
+	}
+	started[exportID] = true
+	startedMu.Unlock()
+
+	go func() {
+		defer func() {
+			startedMu.Lock()
+			delete(started, exportID)
+			startedMu.Unlock()
+		}()
+		// An export that trips up the builder fails on its own rather than
+		// taking the server down, which would otherwise resume it and fail again
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[archive] Export %d panicked: %v\n%s", exportID, r, debug.Stack())
+				_ = models.SetDataExportStatus(exportID, models.DataExportFailed, "The archive could not be built")
+			}
+		}()
+		if err := build(exportID); err != nil {
+			log.Printf("[archive] Export %d failed: %v", exportID, err)
+			_ = models.SetDataExportStatus(exportID, models.DataExportFailed, "The archive could not be built")
+		}
+	}()
+}
+
+// ResumeExports restarts exports left pending or running by a previous run of
+// the server. Interrupted archives are built again from scratch.
+func ResumeExports() {
+	exports, err := models.GetUnfinishedDataExports()
+	if err != nil {
+		log.Printf("[archive] Failed to load unfinished exports: %v", err)
+		return
+	}
+	for _, export := range exports {
+		Start(export.ID)
+	}
+}
+
+// RemoveExpired deletes exports past their lifetime along with their
+// archives, and any archive left behind by an export that no longer exists,
+// such as one of a deleted user
+func RemoveExpired() error {
+	exports, err := models.GetAllDataExports()
+	if err != nil {
+		return err
+	}
+	kept := make(map[string]bool)
+	for _, export := range exports {
+		if !export.IsExpired() {
+			// Including exports still being built, whose archive may appear
+			// at any moment
+			kept[filename(export.ID)] = true
+			continue
+		}
+		if export.Filename.Valid {
+			if err := os.Remove(Path(&export)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := models.DeleteDataExport(export.ID); err != nil {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		// Archives being built are still temporary files
+		if entry.IsDir() || kept[name] || !strings.HasSuffix(name, ".zip") {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filename returns the name of an export's archive on disk
+func filename(exportID int64) string {
+	return "export-" + strconv.FormatInt(exportID, 10) + ".zip"
+}
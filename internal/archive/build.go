@@ -0,0 +1,531 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+// ManifestVersion is bumped when the layout of archives changes incompatibly
+const ManifestVersion = 1
+
+// manifest describes an archive, written last as manifest.json
+type manifest struct {
+	Version     int            `json:"version"`
+	GeneratedAt time.Time      `json:"generated_at"`
+	UserID      int64          `json:"user_id"`
+	Username    string         `json:"username"`
+	RequestedBy string         `json:"requested_by"`
+	Files       []manifestFile `json:"files"`
+}
+
+// manifestFile is one file of an archive
+type manifestFile struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Number of entries in the file, for lists
+	Records *int   `json:"records,omitempty"`
+	Size    int    `json:"size"`
+	SHA256  string `json:"sha256"`
+}
+
+type profileRecord struct {
+	ID             int64     `json:"id"`
+	Username       string    `json:"username"`
+	DisplayName    string    `json:"display_name"`
+	Description    string    `json:"description"`
+	Theme          string    `json:"theme"`
+	CatalogPublic  bool      `json:"catalog_public"`
+	HasPassword    bool      `json:"has_password"`
+	ProfilePicture string    `json:"profile_picture,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type reviewRecord struct {
+	BookID    int64     `json:"book_id"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body"` // Markdown
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type quoteRecord struct {
+	BookID    int64     `json:"book_id"`
+	Title     string    `json:"title"`
+	Text      string    `json:"text"`
+	Page      *int64    `json:"page,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+	Public    bool      `json:"public"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type goalRecord struct {
+	Year      int    `json:"year"`
+	BooksGoal *int64 `json:"books_goal,omitempty"`
+	PagesGoal *int64 `json:"pages_goal,omitempty"`
+	ReachedAt string `json:"reached_at,omitempty"`
+}
+
+type eventRecord struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"type"`
+	BookID    *int64    `json:"book_id,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	Shelf     string    `json:"shelf,omitempty"`
+	OldValue  string    `json:"old_value,omitempty"`
+	NewValue  string    `json:"new_value,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// sessionRecord leaves out the session token, which would let anyone holding
+// the archive sign in
+type sessionRecord struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"type"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type importRecord struct {
+	Source     string    `json:"source"`
+	Filename   string    `json:"filename"`
+	Status     string    `json:"status"`
+	Rows       int       `json:"rows"`
+	Imported   int       `json:"imported"`
+	CreatedAt  time.Time `json:"created_at"`
+	FinishedAt string    `json:"finished_at,omitempty"`
+}
+
+// pendingClippingRecord is a book's Kindle highlights waiting for the user to
+// pick the book they belong to
+type pendingClippingRecord struct {
+	Title     string            `json:"title"`
+	Authors   string            `json:"authors,omitempty"`
+	Clippings []models.Clipping `json:"clippings"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+type followRecord struct {
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type followsRecord struct {
+	Following []followRecord `json:"following"`
+	Followers []followRecord `json:"followers"`
+}
+
+type remoteFollowerRecord struct {
+	ActorID   string    `json:"actor_id"`
+	Inbox     string    `json:"inbox"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// commentRecord is a comment on an event. Removed comments keep their place
+// but not their text.
+type commentRecord struct {
+	ID        int64     `json:"id"`
+	EventID   int64     `json:"event_id"`
+	ParentID  *int64    `json:"parent_id,omitempty"`
+	Body      string    `json:"body"`
+	Removed   string    `json:"removed,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type reactionRecord struct {
+	EventID   int64     `json:"event_id"`
+	Emoji     string    `json:"emoji"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type notificationRecord struct {
+	Kind      string    `json:"kind"`
+	From      string    `json:"from"`
+	EventID   int64     `json:"event_id"`
+	CommentID *int64    `json:"comment_id,omitempty"`
+	Read      bool      `json:"read"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type dataExportRecord struct {
+	RequestedBy string    `json:"requested_by"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// archiveWriter adds files to a ZIP, noting each in the manifest
+type archiveWriter struct {
+	zw       *zip.Writer
+	manifest manifest
+}
+
+// add writes a file to the archive. records is the number of entries in a
+// list, or -1 for files that aren't one.
+func (a *archiveWriter) add(name, description string, data []byte, records int) error {
+	w, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: a.manifest.GeneratedAt})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	file := manifestFile{Name: name, Description: description, Size: len(data), SHA256: hex.EncodeToString(sum[:])}
+	if records >= 0 {
+		file.Records = &records
+	}
+	a.manifest.Files = append(a.manifest.Files, file)
+	return nil
+}
+
+// addJSON writes v to the archive as indented JSON
+func (a *archiveWriter) addJSON(name, description string, v interface{}, records int) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return a.add(name, description, data, records)
+}
+
+// build writes an export's archive to a temporary file and moves it into
+// place once complete, so a half-written archive is never offered
+func build(exportID int64) error {
+	workMu.Lock()
+	defer workMu.Unlock()
+
+	export, err := models.GetDataExport(exportID)
+	if err != nil {
+		return err
+	}
+	user, err := models.GetUserByID(export.UserID)
+	if err != nil {
+		return err
+	}
+	if err := models.SetDataExportStatus(exportID, models.DataExportRunning, ""); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "export-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	a := &archiveWriter{
+		zw: zip.NewWriter(tmp),
+		manifest: manifest{
+			Version:     ManifestVersion,
+			GeneratedAt: time.Now().UTC(),
+			UserID:      user.ID,
+			Username:    user.Username,
+			RequestedBy: export.RequestedBy,
+		},
+	}
+	if err := writeContents(a, user); err != nil {
+		return err
+	}
+	if err := a.addJSON("manifest.json", "This file: what the archive holds", a.manifest, -1); err != nil {
+		return err
+	}
+	if err := a.zw.Close(); err != nil {
+		return err
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	name := filename(exportID)
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	return models.FinishDataExport(exportID, name, info.Size())
+}
+
+// writeContents adds everything stored about the user to the archive
+func writeContents(a *archiveWriter, user *models.User) error {
+	profile := profileRecord{
+		ID:          user.ID,
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		Description: user.Description,
+		Theme:       user.Theme,
+		HasPassword: user.HasPassword(),
+		CreatedAt:   user.CreatedAt.UTC(),
+	}
+	catalogPublic, err := models.IsCatalogPublic(user.ID)
+	if err != nil {
+		return err
+	}
+	profile.CatalogPublic = catalogPublic
+	if user.HasProfilePicture() {
+		profile.ProfilePicture = "avatar/" + user.ProfilePicture.String
+	}
+	if err := a.addJSON("profile.json", "Profile details; the password itself is never exported", profile, -1); err != nil {
+		return err
+	}
+	if user.HasProfilePicture() {
+		// The picture may have been removed from disk; the archive is still useful without it
+		data, err := os.ReadFile(filepath.Join(models.AvatarDir, filepath.Base(user.ProfilePicture.String)))
+		if err == nil {
+			if err := a.add(profile.ProfilePicture, "Profile picture", data, -1); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	library, err := models.GetLibraryExport(user)
+	if err != nil {
+		return err
+	}
+	if err := a.addJSON("library.json", "Every shelved book with its dates, rating, tags, review and notes", library, len(library.Books)); err != nil {
+		return err
+	}
+	var csv bytes.Buffer
+	if err := library.WriteGoodreadsCSV(&csv); err != nil {
+		return err
+	}
+	if err := a.add("library.csv", "The shelves in Goodreads' CSV layout, for importing elsewhere", csv.Bytes(), len(library.Books)); err != nil {
+		return err
+	}
+
+	// A limit of -1 means no limit in SQLite
+	reviews, err := models.GetUserReviews(user.ID, -1)
+	if err != nil {
+		return err
+	}
+	reviewRecords := make([]reviewRecord, 0, len(reviews))
+	for _, r := range reviews {
+		reviewRecords = append(reviewRecords, reviewRecord{
+			BookID:    r.UserBook.BookID,
+			Title:     r.UserBook.Book.Title,
+			Body:      r.Body,
+			CreatedAt: r.CreatedAt.UTC(),
+			UpdatedAt: r.UpdatedAt.UTC(),
+		})
+	}
+	if err := a.addJSON("reviews.json", "Reviews with when they were written and last edited", reviewRecords, len(reviewRecords)); err != nil {
+		return err
+	}
+
+	quotes, err := models.SearchUserQuotes(user.ID, "", -1)
+	if err != nil {
+		return err
+	}
+	quoteRecords := make([]quoteRecord, 0, len(quotes))
+	for _, q := range quotes {
+		record := quoteRecord{
+			BookID:    q.Book.ID,
+			Title:     q.Book.Title,
+			Text:      q.Text,
+			Comment:   q.Comment.String,
+			Public:    q.IsPublic,
+			CreatedAt: q.CreatedAt.UTC(),
+		}
+		if q.Page.Valid {
+			record.Page = &q.Page.Int64
+		}
+		quoteRecords = append(quoteRecords, record)
+	}
+	if err := a.addJSON("quotes.json", "Saved quotes", quoteRecords, len(quoteRecords)); err != nil {
+		return err
+	}
+
+	goals, err := models.GetUserReadingGoals(user.ID)
+	if err != nil {
+		return err
+	}
+	goalRecords := make([]goalRecord, 0, len(goals))
+	for _, g := range goals {
+		record := goalRecord{Year: g.Year, ReachedAt: g.ReachedAt.String}
+		if g.BooksGoal.Valid {
+			record.BooksGoal = &g.BooksGoal.Int64
+		}
+		if g.PagesGoal.Valid {
+			record.PagesGoal = &g.PagesGoal.Int64
+		}
+		goalRecords = append(goalRecords, record)
+	}
+	if err := a.addJSON("reading_goals.json", "Yearly reading goals", goalRecords, len(goalRecords)); err != nil {
+		return err
+	}
+
+	events, err := models.GetUserEvents(user.ID, -1)
+	if err != nil {
+		return err
+	}
+	eventRecords := make([]eventRecord, 0, len(events))
+	for _, ev := range events {
+		record := eventRecord{
+			ID:        ev.ID,
+			Type:      ev.EventType,
+			Shelf:     ev.Shelf.String,
+			OldValue:  ev.OldValue.String,
+			NewValue:  ev.NewValue.String,
+			CreatedAt: ev.CreatedAt.UTC(),
+		}
+		if ev.BookID.Valid {
+			record.BookID = &ev.BookID.Int64
+		}
+		if ev.Book != nil {
+			record.Title = ev.Book.Title
+		}
+		eventRecords = append(eventRecords, record)
+	}
+	if err := a.addJSON("events.json", "Activity shown on the dashboard and profile, newest first", eventRecords, len(eventRecords)); err != nil {
+		return err
+	}
+
+	following, err := models.GetFollowing(user.ID)
+	if err != nil {
+		return err
+	}
+	followers, err := models.GetFollowers(user.ID)
+	if err != nil {
+		return err
+	}
+	follows := followsRecord{Following: followRecords(following), Followers: followRecords(followers)}
+	if err := a.addJSON("follows.json", "Users followed, and users following, with when each follow started", follows, len(following)+len(followers)); err != nil {
+		return err
+	}
+
+	remoteFollowers, err := models.GetRemoteFollowers(user.ID)
+	if err != nil {
+		return err
+	}
+	remoteRecords := make([]remoteFollowerRecord, 0, len(remoteFollowers))
+	for _, f := range remoteFollowers {
+		remoteRecords = append(remoteRecords, remoteFollowerRecord{ActorID: f.ActorID, Inbox: f.Inbox, CreatedAt: f.CreatedAt.UTC()})
+	}
+	if err := a.addJSON("remote_followers.json", "Accounts on other servers following through ActivityPub", remoteRecords, len(remoteRecords)); err != nil {
+		return err
+	}
+
+	comments, err := models.GetUserComments(user.ID)
+	if err != nil {
+		return err
+	}
+	commentRecords := make([]commentRecord, 0, len(comments))
+	for _, c := range comments {
+		record := commentRecord{ID: c.ID, EventID: c.EventID, Body: c.Body, Removed: c.Removed.String, CreatedAt: c.CreatedAt.UTC()}
+		if c.ParentID.Valid {
+			record.ParentID = &c.ParentID.Int64
+		}
+		commentRecords = append(commentRecords, record)
+	}
+	if err := a.addJSON("comments.json", "Comments on activity, including deleted and removed ones, newest first", commentRecords, len(commentRecords)); err != nil {
+		return err
+	}
+
+	reactions, err := models.GetUserReactions(user.ID)
+	if err != nil {
+		return err
+	}
+	reactionRecords := make([]reactionRecord, 0, len(reactions))
+	for _, r := range reactions {
+		reactionRecords = append(reactionRecords, reactionRecord{EventID: r.EventID, Emoji: r.Emoji, CreatedAt: r.CreatedAt.UTC()})
+	}
+	if err := a.addJSON("reactions.json", "Reactions to activity, newest first", reactionRecords, len(reactionRecords)); err != nil {
+		return err
+	}
+
+	notifications, err := models.GetNotifications(user.ID, -1)
+	if err != nil {
+		return err
+	}
+	notificationRecords := make([]notificationRecord, 0, len(notifications))
+	for _, n := range notifications {
+		record := notificationRecord{
+			Kind:      n.Kind,
+			From:      n.Actor.Username,
+			EventID:   n.EventID,
+			Read:      !n.Unread,
+			CreatedAt: n.CreatedAt.UTC(),
+		}
+		if n.CommentID.Valid {
+			record.CommentID = &n.CommentID.Int64
+		}
+		notificationRecords = append(notificationRecords, record)
+	}
+	if err := a.addJSON("notifications.json", "Notifications of reactions, comments and replies, newest first", notificationRecords, len(notificationRecords)); err != nil {
+		return err
+	}
+
+	imports, err := models.GetImportJobs(user.ID, -1)
+	if err != nil {
+		return err
+	}
+	importRecords := make([]importRecord, 0, len(imports))
+	for _, job := range imports {
+		importRecords = append(importRecords, importRecord{
+			Source:     job.Source,
+			Filename:   job.Filename,
+			Status:     job.Status,
+			Rows:       job.Total,
+			Imported:   job.Imported,
+			CreatedAt:  job.CreatedAt.UTC(),
+			FinishedAt: job.FinishedAt.String,
+		})
+	}
+	if err := a.addJSON("imports.json", "Files imported from other services", importRecords, len(importRecords)); err != nil {
+		return err
+	}
+
+	pending, err := models.GetPendingClippings(user.ID)
+	if err != nil {
+		return err
+	}
+	pendingRecords := make([]pendingClippingRecord, 0, len(pending))
+	for _, b := range pending {
+		pendingRecords = append(pendingRecords, pendingClippingRecord{Title: b.Title, Authors: b.Authors, Clippings: b.Clippings, CreatedAt: b.CreatedAt.UTC()})
+	}
+	if err := a.addJSON("pending_clippings.json", "Kindle highlights waiting to be matched to a book", pendingRecords, len(pendingRecords)); err != nil {
+		return err
+	}
+
+	sessions, err := models.GetUserSessions(user.ID)
+	if err != nil {
+		return err
+	}
+	sessionRecords := make([]sessionRecord, 0, len(sessions))
+	for _, s := range sessions {
+		sessionRecords = append(sessionRecords, sessionRecord{ID: s.ID, Type: s.SessionType, ExpiresAt: s.ExpiresAt.UTC()})
+	}
+	if err := a.addJSON("sessions.json", "Sign-in sessions, without their secret tokens", sessionRecords, len(sessionRecords)); err != nil {
+		return err
+	}
+
+	exports, err := models.GetUserDataExports(user.ID, -1)
+	if err != nil {
+		return err
+	}
+	exportRecords := make([]dataExportRecord, 0, len(exports))
+	for _, e := range exports {
+		exportRecords = append(exportRecords, dataExportRecord{RequestedBy: e.RequestedBy, Status: e.Status, CreatedAt: e.CreatedAt.UTC()})
+	}
+	return a.addJSON("data_exports.json", "Archives like this one that have been requested", exportRecords, len(exportRecords))
+}
+
+func followRecords(follows []models.Follow) []followRecord {
+	records := make([]followRecord, 0, len(follows))
+	for _, f := range follows {
+		records = append(records, followRecord{UserID: f.UserID, Username: f.Username, CreatedAt: f.CreatedAt.UTC()})
+	}
+	return records
+}
@@ -0,0 +1,345 @@
+// Package backup takes and restores backups of a whole Spines instance: a
+// consistent copy of the database made with SQLite's online backup API while
+// the server keeps running, and the uploaded files, zipped together with a
+// manifest. Only the newest archives are kept.
+package backup
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/nuuner/spines/internal/database"
+)
+
+// ManifestVersion is bumped when the layout of archives changes incompatibly
+const ManifestVersion = 1
+
+// Names of the parts of an archive
+const (
+	manifestName = "backup.json"
+	databaseName = "spines.db"
+	uploadsName  = "uploads/"
+)
+
+// archiveName matches the names of backup archives, which sort by age
+var archiveName = regexp.MustCompile(`^spines-backup-\d{8}-\d{6}\.zip$`)
+
+// createMu takes one backup at a time
+var createMu sync.Mutex
+
+// ErrNotBackup is returned for archives that aren't Spines backups
+var ErrNotBackup = errors.New("not a Spines backup archive")
+
+// manifest describes a backup, written last as backup.json
+type manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	// Migrations applied to the backed up database
+	Migrations []string `json:"migrations"`
+	Uploads    int      `json:"uploads"`
+}
+
+// Archive is a backup archive on disk
+type Archive struct {
+	Name      string
+	Size      int64
+	CreatedAt time.Time
+}
+
+// CreatedAtDisplay returns when the backup was taken, for display
+func (a Archive) CreatedAtDisplay() string {
+	return a.CreatedAt.Local().Format("Jan 2, 2006 15:04")
+}
+
+// SizeDisplay returns the archive's size, for display
+func (a Archive) SizeDisplay() string {
+	switch {
+	case a.Size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(a.Size)/(1<<20))
+	case a.Size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(a.Size)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", a.Size)
+}
+
+// Create backs up the open database and the uploads directory into a new
+// archive in dir, then removes all but the newest keep archives. A keep of 0
+// or less keeps every archive.
+func Create(dir, uploadsDir string, keep int) (*Archive, error) {
+	createMu.Lock()
+	defer createMu.Unlock()
+
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	createdAt := time.Now().UTC()
+	name := "spines-backup-" + createdAt.Format("20060102-150405") + ".zip"
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("backup %s already exists", name)
+	}
+
+	// The backup API copies into a database file, which is then zipped
+	snapshot, err := os.CreateTemp(dir, ".spines-backup-*.db")
+	if err != nil {
+		return nil, err
+	}
+	snapshot.Close()
+	defer os.Remove(snapshot.Name())
+	if err := copyDatabase(snapshot.Name()); err != nil {
+		return nil, fmt.Errorf("copying database: %w", err)
+	}
+	migrations, err := appliedMigrations(snapshot.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	// Written under a temporary name so a half-written archive is never listed
+	tmp, err := os.CreateTemp(dir, ".spines-backup-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	zw := zip.NewWriter(tmp)
+	if err := addFile(zw, databaseName, snapshot.Name(), createdAt); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	uploads, err := addUploads(zw, uploadsDir)
+	if err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("adding uploads: %w", err)
+	}
+	data, err := json.MarshalIndent(manifest{
+		Version:    ManifestVersion,
+		CreatedAt:  createdAt,
+		Migrations: migrations,
+		Uploads:    uploads,
+	}, "", "  ")
+	if err == nil {
+		err = addBytes(zw, manifestName, data, createdAt)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+
+	if err := rotate(dir, keep); err != nil {
+		return nil, fmt.Errorf("removing old backups: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{Name: name, Size: info.Size(), CreatedAt: createdAt}, nil
+}
+
+// copyDatabase copies the open database to path with the online backup API,
+// which gives a consistent copy even while the server writes to it
+func copyDatabase(path string) error {
+	ctx := context.Background()
+	src, err := database.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	destDB, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer destDB.Close()
+	dest, err := destDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	return dest.Raw(func(destDriver interface{}) error {
+		return src.Raw(func(srcDriver interface{}) error {
+			destConn, ok := destDriver.(*sqlite3.SQLiteConn)
+			srcConn, ok2 := srcDriver.(*sqlite3.SQLiteConn)
+			if !ok || !ok2 {
+				return errors.New("not an SQLite connection")
+			}
+			b, err := destConn.Backup("main", srcConn, "main")
+			if err != nil {
+				return err
+			}
+			// Copy every page in one step, so writes can't interleave
+			if _, err := b.Step(-1); err != nil {
+				b.Finish()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+}
+
+// appliedMigrations returns the migrations recorded in a database file, or
+// ErrNotBackup if it has no record of migrations
+func appliedMigrations(path string) ([]string, error) {
+	db, err := openReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT name FROM schema_migrations ORDER BY name")
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") || strings.Contains(err.Error(), "not a database") {
+			return nil, ErrNotBackup
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// openReadOnly opens a database file without changing it
+func openReadOnly(path string) (*sql.DB, error) {
+	dsn := url.URL{Scheme: "file", Opaque: (&url.URL{Path: path}).EscapedPath(), RawQuery: "mode=ro"}
+	return sql.Open("sqlite3", dsn.String())
+}
+
+// addUploads adds every file under the uploads directory, returning how many
+// were added. A missing directory has nothing to add.
+func addUploads(zw *zip.Writer, uploadsDir string) (int, error) {
+	count := 0
+	err := filepath.WalkDir(uploadsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == uploadsDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(uploadsDir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		count++
+		return addFile(zw, uploadsName+filepath.ToSlash(rel), path, info.ModTime())
+	})
+	return count, err
+}
+
+func addFile(zw *zip.Writer, name, path string, modified time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func addBytes(zw *zip.Writer, name string, data []byte, modified time.Time) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// List returns the backup archives in dir, newest first
+func List(dir string) ([]Archive, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var archives []Archive
+	for _, entry := range entries {
+		if entry.IsDir() || !archiveName.MatchString(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		createdAt, err := time.Parse("20060102-150405", strings.TrimSuffix(strings.TrimPrefix(entry.Name(), "spines-backup-"), ".zip"))
+		if err != nil {
+			createdAt = info.ModTime()
+		}
+		archives = append(archives, Archive{Name: entry.Name(), Size: info.Size(), CreatedAt: createdAt})
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].Name > archives[j].Name
+	})
+	return archives, nil
+}
+
+// Path returns where the named archive is in dir, or false if the name isn't
+// one of a backup archive
+func Path(dir, name string) (string, bool) {
+	if !archiveName.MatchString(name) {
+		return "", false
+	}
+	return filepath.Join(dir, name), true
+}
+
+// rotate removes all but the newest keep archives in dir
+func rotate(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	archives, err := List(dir)
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(archives); i++ {
+		if err := os.Remove(filepath.Join(dir, archives[i].Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
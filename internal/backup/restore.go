@@ -0,0 +1,269 @@
+package backup
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nuuner/spines/internal/database"
+)
+
+// Restored names where Restore kept what it replaced, empty if there was
+// nothing to keep
+type Restored struct {
+	// The previous database
+	Database string
+	// Copies of the uploads the backup's overwrote
+	Uploads string
+}
+
+// Restore replaces the database at dbPath with the one in a backup archive
+// and puts the archive's uploads back into uploadsDir, overwriting files of
+// the same name. The server must be stopped first. The database and uploads
+// are extracted and checked before anything is changed, and what they replace
+// is kept next to the database so a restore can be undone.
+func Restore(archivePath, dbPath, uploadsDir string) (*Restored, error) {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, ErrNotBackup
+	}
+	defer zr.Close()
+
+	var manifestFile, databaseFile *zip.File
+	for _, f := range zr.File {
+		switch f.Name {
+		case manifestName:
+			manifestFile = f
+		case databaseName:
+			databaseFile = f
+		}
+	}
+	if manifestFile == nil || databaseFile == nil {
+		return nil, ErrNotBackup
+	}
+	var m manifest
+	if err := readJSON(manifestFile, &m); err != nil {
+		return nil, ErrNotBackup
+	}
+	if m.Version > ManifestVersion {
+		return nil, fmt.Errorf("the backup was made by a newer version of Spines (archive version %d)", m.Version)
+	}
+
+	// Extracted next to the database, so it can be renamed into place
+	tmp, err := os.CreateTemp(filepath.Dir(dbPath), ".spines-restore-*.db")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	err = extract(databaseFile, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("extracting database: %w", err)
+	}
+	if err := checkDatabase(tmp.Name()); err != nil {
+		return nil, err
+	}
+
+	// Uploads are staged inside the uploads directory, so they can be
+	// renamed into place; it is often a volume of its own
+	if err := os.MkdirAll(uploadsDir, 0o755); err != nil {
+		return nil, err
+	}
+	staging, err := os.MkdirTemp(uploadsDir, ".spines-restore-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+	staged, err := stageUploads(zr.File, staging)
+	if err != nil {
+		return nil, fmt.Errorf("restoring uploads: %w", err)
+	}
+
+	suffix := ".before-restore-" + time.Now().UTC().Format("20060102-150405")
+	restored := &Restored{}
+
+	// Copies of the uploads about to be overwritten are kept next to the
+	// database, as uploadsDir may be on another volume
+	uploadsAside := filepath.Join(filepath.Dir(dbPath), "uploads"+suffix)
+	kept, err := keepUploads(staged, uploadsDir, uploadsAside)
+	if err != nil {
+		return nil, fmt.Errorf("keeping current uploads: %w", err)
+	}
+	if kept > 0 {
+		restored.Uploads = uploadsAside
+	}
+
+	// Set the current database aside, with its journal files so it stays
+	// usable, and move the restored one in
+	aside := dbPath + suffix
+	var moved []string
+	for _, journal := range []string{"", "-wal", "-shm", "-journal"} {
+		err := os.Rename(dbPath+journal, aside+journal)
+		if err != nil && !os.IsNotExist(err) {
+			putBack(moved, dbPath, aside)
+			return nil, err
+		}
+		if err == nil {
+			moved = append(moved, journal)
+			if journal == "" {
+				restored.Database = aside
+			}
+		}
+	}
+	if err := os.Rename(tmp.Name(), dbPath); err != nil {
+		putBack(moved, dbPath, aside)
+		return nil, err
+	}
+
+	for _, rel := range staged {
+		path := filepath.Join(uploadsDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return restored, fmt.Errorf("restoring uploads: %w", err)
+		}
+		if err := os.Rename(filepath.Join(staging, rel), path); err != nil {
+			return restored, fmt.Errorf("restoring uploads: %w", err)
+		}
+	}
+	return restored, nil
+}
+
+// putBack returns a database set aside by Restore to its place
+func putBack(journals []string, dbPath, aside string) {
+	for _, journal := range journals {
+		os.Rename(aside+journal, dbPath+journal)
+	}
+}
+
+// checkDatabase makes sure a database is intact and has no migrations this
+// build doesn't know. Those would mean it comes from a newer version of
+// Spines, whose schema this one can't work with. Older databases are fine, as
+// the server migrates them when it starts.
+func checkDatabase(path string) error {
+	db, err := openReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return ErrNotBackup
+	}
+	if result != "ok" {
+		return fmt.Errorf("the backed up database is damaged: %s", result)
+	}
+	db.Close()
+
+	applied, err := appliedMigrations(path)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool)
+	for _, name := range database.MigrationNames() {
+		known[name] = true
+	}
+	var unknown []string
+	for _, name := range applied {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("the backup was made by a newer version of Spines, with migrations this one doesn't know: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// stageUploads extracts the archive's uploads into dir, returning their
+// paths relative to it
+func stageUploads(files []*zip.File, dir string) ([]string, error) {
+	var staged []string
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name, uploadsName) || strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		// Names must stay inside the uploads directory
+		rel := filepath.FromSlash(strings.TrimPrefix(f.Name, uploadsName))
+		if !filepath.IsLocal(rel) {
+			return nil, fmt.Errorf("unsafe file name %q", f.Name)
+		}
+		path := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+		out, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		err = extract(f, out)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		staged = append(staged, rel)
+	}
+	return staged, nil
+}
+
+// keepUploads copies the files in uploadsDir that restoring would overwrite
+// into aside, returning how many there were
+func keepUploads(rels []string, uploadsDir, aside string) (int, error) {
+	kept := 0
+	for _, rel := range rels {
+		in, err := os.Open(filepath.Join(uploadsDir, rel))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return kept, err
+		}
+		path := filepath.Join(aside, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			in.Close()
+			return kept, err
+		}
+		out, err := os.Create(path)
+		if err != nil {
+			in.Close()
+			return kept, err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return kept, err
+		}
+		kept++
+	}
+	return kept, nil
+}
+
+func extract(f *zip.File, w io.Writer) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func readJSON(f *zip.File, v interface{}) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return json.NewDecoder(r).Decode(v)
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -11,6 +13,13 @@ type Config struct {
 	DatabasePath      string
 	AdminPassword     string
 	GoogleBooksAPIKey string
+	RatingScale       int
+	ExportPath        string
+	CalibrePath       string
+	BackupPath        string
+	BackupKeep        int // how many backup archives to keep, 0 for all
+	PublicURL         string
+	FederateLocal     bool // allow plain http and private addresses for federation, for testing
 }
 
 func Load() *Config {
@@ -21,6 +30,16 @@ func Load() *Config {
 		DatabasePath:      getEnv("DATABASE_PATH", "./spines.db"),
 		AdminPassword:     getEnv("ADMIN_PASSWORD", ""),
 		GoogleBooksAPIKey: getEnv("GOOGLE_BOOKS_API_KEY", ""),
+		RatingScale:       getEnvInt("RATING_SCALE", 5),
+		ExportPath:        getEnv("EXPORT_PATH", "./data/exports"),
+		CalibrePath:       getEnv("CALIBRE_LIBRARY_PATH", ""),
+		BackupPath:        getEnv("BACKUP_PATH", "./data/backups"),
+		BackupKeep:        getEnvInt("BACKUP_KEEP", 7),
+		// Where the instance is reached from outside, such as
+		// https://books.example.com, used for links and IDs in feeds,
+		// catalogs and federation; federation is off without it
+		PublicURL:     strings.TrimSuffix(getEnv("PUBLIC_URL", ""), "/"),
+		FederateLocal: getEnvBool("FEDERATION_ALLOW_LOCAL", false),
 	}
 }
 
@@ -30,3 +49,21 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvInt(key string, fallback int) int {
+	if value, ok := os.LookupEnv(key); ok {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
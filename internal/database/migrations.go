@@ -1,5 +1,10 @@
 package database
 
+import (
+	"context"
+	"strings"
+)
+
 func Migrate() error {
 	schema := `
 	CREATE TABLE IF NOT EXISTS users (
@@ -45,11 +50,17 @@ func Migrate() error {
 	return runMigrations()
 }
 
-func runMigrations() error {
-	migrations := []struct {
-		name string
-		sql  string
-	}{
+// migration is an incremental schema change, recorded by name in
+// schema_migrations once applied
+type migration struct {
+	name string
+	sql  string
+	fn   func() error // used instead of sql for migrations that need more than one statement
+}
+
+// migrations lists the incremental schema changes in the order they are applied
+func migrations() []migration {
+	return []migration{
 		{
 			name: "add_password_hash_to_users",
 			sql:  "ALTER TABLE users ADD COLUMN password_hash TEXT DEFAULT NULL",
@@ -133,8 +144,418 @@ func runMigrations() error {
 			name: "add_description_to_books",
 			sql:  "ALTER TABLE books ADD COLUMN description TEXT DEFAULT NULL",
 		},
+		{
+			// Ratings are stored in half-star points (1-10) so existing integer stars double
+			name: "add_rating_points_to_user_books",
+			sql:  "ALTER TABLE user_books ADD COLUMN rating_points INTEGER DEFAULT NULL CHECK(rating_points IS NULL OR (rating_points >= 1 AND rating_points <= 10))",
+		},
+		{
+			name: "backfill_rating_points_from_rating",
+			sql:  "UPDATE user_books SET rating_points = rating * 2 WHERE rating IS NOT NULL AND rating_points IS NULL",
+		},
+		{
+			name: "create_reviews_table",
+			sql: `CREATE TABLE IF NOT EXISTS reviews (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_book_id INTEGER NOT NULL UNIQUE,
+				body TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (user_book_id) REFERENCES user_books(id) ON DELETE CASCADE
+			)`,
+		},
+		{
+			name: "add_notes_to_user_books",
+			sql:  "ALTER TABLE user_books ADD COLUMN notes TEXT DEFAULT NULL",
+		},
+		{
+			name: "create_quotes_table",
+			sql: `CREATE TABLE IF NOT EXISTS quotes (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_book_id INTEGER NOT NULL,
+				text TEXT NOT NULL,
+				page INTEGER DEFAULT NULL,
+				comment TEXT DEFAULT NULL,
+				is_public INTEGER NOT NULL DEFAULT 0,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (user_book_id) REFERENCES user_books(id) ON DELETE CASCADE
+			)`,
+		},
+		{
+			name: "create_quotes_user_book_id_index",
+			sql:  "CREATE INDEX IF NOT EXISTS idx_quotes_user_book_id ON quotes(user_book_id)",
+		},
+		{
+			name: "add_paused_and_dnf_shelves_to_user_books",
+			fn: func() error {
+				return rebuildTable("user_books", `CREATE TABLE user_books_new (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					user_id INTEGER NOT NULL,
+					book_id INTEGER NOT NULL,
+					shelf TEXT NOT NULL CHECK(shelf IN ('want_to_read', 'currently_reading', 'read', 'on_hold', 'did_not_finish')),
+					sub_status TEXT DEFAULT NULL,
+					added_at DATETIME DEFAULT NULL,
+					started_reading_at DATETIME DEFAULT NULL,
+					finished_reading_at DATETIME DEFAULT NULL,
+					rating INTEGER DEFAULT NULL CHECK(rating IS NULL OR (rating >= 1 AND rating <= 5)),
+					rating_points INTEGER DEFAULT NULL CHECK(rating_points IS NULL OR (rating_points >= 1 AND rating_points <= 10)),
+					notes TEXT DEFAULT NULL,
+					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+					FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE CASCADE,
+					UNIQUE(user_id, book_id)
+				)`, []string{
+					"id", "user_id", "book_id", "shelf", "sub_status", "added_at", "started_reading_at",
+					"finished_reading_at", "rating", "rating_points", "notes",
+				})
+			},
+		},
+		{
+			name: "add_stopped_at_to_user_books",
+			sql:  "ALTER TABLE user_books ADD COLUMN stopped_at DATETIME DEFAULT NULL",
+		},
+		{
+			name: "add_stopped_page_to_user_books",
+			sql:  "ALTER TABLE user_books ADD COLUMN stopped_page INTEGER DEFAULT NULL",
+		},
+		{
+			name: "add_stopped_percent_to_user_books",
+			sql:  "ALTER TABLE user_books ADD COLUMN stopped_percent INTEGER DEFAULT NULL CHECK(stopped_percent IS NULL OR (stopped_percent >= 0 AND stopped_percent <= 100))",
+		},
+		{
+			name: "add_stopped_reason_to_user_books",
+			sql:  "ALTER TABLE user_books ADD COLUMN stopped_reason TEXT DEFAULT NULL",
+		},
+		{
+			name: "add_format_to_user_books",
+			sql:  "ALTER TABLE user_books ADD COLUMN format TEXT DEFAULT NULL CHECK(format IS NULL OR format IN ('hardcover', 'paperback', 'ebook', 'audiobook', 'library_loan'))",
+		},
+		{
+			name: "add_owned_to_user_books",
+			sql:  "ALTER TABLE user_books ADD COLUMN owned INTEGER DEFAULT NULL CHECK(owned IS NULL OR owned IN (0, 1))",
+		},
+		{
+			// Ownership used to be stored in sub_status on the want to read shelf
+			name: "move_ownership_out_of_sub_status",
+			sql: `UPDATE user_books
+				SET owned = CASE WHEN sub_status = 'need_to_buy' THEN 0 ELSE 1 END, sub_status = NULL
+				WHERE sub_status IN ('need_to_buy', 'already_own', 'already_owned')`,
+		},
+		{
+			// Position in the user's reading queue, only set on the want to read shelf
+			name: "add_queue_position_to_user_books",
+			sql:  "ALTER TABLE user_books ADD COLUMN queue_position INTEGER DEFAULT NULL",
+		},
+		{
+			// Start each queue in the previous order: newest additions first
+			name: "backfill_queue_positions",
+			sql: `UPDATE user_books
+				SET queue_position = 1 + (
+					SELECT COUNT(*) FROM user_books o
+					WHERE o.user_id = user_books.user_id AND o.shelf = 'want_to_read'
+					  AND (COALESCE(o.added_at, '1970-01-01') > COALESCE(user_books.added_at, '1970-01-01')
+					       OR (COALESCE(o.added_at, '1970-01-01') = COALESCE(user_books.added_at, '1970-01-01') AND o.id > user_books.id))
+				)
+				WHERE shelf = 'want_to_read'`,
+		},
+		{
+			name: "create_user_book_tags_table",
+			sql: `CREATE TABLE IF NOT EXISTS user_book_tags (
+				user_book_id INTEGER NOT NULL,
+				tag TEXT NOT NULL,
+				PRIMARY KEY (user_book_id, tag),
+				FOREIGN KEY (user_book_id) REFERENCES user_books(id) ON DELETE CASCADE
+			)`,
+		},
+		{
+			name: "create_reading_goals_table",
+			sql: `CREATE TABLE IF NOT EXISTS reading_goals (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				year INTEGER NOT NULL,
+				books_goal INTEGER DEFAULT NULL CHECK(books_goal IS NULL OR books_goal > 0),
+				pages_goal INTEGER DEFAULT NULL CHECK(pages_goal IS NULL OR pages_goal > 0),
+				reached_at DATETIME DEFAULT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+				UNIQUE(user_id, year)
+			)`,
+		},
+		{
+			name: "add_categories_to_books",
+			sql:  "ALTER TABLE books ADD COLUMN categories TEXT DEFAULT NULL",
+		},
+		{
+			name: "create_import_jobs_table",
+			sql: `CREATE TABLE IF NOT EXISTS import_jobs (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				source TEXT NOT NULL,
+				filename TEXT NOT NULL DEFAULT '',
+				status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'running', 'done', 'failed')),
+				error TEXT DEFAULT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				finished_at DATETIME DEFAULT NULL,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			)`,
+		},
+		{
+			name: "create_import_rows_table",
+			sql: `CREATE TABLE IF NOT EXISTS import_rows (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				job_id INTEGER NOT NULL,
+				line INTEGER NOT NULL,
+				data TEXT NOT NULL,
+				status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'imported', 'unmatched', 'failed')),
+				book_id INTEGER DEFAULT NULL,
+				message TEXT DEFAULT NULL,
+				FOREIGN KEY (job_id) REFERENCES import_jobs(id) ON DELETE CASCADE,
+				FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE SET NULL
+			)`,
+		},
+		{
+			name: "create_import_rows_job_id_index",
+			sql:  "CREATE INDEX IF NOT EXISTS idx_import_rows_job_id ON import_rows(job_id)",
+		},
+		{
+			// Jobs can stop after matching so the user can review matches before
+			// anything is shelved
+			name: "add_review_to_import_jobs",
+			sql:  "ALTER TABLE import_jobs ADD COLUMN review INTEGER NOT NULL DEFAULT 0 CHECK(review IN (0, 1))",
+		},
+		{
+			name: "add_review_statuses_to_import_tables",
+			fn: func() error {
+				err := rebuildTable("import_jobs", `CREATE TABLE import_jobs_new (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					user_id INTEGER NOT NULL,
+					source TEXT NOT NULL,
+					filename TEXT NOT NULL DEFAULT '',
+					status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'running', 'review', 'done', 'failed')),
+					error TEXT DEFAULT NULL,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					finished_at DATETIME DEFAULT NULL,
+					review INTEGER NOT NULL DEFAULT 0 CHECK(review IN (0, 1)),
+					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+				)`, []string{"id", "user_id", "source", "filename", "status", "error", "created_at", "finished_at", "review"})
+				if err != nil {
+					return err
+				}
+				err = rebuildTable("import_rows", `CREATE TABLE import_rows_new (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					job_id INTEGER NOT NULL,
+					line INTEGER NOT NULL,
+					data TEXT NOT NULL,
+					status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'matched', 'imported', 'unmatched', 'skipped', 'failed')),
+					book_id INTEGER DEFAULT NULL,
+					message TEXT DEFAULT NULL,
+					FOREIGN KEY (job_id) REFERENCES import_jobs(id) ON DELETE CASCADE,
+					FOREIGN KEY (book_id) REFERENCES books(id) ON DELETE SET NULL
+				)`, []string{"id", "job_id", "line", "data", "status", "book_id", "message"})
+				if err != nil {
+					return err
+				}
+				_, err = DB.Exec("CREATE INDEX IF NOT EXISTS idx_import_rows_job_id ON import_rows(job_id)")
+				return err
+			},
+		},
+		{
+			// Generic CSV files are kept in upload until the user has said which
+			// column holds what; quiet imports don't post to the activity feed
+			name: "add_mapping_to_import_jobs",
+			fn: func() error {
+				return rebuildTable("import_jobs", `CREATE TABLE import_jobs_new (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					user_id INTEGER NOT NULL,
+					source TEXT NOT NULL,
+					filename TEXT NOT NULL DEFAULT '',
+					status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('mapping', 'pending', 'running', 'review', 'done', 'failed')),
+					error TEXT DEFAULT NULL,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					finished_at DATETIME DEFAULT NULL,
+					review INTEGER NOT NULL DEFAULT 0 CHECK(review IN (0, 1)),
+					quiet INTEGER NOT NULL DEFAULT 0 CHECK(quiet IN (0, 1)),
+					upload TEXT DEFAULT NULL,
+					FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+				)`, []string{"id", "user_id", "source", "filename", "status", "error", "created_at", "finished_at", "review"})
+			},
+		},
+		{
+			// Archives of everything stored about a user, built in the
+			// background; the file itself is kept on disk under filename
+			name: "create_data_exports_table",
+			sql: `CREATE TABLE IF NOT EXISTS data_exports (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				requested_by TEXT NOT NULL DEFAULT 'user' CHECK(requested_by IN ('user', 'admin')),
+				status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'running', 'done', 'failed')),
+				filename TEXT DEFAULT NULL,
+				size INTEGER NOT NULL DEFAULT 0,
+				error TEXT DEFAULT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				finished_at DATETIME DEFAULT NULL,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			)`,
+		},
+		{
+			name: "create_data_exports_user_id_index",
+			sql:  "CREATE INDEX IF NOT EXISTS idx_data_exports_user_id ON data_exports(user_id)",
+		},
+		{
+			// Kindle highlights whose book couldn't be found on the user's
+			// shelves, kept until the user picks the book or discards them
+			name: "create_pending_clippings_table",
+			sql: `CREATE TABLE IF NOT EXISTS pending_clippings (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				title TEXT NOT NULL,
+				authors TEXT NOT NULL DEFAULT '',
+				clippings TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			)`,
+		},
+		{
+			name: "create_pending_clippings_user_id_index",
+			sql:  "CREATE INDEX IF NOT EXISTS idx_pending_clippings_user_id ON pending_clippings(user_id)",
+		},
+		{
+			name: "create_actor_keys_table",
+			sql: `CREATE TABLE IF NOT EXISTS actor_keys (
+				user_id INTEGER PRIMARY KEY,
+				public_key TEXT NOT NULL,
+				private_key TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			)`,
+		},
+		{
+			name: "create_remote_followers_table",
+			sql: `CREATE TABLE IF NOT EXISTS remote_followers (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				actor_id TEXT NOT NULL,
+				inbox TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+				UNIQUE(user_id, actor_id)
+			)`,
+		},
+		{
+			name: "create_activitypub_delivery_table",
+			sql: `CREATE TABLE IF NOT EXISTS activitypub_delivery (
+				id INTEGER PRIMARY KEY CHECK(id = 1),
+				last_event_id INTEGER NOT NULL
+			)`,
+		},
+		{
+			name: "create_follows_table",
+			sql: `CREATE TABLE IF NOT EXISTS follows (
+				follower_id INTEGER NOT NULL,
+				followed_id INTEGER NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (follower_id, followed_id),
+				FOREIGN KEY (follower_id) REFERENCES users(id) ON DELETE CASCADE,
+				FOREIGN KEY (followed_id) REFERENCES users(id) ON DELETE CASCADE
+			)`,
+		},
+		{
+			name: "create_follows_followed_index",
+			sql:  `CREATE INDEX IF NOT EXISTS idx_follows_followed ON follows(followed_id)`,
+		},
+		{
+			name: "create_event_reactions_table",
+			sql: `CREATE TABLE IF NOT EXISTS event_reactions (
+				event_id INTEGER NOT NULL,
+				user_id INTEGER NOT NULL,
+				emoji TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (event_id, user_id, emoji),
+				FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			)`,
+		},
+		{
+			name: "create_event_comments_table",
+			sql: `CREATE TABLE IF NOT EXISTS event_comments (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				event_id INTEGER NOT NULL,
+				user_id INTEGER NOT NULL,
+				parent_id INTEGER,
+				body TEXT NOT NULL,
+				removed TEXT CHECK(removed IN ('author', 'admin')),
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+				FOREIGN KEY (parent_id) REFERENCES event_comments(id) ON DELETE CASCADE
+			)`,
+		},
+		{
+			name: "create_event_comments_event_index",
+			sql:  "CREATE INDEX IF NOT EXISTS idx_event_comments_event_id ON event_comments(event_id)",
+		},
+		{
+			name: "create_notifications_table",
+			sql: `CREATE TABLE IF NOT EXISTS notifications (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL,
+				actor_id INTEGER NOT NULL,
+				kind TEXT NOT NULL CHECK(kind IN ('reaction', 'comment', 'reply')),
+				event_id INTEGER NOT NULL,
+				comment_id INTEGER,
+				read_at DATETIME,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+				FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE CASCADE,
+				FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE,
+				FOREIGN KEY (comment_id) REFERENCES event_comments(id) ON DELETE CASCADE
+			)`,
+		},
+		{
+			name: "create_notifications_user_index",
+			sql:  "CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications(user_id, read_at)",
+		},
+		{
+			// Whole-star ratings were moved to rating_points by
+			// backfill_rating_points_from_rating and are no longer kept
+			name: "drop_rating_from_user_books",
+			sql:  "ALTER TABLE user_books DROP COLUMN rating",
+		},
+		{
+			// Activities waiting to be delivered to remote inboxes, kept
+			// until they are sent so a restart doesn't lose them
+			name: "create_activitypub_outbox_table",
+			sql: `CREATE TABLE IF NOT EXISTS activitypub_outbox (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				inbox TEXT NOT NULL,
+				user_id INTEGER NOT NULL,
+				activity TEXT NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			)`,
+		},
+		{
+			name: "create_activitypub_outbox_inbox_index",
+			sql:  "CREATE INDEX IF NOT EXISTS idx_activitypub_outbox_inbox ON activitypub_outbox(inbox)",
+		},
+		{
+			// Whether a user's shelves are offered as an OPDS catalog
+			name: "add_catalog_public_to_users",
+			sql:  "ALTER TABLE users ADD COLUMN catalog_public INTEGER NOT NULL DEFAULT 1",
+		},
 	}
+}
 
+// MigrationNames returns the names of the migrations this build knows, so a
+// database from elsewhere can be checked against them
+func MigrationNames() []string {
+	var names []string
+	for _, m := range migrations() {
+		names = append(names, m.name)
+	}
+	return names
+}
+
+func runMigrations() error {
 	// Create migrations table if not exists
 	_, err := DB.Exec(`
 		CREATE TABLE IF NOT EXISTS schema_migrations (
@@ -146,7 +567,7 @@ func runMigrations() error {
 		return err
 	}
 
-	for _, m := range migrations {
+	for _, m := range migrations() {
 		// Check if migration already applied
 		var count int
 		err := DB.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE name = ?", m.name).Scan(&count)
@@ -158,7 +579,11 @@ func runMigrations() error {
 		}
 
 		// Apply migration
-		_, err = DB.Exec(m.sql)
+		if m.fn != nil {
+			err = m.fn()
+		} else {
+			_, err = DB.Exec(m.sql)
+		}
 		if err != nil {
 			// Ignore "duplicate column" errors for idempotent migrations
 			continue
@@ -173,3 +598,43 @@ func runMigrations() error {
 
 	return nil
 }
+
+// rebuildTable replaces a table with a new definition, keeping its rows.
+// SQLite cannot change constraints with ALTER TABLE, so the new table is
+// created as <table>_new, filled from the old one and renamed into place.
+// Foreign keys are switched off meanwhile so dependent rows survive the drop.
+func rebuildTable(table, createSQL string, columns []string) error {
+	ctx := context.Background()
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The pragma has no effect inside a transaction, so set it on the connection first
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	cols := strings.Join(columns, ", ")
+	statements := []string{
+		"DROP TABLE IF EXISTS " + table + "_new",
+		createSQL,
+		"INSERT INTO " + table + "_new (" + cols + ") SELECT " + cols + " FROM " + table,
+		"DROP TABLE " + table,
+		"ALTER TABLE " + table + "_new RENAME TO " + table,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
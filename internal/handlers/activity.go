@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nuuner/spines/internal/models"
+)
+
+// ActivityDay lists what a user did on a day of their activity calendar (HTMX partial)
+func ActivityDay(c *fiber.Ctx) error {
+	day, err := time.ParseInLocation("2006-01-02", c.Params("date"), time.Local)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid date")
+	}
+
+	user, err := models.GetUserByUsername(c.Params("username"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(fiber.StatusNotFound).SendString("User not found")
+		}
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading user")
+	}
+
+	events, err := models.GetUserActivityOn(user.ID, day)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading activity")
+	}
+
+	return c.Render("partials/activity_day", fiber.Map{
+		"Date":   day,
+		"Events": events,
+	})
+}
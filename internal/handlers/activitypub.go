@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nuuner/spines/internal/activitypub"
+	"github.com/nuuner/spines/internal/models"
+)
+
+// Number of activities per page of an outbox
+const outboxPageSize = 20
+
+// webFingerLink is a link in a WebFinger response
+type webFingerLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type"`
+	Href string `json:"href"`
+}
+
+// sendActivityPub writes an ActivityPub document
+func sendActivityPub(c *fiber.Ctx, doc interface{}) error {
+	return c.JSON(doc, activitypub.ContentType)
+}
+
+// federatedUser loads the user whose actor is requested, when federation is on
+func federatedUser(c *fiber.Ctx) (*models.User, error) {
+	if !activitypub.Enabled() {
+		return nil, fiber.ErrNotFound
+	}
+	return publicUser(c)
+}
+
+// WebFinger resolves an account such as acct:ann@books.example.com, or an
+// actor's URL, to the user's actor
+func WebFinger(c *fiber.Ctx) error {
+	if !activitypub.Enabled() {
+		return fiber.ErrNotFound
+	}
+
+	resource := c.Query("resource")
+	var username string
+	if account, found := strings.CutPrefix(resource, "acct:"); found {
+		name, host, _ := strings.Cut(account, "@")
+		if !strings.EqualFold(host, activitypub.Host()) {
+			return fiber.ErrNotFound
+		}
+		username = name
+	} else if name, found := strings.CutPrefix(resource, activitypub.ActorID("")); found {
+		username = name
+	}
+	if username == "" {
+		return c.Status(fiber.StatusBadRequest).SendString("Unknown resource")
+	}
+
+	user, err := models.GetUserByUsername(username)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fiber.ErrNotFound
+		}
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading user")
+	}
+
+	actor := activitypub.ActorID(user.Username)
+	c.Set(fiber.HeaderAccessControlAllowOrigin, "*")
+	return c.JSON(fiber.Map{
+		"subject": "acct:" + user.Username + "@" + activitypub.Host(),
+		"aliases": []string{actor},
+		"links": []webFingerLink{
+			{Rel: "self", Type: activitypub.ContentType, Href: actor},
+			{Rel: "http://webfinger.net/rel/profile-page", Type: "text/html", Href: actor},
+		},
+	}, "application/jrd+json")
+}
+
+// ActivityPubActor serves a user's actor document to clients asking for
+// ActivityPub at their profile URL, and passes everyone else on to the page
+func ActivityPubActor(c *fiber.Ctx) error {
+	c.Vary(fiber.HeaderAccept)
+	accept := c.Get(fiber.HeaderAccept)
+	if !activitypub.Enabled() ||
+		(!strings.Contains(accept, activitypub.ContentType) && !strings.Contains(accept, "application/ld+json")) {
+		return c.Next()
+	}
+
+	user, err := publicUser(c)
+	if err != nil {
+		return err
+	}
+	actor, err := activitypub.NewActor(user)
+	if err != nil {
+		log.Printf("Error loading actor key for %s: %v", user.Username, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading actor")
+	}
+	return sendActivityPub(c, actor)
+}
+
+// ActivityPubOutbox serves a user's outbox: the activities of their events
+// about books, newest first, in pages continuing from ?max_id
+func ActivityPubOutbox(c *fiber.Ctx) error {
+	user, err := federatedUser(c)
+	if err != nil {
+		return err
+	}
+	id := activitypub.ActorID(user.Username) + "/outbox"
+
+	if c.Query("page") == "" {
+		total, err := models.CountUserBookEvents(user.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Error loading activities")
+		}
+		return sendActivityPub(c, activitypub.NewCollection(id, total, id+"?page=true"))
+	}
+
+	maxID, _ := strconv.ParseInt(c.Query("max_id"), 10, 64)
+	events, err := models.GetUserBookEvents(user.ID, maxID, outboxPageSize)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading activities")
+	}
+
+	pageID := id + "?page=true"
+	if maxID > 0 {
+		pageID += "&max_id=" + strconv.FormatInt(maxID, 10)
+	}
+	page := activitypub.NewCollectionPage(pageID, id)
+	for _, ev := range events {
+		if activity := activitypub.NewActivity(ev); activity != nil {
+			activity.Context = ""
+			page.OrderedItems = append(page.OrderedItems, activity)
+		}
+	}
+	if len(events) == outboxPageSize {
+		page.Next = id + "?page=true&max_id=" + strconv.FormatInt(events[len(events)-1].ID, 10)
+	}
+	return sendActivityPub(c, page)
+}
+
+// ActivityPubActivity serves a single activity from a user's outbox
+func ActivityPubActivity(c *fiber.Ctx) error {
+	user, err := federatedUser(c)
+	if err != nil {
+		return err
+	}
+	eventID, err := strconv.ParseInt(c.Params("event_id"), 10, 64)
+	if err != nil {
+		return fiber.ErrNotFound
+	}
+	ev, err := models.GetUserEvent(user.ID, eventID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fiber.ErrNotFound
+		}
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading activity")
+	}
+	activity := activitypub.NewActivity(*ev)
+	if activity == nil {
+		return fiber.ErrNotFound
+	}
+	return sendActivityPub(c, activity)
+}
+
+// ActivityPubFollowers serves the size of a user's remote following; who
+// follows them isn't listed
+func ActivityPubFollowers(c *fiber.Ctx) error {
+	user, err := federatedUser(c)
+	if err != nil {
+		return err
+	}
+	count, err := models.CountRemoteFollowers(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading followers")
+	}
+	return sendActivityPub(c, activitypub.NewCollection(activitypub.ActorID(user.Username)+"/followers", count, ""))
+}
+
+// ActivityPubInbox receives activities from other servers for a user. Only
+// requests signed by the activity's actor are accepted.
+func ActivityPubInbox(c *fiber.Ctx) error {
+	user, err := federatedUser(c)
+	if err != nil {
+		return err
+	}
+
+	body := append([]byte(nil), c.Body()...)
+	sender, err := activitypub.Verify(activitypub.SignedRequest{
+		Method: c.Method(),
+		Target: c.OriginalURL(),
+		Header: func(name string) string { return c.Get(name) },
+		Body:   body,
+	})
+	if err != nil {
+		log.Printf("ActivityPub: rejected request to %s's inbox from %s: %v", user.Username, c.IP(), err)
+		return c.Status(fiber.StatusUnauthorized).SendString("Invalid signature")
+	}
+
+	if err := activitypub.HandleInbox(user, sender, body); err != nil {
+		if err == activitypub.ErrWrongActor {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+		log.Printf("ActivityPub: error handling activity from %s: %v", sender.ID, err)
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid activity")
+	}
+	return c.SendStatus(fiber.StatusAccepted)
+}
@@ -68,8 +68,14 @@ func AdminEditUser(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).SendString("Error loading user")
 	}
 
+	exports, err := models.GetUserDataExports(id, recentDataExports)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading user")
+	}
+
 	return c.Render("pages/admin/edit_user", NavData(c, fiber.Map{
 		"User":    user,
+		"Exports": exports,
 		"Error":   c.Query("error"),
 		"Success": c.Query("success"),
 		// SEO metadata
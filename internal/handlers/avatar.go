@@ -18,7 +18,7 @@ import (
 )
 
 const (
-	avatarDir     = "./web/static/uploads/avatars"
+	avatarDir     = models.AvatarDir
 	maxAvatarSize = 2 * 1024 * 1024 // 2MB
 	avatarSize    = 200             // 200x200 pixels
 )
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"log"
+	"net/url"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nuuner/spines/internal/backup"
+	"github.com/nuuner/spines/internal/config"
+	"github.com/nuuner/spines/internal/models"
+)
+
+type BackupHandler struct {
+	Config *config.Config
+}
+
+func NewBackupHandler(cfg *config.Config) *BackupHandler {
+	return &BackupHandler{Config: cfg}
+}
+
+// BackupsPage lists the instance backups, newest first
+func (h *BackupHandler) BackupsPage(c *fiber.Ctx) error {
+	archives, err := backup.List(h.Config.BackupPath)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading backups")
+	}
+
+	return c.Render("pages/admin/backups", NavData(c, fiber.Map{
+		"Backups": archives,
+		"Keep":    h.Config.BackupKeep,
+		"Error":   c.Query("error"),
+		"Success": c.Query("success"),
+		// SEO metadata
+		"PageTitle":  "Backups",
+		"MetaRobots": "noindex, nofollow",
+	}), "layouts/base")
+}
+
+// CreateBackup backs up the database and uploads while the server keeps
+// running, removing the oldest backups beyond the configured number
+func (h *BackupHandler) CreateBackup(c *fiber.Ctx) error {
+	archive, err := backup.Create(h.Config.BackupPath, models.UploadsDir, h.Config.BackupKeep)
+	if err != nil {
+		log.Printf("[backup] Backup failed: %v", err)
+		return c.Redirect("/admin/backups?error=Backup+failed")
+	}
+	log.Printf("[backup] Created %s", archive.Name)
+	return c.Redirect("/admin/backups?success=" + url.QueryEscape("Created "+archive.Name+"."))
+}
+
+// DownloadBackup downloads a backup archive
+func (h *BackupHandler) DownloadBackup(c *fiber.Ctx) error {
+	path, ok := backup.Path(h.Config.BackupPath, c.Params("name"))
+	if !ok {
+		return c.Status(fiber.StatusNotFound).SendString("Backup not found")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Backup not found")
+	}
+	return c.Download(path, c.Params("name"))
+}
@@ -131,19 +131,14 @@ func (h *BooksHandler) AddBook(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Redirect("/admin/users/" + c.Params("id") + "/books?error=Failed+to+create+book")
 	}
+	_ = models.FetchBookCategories(book.ID, book.GoogleBooksID, h.Config.GoogleBooksAPIKey)
 
 	var nullSubStatus sql.NullString
 	if subStatus != "" {
 		nullSubStatus = sql.NullString{String: subStatus, Valid: true}
 	}
 
-	var nullRating sql.NullInt64
-	if ratingStr != "" {
-		rating, err := strconv.ParseInt(ratingStr, 10, 64)
-		if err == nil && rating >= 1 && rating <= 5 {
-			nullRating = sql.NullInt64{Int64: rating, Valid: true}
-		}
-	}
+	nullRating := parseRating(ratingStr)
 
 	err = models.AddBookToShelf(userID, book.ID, shelf, nullSubStatus, nullRating)
 	if err != nil {
@@ -152,6 +147,9 @@ func (h *BooksHandler) AddBook(c *fiber.Ctx) error {
 
 	// Create event for book added to shelf
 	_ = models.CreateBookAddedEvent(userID, book.ID, shelf)
+	if shelf == "read" {
+		_ = models.CheckReadingGoals(userID)
+	}
 
 	return c.Redirect("/admin/users/" + c.Params("id") + "/books")
 }
@@ -191,24 +189,26 @@ func (h *BooksHandler) UpdateBook(c *fiber.Ctx) error {
 		nullSubStatus = sql.NullString{String: subStatus, Valid: true}
 	}
 
-	var nullRating sql.NullInt64
-	if ratingStr != "" {
-		rating, err := strconv.ParseInt(ratingStr, 10, 64)
-		if err == nil && rating >= 1 && rating <= 5 {
-			nullRating = sql.NullInt64{Int64: rating, Valid: true}
-		}
-	}
+	nullRating := parseRating(ratingStr)
 
 	err = models.UpdateUserBook(userID, bookID, shelf, nullSubStatus, nullRating)
 	if err != nil {
 		return c.Redirect("/admin/users/" + c.Params("id") + "/books?error=Failed+to+update+book")
 	}
 
+	// Only touch ownership when the form carries it, so shelf-only updates keep it
+	if args := c.Request().PostArgs(); args.Has("format") || args.Has("owned") {
+		format, owned := parseOwnership(c)
+		if err := models.UpdateUserBookOwnership(userID, bookID, format, owned); err != nil {
+			return c.Redirect("/admin/users/" + c.Params("id") + "/books?error=Failed+to+update+book")
+		}
+	}
+
 	// Create appropriate event based on what changed
 	if currentBook != nil {
 		if oldShelf != shelf {
-			// Shelf changed - create book moved event
-			_ = models.CreateBookMovedEvent(userID, bookID, oldShelf, shelf)
+			// Shelf changed - create book moved (or paused/DNF) event
+			createShelfChangeEvent(userID, bookID, oldShelf, shelf, sql.NullInt64{}, sql.NullInt64{})
 		} else if oldSubStatus != subStatus && shelf == "currently_reading" {
 			// Reading progress changed
 			_ = models.CreateReadingProgressEvent(userID, bookID, oldSubStatus, subStatus)
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nuuner/spines/internal/models"
+)
+
+// BulkUpdate applies one action to every selected book on /my-books. The form
+// holds one book_id value per selected book and an action: move (to shelf),
+// remove, tag (with tag) or dates (setting date_field to date).
+func (h *UserBooksHandler) BulkUpdate(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var bookIDs []int64
+	for _, value := range c.Request().PostArgs().PeekMulti("book_id") {
+		bookID, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid book ID")
+		}
+		bookIDs = append(bookIDs, bookID)
+	}
+	if len(bookIDs) == 0 {
+		return c.Redirect("/my-books?error=No+books+selected")
+	}
+
+	var count int
+	var err error
+	switch c.FormValue("action") {
+	case models.BulkMove:
+		shelf := c.FormValue("shelf")
+		if !isValidShelf(shelf) {
+			return c.Redirect("/my-books?error=Invalid+shelf")
+		}
+		count, err = models.BulkMoveBooks(user.ID, bookIDs, shelf)
+	case models.BulkRemove:
+		count, err = models.BulkRemoveBooks(user.ID, bookIDs)
+	case models.BulkTag:
+		tag := models.NormalizeTag(c.FormValue("tag"))
+		if tag == "" {
+			return c.Redirect("/my-books?error=Tag+is+required")
+		}
+		count, err = models.BulkTagBooks(user.ID, bookIDs, tag)
+	case models.BulkDates:
+		field := c.FormValue("date_field")
+		if _, ok := models.BulkDateFields[field]; !ok {
+			return c.Redirect("/my-books?error=Invalid+date")
+		}
+		date, parseErr := time.ParseInLocation("2006-01-02", c.FormValue("date"), time.Local)
+		if parseErr != nil {
+			return c.Redirect("/my-books?error=Invalid+date")
+		}
+		count, err = models.BulkSetDates(user.ID, bookIDs, field, date.Format("2006-01-02 15:04:05"))
+	default:
+		return c.Redirect("/my-books?error=Invalid+bulk+action")
+	}
+	if err != nil {
+		return c.Redirect("/my-books?error=Failed+to+update+books")
+	}
+	_ = models.CheckReadingGoals(user.ID)
+
+	if count == 1 {
+		return c.Redirect("/my-books?success=Updated+1+book")
+	}
+	return c.Redirect("/my-books?success=Updated+" + strconv.Itoa(count) + "+books")
+}
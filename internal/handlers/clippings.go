@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nuuner/spines/internal/importer"
+	"github.com/nuuner/spines/internal/models"
+)
+
+// shelvedBooks returns all of a user's books, by title, for matching
+// highlights and choosing their book
+func shelvedBooks(userID int64) ([]models.UserBook, error) {
+	shelves, err := models.GetUserBooks(userID)
+	if err != nil {
+		return nil, err
+	}
+	var books []models.UserBook
+	for _, shelf := range [][]models.UserBook{shelves.WantToRead, shelves.CurrentlyReading, shelves.Read, shelves.OnHold, shelves.DidNotFinish} {
+		books = append(books, shelf...)
+	}
+	sort.SliceStable(books, func(i, j int) bool {
+		return strings.ToLower(books[i].Book.Title) < strings.ToLower(books[j].Book.Title)
+	})
+	return books, nil
+}
+
+// ClippingsPage shows the Kindle highlights upload form and the books whose
+// highlights are waiting for the user to pick the book
+func (h *UserBooksHandler) ClippingsPage(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	pending, err := models.GetPendingClippings(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading highlights")
+	}
+	books, err := shelvedBooks(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading books")
+	}
+
+	return c.Render("pages/user/clippings", NavData(c, fiber.Map{
+		"User":    user,
+		"Pending": pending,
+		"Books":   books,
+		"Error":   c.Query("error"),
+		"Success": c.Query("success"),
+		// SEO metadata
+		"PageTitle":  "Import Kindle Highlights",
+		"MetaRobots": "noindex, nofollow",
+	}), "layouts/base")
+}
+
+// ImportClippings reads an uploaded "My Clippings.txt", saving the highlights
+// of books found on the user's shelves as quotes and keeping the rest for
+// review
+func (h *UserBooksHandler) ImportClippings(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Redirect("/my-books/quotes/import?error=No+file+uploaded")
+	}
+	if file.Size > maxImportSize {
+		return c.Redirect("/my-books/quotes/import?error=File+too+large.+Maximum+size+is+4MB")
+	}
+	src, err := file.Open()
+	if err != nil {
+		return c.Redirect("/my-books/quotes/import?error=Failed+to+read+file")
+	}
+	defer src.Close()
+
+	clippingBooks, skippedNotes, err := importer.ParseClippings(src)
+	switch {
+	case err == importer.ErrTooManyRows:
+		return c.Redirect("/my-books/quotes/import?error=File+has+more+than+" + strconv.Itoa(importer.MaxRows) + "+clippings")
+	case err != nil:
+		return c.Redirect("/my-books/quotes/import?error=Could+not+read+the+file")
+	case len(clippingBooks) == 0:
+		return c.Redirect("/my-books/quotes/import?error=No+highlights+found+in+the+file")
+	}
+
+	shelved, err := shelvedBooks(user.ID)
+	if err != nil {
+		return c.Redirect("/my-books/quotes/import?error=Failed+to+import+highlights")
+	}
+
+	saved, matched, unmatched := 0, 0, 0
+	for _, book := range clippingBooks {
+		ub := importer.MatchClippingBook(book, shelved)
+		if ub == nil {
+			if err := models.SavePendingClippings(user.ID, book); err != nil {
+				log.Printf("[clippings] Failed to keep highlights of %q for user %d: %v", book.Title, user.ID, err)
+				return c.Redirect("/my-books/quotes/import?error=Failed+to+import+highlights")
+			}
+			unmatched++
+			continue
+		}
+		n, err := models.SaveClippings(ub.ID, book.Clippings)
+		if err != nil {
+			log.Printf("[clippings] Failed to save highlights of %q for user %d: %v", book.Title, user.ID, err)
+			return c.Redirect("/my-books/quotes/import?error=Failed+to+import+highlights")
+		}
+		saved += n
+		matched++
+	}
+
+	message := "Saved " + strconv.Itoa(saved) + " new highlights from " + strconv.Itoa(matched) + " books on your shelves."
+	if unmatched > 0 {
+		message += " Books not found on your shelves: " + strconv.Itoa(unmatched) + ", choose them below."
+	}
+	if skippedNotes > 0 {
+		message += " Notes skipped for lacking a highlight: " + strconv.Itoa(skippedNotes) + "."
+	}
+	return c.Redirect("/my-books/quotes/import?success=" + url.QueryEscape(message))
+}
+
+// pendingClipping loads the book of highlights under review named in the URL
+func pendingClipping(c *fiber.Ctx, userID int64) (*models.ClippingBook, error) {
+	id, err := strconv.ParseInt(c.Params("pending_id"), 10, 64)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid ID")
+	}
+	book, err := models.GetPendingClipping(userID, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fiber.NewError(fiber.StatusNotFound, "Highlights not found")
+		}
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Error loading highlights")
+	}
+	return book, nil
+}
+
+// AssignClippings saves highlights under review to the book the user chose
+func (h *UserBooksHandler) AssignClippings(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	pending, err := pendingClipping(c, user.ID)
+	if err != nil {
+		return err
+	}
+	bookID, err := strconv.ParseInt(c.FormValue("book_id"), 10, 64)
+	if err != nil {
+		return c.Redirect("/my-books/quotes/import?error=Choose+a+book+for+the+highlights")
+	}
+	ub, err := models.GetUserBook(user.ID, bookID)
+	if err != nil {
+		return c.Redirect("/my-books/quotes/import?error=Book+not+found+on+your+shelves")
+	}
+
+	saved, err := models.SaveClippings(ub.ID, pending.Clippings)
+	if err != nil {
+		return c.Redirect("/my-books/quotes/import?error=Failed+to+save+highlights")
+	}
+	if err := models.DeletePendingClipping(user.ID, pending.ID); err != nil {
+		return c.Redirect("/my-books/quotes/import?error=Failed+to+save+highlights")
+	}
+	return c.Redirect("/my-books/quotes/import?success=" + url.QueryEscape("Saved "+strconv.Itoa(saved)+" new highlights."))
+}
+
+// DiscardClippings drops highlights under review without saving them
+func (h *UserBooksHandler) DiscardClippings(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	pending, err := pendingClipping(c, user.ID)
+	if err != nil {
+		return err
+	}
+	if err := models.DeletePendingClipping(user.ID, pending.ID); err != nil {
+		return c.Redirect("/my-books/quotes/import?error=Failed+to+discard+highlights")
+	}
+	return c.Redirect("/my-books/quotes/import")
+}
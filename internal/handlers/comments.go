@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nuuner/spines/internal/models"
+)
+
+// Maximum length of a comment on an activity event, in characters
+const maxEventCommentLength = 2000
+
+// Number of comments shown for moderation
+const moderationCommentsLimit = 100
+
+// loadEvent loads the event an activity page or action is about
+func loadEvent(c *fiber.Ctx) (*models.Event, error) {
+	eventID, err := strconv.ParseInt(c.Params("event_id"), 10, 64)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid event ID")
+	}
+	event, err := models.GetEvent(eventID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fiber.NewError(fiber.StatusNotFound, "Activity not found")
+		}
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Error loading activity")
+	}
+	return event, nil
+}
+
+func eventURL(eventID int64) string {
+	return "/events/" + strconv.FormatInt(eventID, 10)
+}
+
+// EventPage shows an activity event with its reactions and comment threads
+func EventPage(c *fiber.Ctx) error {
+	event, err := loadEvent(c)
+	if err != nil {
+		return err
+	}
+
+	var currentID int64
+	if current, ok := c.Locals("CurrentUser").(*models.User); ok {
+		currentID = current.ID
+	}
+	reactions, err := models.GetEventReactions(event.ID, currentID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading reactions")
+	}
+	comments, err := models.GetEventComments(event.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading comments")
+	}
+
+	return c.Render("pages/event", NavData(c, fiber.Map{
+		"Event":            event,
+		"Reactions":        reactions,
+		"Comments":         comments,
+		"MaxCommentLength": maxEventCommentLength,
+		"Error":            c.Query("error"),
+		// SEO metadata
+		"PageTitle":  event.User.DisplayName + " " + event.EventDescription(),
+		"MetaRobots": "noindex, nofollow",
+	}), "layouts/base")
+}
+
+// ReactToEvent adds the current user's reaction to an event, or takes it back
+func ReactToEvent(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	event, err := loadEvent(c)
+	if err != nil {
+		return err
+	}
+	emoji := c.FormValue("emoji")
+	if !models.IsReactionEmoji(emoji) {
+		return c.Redirect(eventURL(event.ID) + "?error=Unknown+reaction")
+	}
+
+	if err := models.ToggleReaction(event, user.ID, emoji); err != nil {
+		return c.Redirect(eventURL(event.ID) + "?error=Failed+to+save+reaction")
+	}
+	return c.Redirect(eventURL(event.ID))
+}
+
+// AddEventComment adds the current user's comment to an event, or their
+// reply to one of its comments
+func AddEventComment(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	event, err := loadEvent(c)
+	if err != nil {
+		return err
+	}
+	pageURL := eventURL(event.ID)
+
+	body := strings.TrimSpace(c.FormValue("body"))
+	if body == "" {
+		return c.Redirect(pageURL + "?error=Comment+is+empty")
+	}
+	if utf8.RuneCountInString(body) > maxEventCommentLength {
+		return c.Redirect(pageURL + "?error=Comment+is+too+long")
+	}
+
+	var parentID sql.NullInt64
+	if parent := c.FormValue("parent_id"); parent != "" {
+		id, err := strconv.ParseInt(parent, 10, 64)
+		if err != nil {
+			return c.Redirect(pageURL + "?error=Invalid+comment+to+reply+to")
+		}
+		parentID = sql.NullInt64{Int64: id, Valid: true}
+	}
+
+	id, err := models.AddComment(event, user.ID, parentID, body)
+	if err != nil {
+		if err == models.ErrInvalidParent {
+			return c.Redirect(pageURL + "?error=The+comment+you+replied+to+is+gone")
+		}
+		return c.Redirect(pageURL + "?error=Failed+to+save+comment")
+	}
+	return c.Redirect(pageURL + "#comment-" + strconv.FormatInt(id, 10))
+}
+
+// DeleteEventComment removes one of the current user's own comments
+func DeleteEventComment(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	event, err := loadEvent(c)
+	if err != nil {
+		return err
+	}
+	commentID, err := strconv.ParseInt(c.Params("comment_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid comment ID")
+	}
+	comment, err := models.GetComment(commentID)
+	if err != nil || comment.EventID != event.ID || comment.UserID != user.ID {
+		return c.Status(fiber.StatusNotFound).SendString("Comment not found")
+	}
+
+	if err := models.RemoveComment(comment.ID, models.RemovedByAuthor); err != nil {
+		return c.Redirect(eventURL(event.ID) + "?error=Failed+to+delete+comment")
+	}
+	return c.Redirect(eventURL(event.ID))
+}
+
+// NotificationsPage lists the current user's notifications, marking them seen
+func NotificationsPage(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	notifications, err := models.GetNotifications(user.ID, 50)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading notifications")
+	}
+	if err := models.MarkNotificationsRead(user.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error updating notifications")
+	}
+
+	return c.Render("pages/user/notifications", NavData(c, fiber.Map{
+		"Notifications":       notifications,
+		"UnreadNotifications": 0,
+		// SEO metadata
+		"PageTitle":  "Notifications",
+		"MetaRobots": "noindex, nofollow",
+	}), "layouts/base")
+}
+
+// AdminCommentsPage lists the newest comments for moderation
+func AdminCommentsPage(c *fiber.Ctx) error {
+	comments, err := models.GetRecentComments(moderationCommentsLimit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading comments")
+	}
+
+	return c.Render("pages/admin/comments", NavData(c, fiber.Map{
+		"Comments": comments,
+		"Error":    c.Query("error"),
+		// SEO metadata
+		"PageTitle":  "Comments",
+		"MetaRobots": "noindex, nofollow",
+	}), "layouts/base")
+}
+
+// AdminRemoveComment removes a comment as a moderator, returning to the
+// activity page when removed from there
+func AdminRemoveComment(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid comment ID")
+	}
+	comment, err := models.GetComment(id)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Comment not found")
+	}
+
+	back := "/admin/comments"
+	if c.FormValue("from") == "event" {
+		back = eventURL(comment.EventID)
+	}
+	if err := models.RemoveComment(comment.ID, models.RemovedByAdmin); err != nil {
+		return c.Redirect(back + "?error=Failed+to+remove+comment")
+	}
+	return c.Redirect(back)
+}
@@ -53,20 +53,43 @@ func Dashboard(c *fiber.Ctx) error {
 		usersWithBooks[i], usersWithBooks[j] = usersWithBooks[j], usersWithBooks[i]
 	})
 
-	// Fetch latest 2 events per user for the activity feed
-	events, err := models.GetLatestNEventsPerUser(2, 50)
-	if err != nil {
-		events = []models.Event{}
+	// Signed in readers get a feed of the users they follow, which is the
+	// default once they follow someone, next to everyone's activity
+	feed := "everyone"
+	var events []models.Event
+	var nextBefore int64
+	if current, ok := c.Locals("CurrentUser").(*models.User); ok {
+		following, _ := models.CountFollowing(current.ID)
+		if c.Query("feed") == "following" || (c.Query("feed") == "" && following > 0) {
+			feed = "following"
+			events, nextBefore, err = followingFeed(current.ID, 0)
+			if err != nil {
+				events = []models.Event{}
+			}
+		}
+	}
+
+	if feed == "everyone" {
+		// Fetch latest 2 events per user for the activity feed
+		events, err = models.GetLatestNEventsPerUser(2, 50)
+		if err != nil {
+			events = []models.Event{}
+		}
 	}
+	// Reaction and comment counts are left out if they fail to load
+	_ = models.LoadEventCounts(events)
 
 	return c.Render("pages/dashboard", NavData(c, fiber.Map{
-		"Users":  usersWithBooks,
-		"Events": events,
+		"Users":      usersWithBooks,
+		"Events":     events,
+		"Feed":       feed,
+		"NextBefore": nextBefore,
 		// SEO metadata
 		"PageTitle":       "Readers",
 		"MetaDescription": "Discover book collections and reading lists on Spines",
 		"OGTitle":         "Spines - Track Your Reading",
 		"OGDescription":   "Discover book collections and reading lists on Spines",
 		"OGType":          "website",
+		"FeedLinks":       activityFeedLinks("Spines activity", ""),
 	}), "layouts/base")
 }
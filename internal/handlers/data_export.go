@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nuuner/spines/internal/archive"
+	"github.com/nuuner/spines/internal/models"
+)
+
+// recentDataExports is how many archives are listed on the profile and admin
+// pages; older ones expire soon enough anyway
+const recentDataExports = 5
+
+// dataExportsData returns a user's recent archives for the data export list,
+// and whether any is still being built
+func dataExportsData(userID int64) (fiber.Map, error) {
+	exports, err := models.GetUserDataExports(userID, recentDataExports)
+	if err != nil {
+		return nil, err
+	}
+	working := false
+	for _, e := range exports {
+		working = working || e.IsWorking()
+	}
+	return fiber.Map{"Exports": exports, "ExportWorking": working}, nil
+}
+
+// startDataExport queues an archive of a user's data unless one is already
+// being built, clearing out expired archives first
+func startDataExport(userID int64, requestedBy string) (bool, error) {
+	if err := archive.RemoveExpired(); err != nil {
+		log.Printf("[archive] Failed to remove expired exports: %v", err)
+	}
+	exportID, err := models.CreateDataExport(userID, requestedBy)
+	if err == models.ErrDataExportWorking {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	archive.Start(exportID)
+	return true, nil
+}
+
+// sendDataExport downloads an archive of the given user's data
+func sendDataExport(c *fiber.Ctx, userID int64, username string) error {
+	exportID, err := strconv.ParseInt(c.Params("export_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid export ID")
+	}
+	export, err := models.GetDataExport(exportID)
+	if err == sql.ErrNoRows || (err == nil && export.UserID != userID) {
+		return c.Status(fiber.StatusNotFound).SendString("Export not found")
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading export")
+	}
+	if !export.IsReady() {
+		return c.Status(fiber.StatusNotFound).SendString("This archive is not available")
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.Download(archive.Path(export), "spines-data-"+username+"-"+export.CreatedAt.Format("2006-01-02")+".zip")
+}
+
+// RequestDataExport starts building an archive of everything stored about
+// the user
+func RequestDataExport(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	started, err := startDataExport(user.ID, "user")
+	if err != nil {
+		return c.Redirect("/profile?error=Failed+to+start+the+export")
+	}
+	if !started {
+		return c.Redirect("/profile?error=An+archive+is+already+being+prepared")
+	}
+	return c.Redirect("/profile?success=Your+archive+is+being+prepared.+It+will+appear+below+when+ready.")
+}
+
+// DataExportsList returns the list of the user's archives, polled while one
+// is being built
+func DataExportsList(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	data, err := dataExportsData(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading exports")
+	}
+	return c.Render("partials/data_exports", data)
+}
+
+// DownloadDataExport downloads one of the user's archives
+func DownloadDataExport(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	return sendDataExport(c, user.ID, user.Username)
+}
+
+// AdminRequestDataExport starts building an archive of a user's data on
+// their behalf
+func AdminRequestDataExport(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid user ID")
+	}
+	if _, err := models.GetUserByID(id); err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("User not found")
+	}
+
+	redirectURL := "/admin/users/" + c.Params("id") + "/edit"
+	started, err := startDataExport(id, "admin")
+	if err != nil {
+		return c.Redirect(redirectURL + "?error=Failed+to+start+the+export")
+	}
+	if !started {
+		return c.Redirect(redirectURL + "?error=An+archive+is+already+being+prepared")
+	}
+	return c.Redirect(redirectURL + "?success=Data+export+started.+Reload+this+page+to+see+when+it+is+ready.")
+}
+
+// AdminDownloadDataExport downloads an archive of a user's data
+func AdminDownloadDataExport(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid user ID")
+	}
+	user, err := models.GetUserByID(id)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("User not found")
+	}
+	return sendDataExport(c, user.ID, user.Username)
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nuuner/spines/internal/models"
+)
+
+// ExportLibrary downloads the user's whole library, as a Goodreads-style CSV
+// by default or as JSON with format=json
+func (h *UserBooksHandler) ExportLibrary(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	format := c.Query("format", "csv")
+	if format != "csv" && format != "json" {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid export format")
+	}
+
+	export, err := models.GetLibraryExport(user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading books")
+	}
+
+	filename := "spines-" + user.Username + "-" + time.Now().Format("2006-01-02") + "." + format
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+
+	if format == "json" {
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+		enc := json.NewEncoder(c)
+		enc.SetIndent("", "  ")
+		return enc.Encode(export)
+	}
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	return export.WriteGoodreadsCSV(c)
+}
@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nuuner/spines/internal/models"
+)
+
+// Number of events in an activity feed
+const feedLength = 50
+
+// publicURL is where the instance is reached from outside, see SetPublicURL
+var publicURL string
+
+// SetPublicURL sets the address feeds and catalogs link to and name their
+// entries after, so they stay the same however a request reached the
+// instance. Without one, links follow the request's own host.
+func SetPublicURL(base string) {
+	publicURL = base
+}
+
+// baseURL returns the configured public URL, or the one the request came in on
+func baseURL(c *fiber.Ctx) string {
+	if publicURL != "" {
+		return publicURL
+	}
+	return c.BaseURL()
+}
+
+// FeedLink is a feed offered by a page, listed in its head as
+// <link rel="alternate"> for feed readers to discover
+type FeedLink struct {
+	Title string
+	Type  string
+	URL   string
+}
+
+// activityFeedLinks returns the Atom and RSS versions of an activity feed
+// whose URLs end in feed.atom and feed.rss after prefix
+func activityFeedLinks(title, prefix string) []FeedLink {
+	return []FeedLink{
+		{Title: title + " (Atom)", Type: "application/atom+xml", URL: prefix + "/feed.atom"},
+		{Title: title + " (RSS)", Type: "application/rss+xml", URL: prefix + "/feed.rss"},
+	}
+}
+
+// userFeedLinks returns the feeds of a user's public page: their activity,
+// what they finished and, unless they turned it off, their OPDS catalog
+func userFeedLinks(user *models.User, catalog bool) []FeedLink {
+	links := activityFeedLinks(user.DisplayName+"'s activity", "/u/"+user.Username)
+	links = append(links, activityFeedLinks(user.DisplayName+"'s finished books", "/u/"+user.Username+"/shelf/read")...)
+	if !catalog {
+		return links
+	}
+	return append(links,
+		FeedLink{Title: "OPDS catalog", Type: opdsNavigationType, URL: "/u/" + user.Username + "/opds"},
+		FeedLink{Title: "OPDS catalog", Type: opds2Type, URL: "/u/" + user.Username + "/opds2"},
+	)
+}
+
+// activityFeed is what a feed shows, written as Atom or RSS
+type activityFeed struct {
+	title       string
+	description string
+	// The page the feed follows and the feed's URL without its extension
+	link string
+	self string
+	// When the feed last changed, if it has no events
+	updated time.Time
+	events  []models.Event
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"feed"`
+	Xmlns    string      `xml:"xmlns,attr"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Author    atomAuthor `xml:"author"`
+	Summary   *atomText  `xml:"summary,omitempty"`
+	Links     []atomLink `xml:"link"`
+}
+
+type rssFeed struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	XmlnsAtom string     `xml:"xmlns:atom,attr"`
+	XmlnsDC   string     `xml:"xmlns:dc,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Self          atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	GUID        rssGUID       `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Creator     string        `xml:"dc:creator"`
+	Description string        `xml:"description,omitempty"`
+	Enclosure   *rssEnclosure `xml:"enclosure,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int    `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// eventID returns a tag URI naming an event, which stays the same wherever
+// and however often the event appears
+func eventID(c *fiber.Ctx, ev models.Event) string {
+	host := c.Hostname()
+	if u, err := url.Parse(publicURL); err == nil && publicURL != "" {
+		host = u.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return "tag:" + host + "," + ev.CreatedAt.UTC().Format("2006-01-02") + ":event/" + strconv.FormatInt(ev.ID, 10)
+}
+
+// eventSummary describes an event's book, if it has one
+func eventSummary(ev models.Event) string {
+	if ev.Book == nil {
+		return ""
+	}
+	if ev.Book.Authors == "" {
+		return ev.Book.Title
+	}
+	return ev.Book.Title + " by " + ev.Book.Authors
+}
+
+// eventCover returns the URL of an event's book cover, if it has one
+func eventCover(base string, ev models.Event) string {
+	if ev.Book == nil || ev.Book.GoogleBooksID == "" {
+		return ""
+	}
+	return base + "/api/images/book/" + ev.Book.GoogleBooksID
+}
+
+// sendActivityFeed writes a feed in the format named in the URL, "atom" or
+// "rss"
+func sendActivityFeed(c *fiber.Ctx, feed activityFeed) error {
+	base := baseURL(c)
+	updated := feed.updated
+	if len(feed.events) > 0 {
+		updated = feed.events[0].CreatedAt
+	}
+
+	var doc interface{}
+	var mediaType string
+	switch c.Params("format") {
+	case "atom":
+		mediaType = "application/atom+xml"
+		atom := atomFeed{
+			Xmlns:    "http://www.w3.org/2005/Atom",
+			ID:       base + feed.self + ".atom",
+			Title:    feed.title,
+			Subtitle: feed.description,
+			Updated:  updated.UTC().Format(time.RFC3339),
+			Links: []atomLink{
+				{Rel: "self", Href: base + feed.self + ".atom", Type: mediaType},
+				{Rel: "alternate", Href: base + feed.link, Type: "text/html"},
+			},
+		}
+		for _, ev := range feed.events {
+			entry := atomEntry{
+				ID:        eventID(c, ev),
+				Title:     ev.User.DisplayName + " " + ev.EventDescription(),
+				Published: ev.CreatedAt.UTC().Format(time.RFC3339),
+				Updated:   ev.CreatedAt.UTC().Format(time.RFC3339),
+				Author:    atomAuthor{Name: ev.User.DisplayName, URI: base + "/u/" + ev.User.Username},
+				Links:     []atomLink{{Rel: "alternate", Href: base + "/u/" + ev.User.Username, Type: "text/html"}},
+			}
+			if summary := eventSummary(ev); summary != "" {
+				entry.Summary = &atomText{Type: "text", Text: summary}
+			}
+			if cover := eventCover(base, ev); cover != "" {
+				entry.Links = append(entry.Links, atomLink{Rel: "enclosure", Href: cover, Type: "image/jpeg"})
+			}
+			atom.Entries = append(atom.Entries, entry)
+		}
+		doc = atom
+
+	case "rss":
+		mediaType = "application/rss+xml"
+		rss := rssFeed{
+			Version:   "2.0",
+			XmlnsAtom: "http://www.w3.org/2005/Atom",
+			XmlnsDC:   "http://purl.org/dc/elements/1.1/",
+			Channel: rssChannel{
+				Title:         feed.title,
+				Link:          base + feed.link,
+				Description:   feed.description,
+				Self:          atomLink{Rel: "self", Href: base + feed.self + ".rss", Type: mediaType},
+				LastBuildDate: updated.UTC().Format(time.RFC1123Z),
+			},
+		}
+		for _, ev := range feed.events {
+			item := rssItem{
+				Title:       ev.User.DisplayName + " " + ev.EventDescription(),
+				Link:        base + "/u/" + ev.User.Username,
+				GUID:        rssGUID{ID: eventID(c, ev)},
+				PubDate:     ev.CreatedAt.UTC().Format(time.RFC1123Z),
+				Creator:     ev.User.DisplayName,
+				Description: eventSummary(ev),
+			}
+			if cover := eventCover(base, ev); cover != "" {
+				// The size of a proxied cover isn't known in advance
+				item.Enclosure = &rssEnclosure{URL: cover, Length: 0, Type: "image/jpeg"}
+			}
+			rss.Channel.Items = append(rss.Channel.Items, item)
+		}
+		doc = rss
+
+	default:
+		return c.Status(fiber.StatusNotFound).SendString("Feed not found")
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error building feed")
+	}
+	c.Set("Content-Type", mediaType+";charset=utf-8")
+	return c.Send(append([]byte(xml.Header), data...))
+}
+
+// ActivityFeed serves the recent activity of everyone as Atom or RSS
+func ActivityFeed(c *fiber.Ctx) error {
+	events, err := models.GetRecentEvents(feedLength)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading activity")
+	}
+	return sendActivityFeed(c, activityFeed{
+		title:       "Spines activity",
+		description: "What everyone on Spines is reading",
+		link:        "/",
+		self:        "/feed",
+		updated:     time.Now(),
+		events:      events,
+	})
+}
+
+// UserActivityFeed serves a user's recent activity as Atom or RSS
+func UserActivityFeed(c *fiber.Ctx) error {
+	user, err := publicUser(c)
+	if err != nil {
+		return err
+	}
+	events, err := models.GetUserEvents(user.ID, feedLength)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading activity")
+	}
+	return sendActivityFeed(c, activityFeed{
+		title:       user.DisplayName + "'s activity",
+		description: "What " + user.DisplayName + " is reading on Spines",
+		link:        "/u/" + user.Username,
+		self:        "/u/" + user.Username + "/feed",
+		updated:     user.CreatedAt,
+		events:      events,
+	})
+}
+
+// ShelfActivityFeed serves the books that recently arrived on one of a
+// user's public shelves as Atom or RSS; the read shelf's feed follows the
+// books they finish
+func ShelfActivityFeed(c *fiber.Ctx) error {
+	user, err := publicUser(c)
+	if err != nil {
+		return err
+	}
+	shelf := findFeedShelf(c.Params("shelf"))
+	if shelf == nil {
+		return c.Status(fiber.StatusNotFound).SendString("Shelf not found")
+	}
+	events, err := models.GetUserShelfEvents(user.ID, shelf.value, feedLength)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading activity")
+	}
+	return sendActivityFeed(c, activityFeed{
+		title:       user.DisplayName + "'s " + shelf.title + " shelf",
+		description: "Books " + user.DisplayName + " put on their " + shelf.title + " shelf",
+		link:        "/u/" + user.Username,
+		self:        "/u/" + user.Username + "/shelf/" + shelf.value + "/feed",
+		updated:     user.CreatedAt,
+		events:      events,
+	})
+}
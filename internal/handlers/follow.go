@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"database/sql"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nuuner/spines/internal/models"
+)
+
+// Number of events per page of the following feed
+const followingFeedPageSize = 20
+
+// FollowUser makes the current user follow the user whose page they're on
+func FollowUser(c *fiber.Ctx) error {
+	return setFollowing(c, true)
+}
+
+// UnfollowUser stops the current user following the user whose page they're on
+func UnfollowUser(c *fiber.Ctx) error {
+	return setFollowing(c, false)
+}
+
+func setFollowing(c *fiber.Ctx, follow bool) error {
+	current := c.Locals("user").(*models.User)
+
+	user, err := models.GetUserByUsername(c.Params("username"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(fiber.StatusNotFound).SendString("User not found")
+		}
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading user")
+	}
+	if user.ID == current.ID {
+		return c.Redirect("/u/" + user.Username)
+	}
+
+	if follow {
+		err = models.FollowUser(current.ID, user.ID)
+	} else {
+		err = models.UnfollowUser(current.ID, user.ID)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error updating follow")
+	}
+	return c.Redirect("/u/" + user.Username)
+}
+
+// followingFeed returns a page of the current user's following feed, older
+// than before unless it is 0, and the cursor of the next page, 0 at the end
+func followingFeed(userID, before int64) ([]models.Event, int64, error) {
+	events, err := models.GetFollowingEvents(userID, before, followingFeedPageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	var next int64
+	if len(events) == followingFeedPageSize {
+		next = events[len(events)-1].ID
+	}
+	return events, next, nil
+}
+
+// FollowingFeedPage returns older events of the following feed for the
+// dashboard's "show more" button
+func FollowingFeedPage(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	before := int64(c.QueryInt("before", 0))
+	if before <= 0 {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid cursor")
+	}
+	events, next, err := followingFeed(user.ID, before)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading activity")
+	}
+	_ = models.LoadEventCounts(events)
+
+	return c.Render("partials/feed_events", fiber.Map{
+		"Events":     events,
+		"NextBefore": next,
+	})
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nuuner/spines/internal/models"
+)
+
+// Largest books and pages goals accepted
+const (
+	maxBooksGoal = 10000
+	maxPagesGoal = 10000000
+)
+
+// SaveGoal sets the user's reading goal for a year (the current one unless
+// given). Leaving both targets empty removes the goal.
+func (h *UserBooksHandler) SaveGoal(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	year := time.Now().Year()
+	if yearStr := c.FormValue("year"); yearStr != "" {
+		y, err := strconv.Atoi(yearStr)
+		if err != nil || y < 1900 || y > year+1 {
+			return c.Redirect("/my-books?error=Invalid+goal+year")
+		}
+		year = y
+	}
+
+	booksGoal, ok := parseGoalTarget(c.FormValue("books_goal"), maxBooksGoal)
+	if !ok {
+		return c.Redirect("/my-books?error=Invalid+books+goal")
+	}
+	pagesGoal, ok := parseGoalTarget(c.FormValue("pages_goal"), maxPagesGoal)
+	if !ok {
+		return c.Redirect("/my-books?error=Invalid+pages+goal")
+	}
+
+	if !booksGoal.Valid && !pagesGoal.Valid {
+		if err := models.DeleteReadingGoal(user.ID, year); err != nil {
+			return c.Redirect("/my-books?error=Failed+to+remove+reading+goal")
+		}
+		return c.Redirect("/my-books?success=Reading+goal+removed")
+	}
+
+	if err := models.SetReadingGoal(user.ID, year, booksGoal, pagesGoal); err != nil {
+		return c.Redirect("/my-books?error=Failed+to+save+reading+goal")
+	}
+
+	return c.Redirect("/my-books?success=Reading+goal+saved")
+}
+
+// parseGoalTarget reads an optional positive goal target, reporting false when
+// it is not a number in range
+func parseGoalTarget(value string, max int64) (sql.NullInt64, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return sql.NullInt64{}, true
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 1 || n > max {
+		return sql.NullInt64{}, false
+	}
+	return sql.NullInt64{Int64: n, Valid: true}, true
+}
+
+// currentReadingGoal returns the user's goal for this year, or nil if they have none
+func currentReadingGoal(userID int64) *models.ReadingGoal {
+	goal, err := models.GetReadingGoal(userID, time.Now().Year())
+	if err != nil {
+		return nil
+	}
+	return goal
+}
@@ -29,16 +29,44 @@ func ProxyBookCover(c *fiber.Ctx) error {
 	zoom := c.Query("zoom", "1")
 	edge := c.Query("edge", "curl")
 
+	img, hit, err := fetchBookCover(bookID, zoom, edge)
+	if err != nil {
+		if fe, ok := err.(*coverFetchError); ok {
+			return c.Status(fe.status).SendString(fe.message)
+		}
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to read image")
+	}
+
+	c.Set("Content-Type", img.ContentType)
+	c.Set("Cache-Control", "public, max-age=3600")
+	if hit {
+		c.Set("X-Cache", "HIT")
+	} else {
+		c.Set("X-Cache", "MISS")
+	}
+	return c.Send(img.Data)
+}
+
+// coverFetchError is a failure to get a cover from Google Books, with the
+// status to respond with
+type coverFetchError struct {
+	status  int
+	message string
+}
+
+func (e *coverFetchError) Error() string {
+	return e.message
+}
+
+// fetchBookCover returns a Google Books cover from the cache, fetching and
+// caching it on a miss. hit reports whether it came from the cache.
+func fetchBookCover(bookID, zoom, edge string) (img cachedImage, hit bool, err error) {
 	// Build cache key from all params
 	cacheKey := "book_cover:" + bookID + ":" + zoom + ":" + edge
 
 	// Check cache first
 	if cached, found := imageCache.Get(cacheKey); found {
-		img := cached.(cachedImage)
-		c.Set("Content-Type", img.ContentType)
-		c.Set("Cache-Control", "public, max-age=3600")
-		c.Set("X-Cache", "HIT")
-		return c.Send(img.Data)
+		return cached.(cachedImage), true, nil
 	}
 
 	// Build Google Books thumbnail URL
@@ -48,18 +76,18 @@ func ProxyBookCover(c *fiber.Ctx) error {
 	// Fetch from Google Books
 	resp, err := http.Get(googleURL)
 	if err != nil {
-		return c.Status(fiber.StatusBadGateway).SendString("Failed to fetch image")
+		return cachedImage{}, false, &coverFetchError{fiber.StatusBadGateway, "Failed to fetch image"}
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return c.Status(resp.StatusCode).SendString("Image not found")
+		return cachedImage{}, false, &coverFetchError{resp.StatusCode, "Image not found"}
 	}
 
 	// Read the image data
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("Failed to read image")
+		return cachedImage{}, false, err
 	}
 
 	// Get content type from response
@@ -68,15 +96,13 @@ func ProxyBookCover(c *fiber.Ctx) error {
 		contentType = "image/jpeg"
 	}
 
-	// Store in cache
-	imageCache.Set(cacheKey, cachedImage{
+	img = cachedImage{
 		Data:        data,
 		ContentType: contentType,
-	})
+	}
 
-	// Return the image
-	c.Set("Content-Type", contentType)
-	c.Set("Cache-Control", "public, max-age=3600")
-	c.Set("X-Cache", "MISS")
-	return c.Send(data)
+	// Store in cache
+	imageCache.Set(cacheKey, img)
+
+	return img, false, nil
 }
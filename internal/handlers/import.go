@@ -0,0 +1,608 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nuuner/spines/internal/importer"
+	"github.com/nuuner/spines/internal/models"
+	"github.com/nuuner/spines/internal/services"
+)
+
+const (
+	maxImportSize = 4 * 1024 * 1024 // 4MB, fiber's default body limit
+	recentImports = 20
+)
+
+// ImportPage shows the import form and the user's recent imports
+func (h *UserBooksHandler) ImportPage(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	jobs, err := models.GetImportJobs(user.ID, recentImports)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading imports")
+	}
+
+	return c.Render("pages/user/import", NavData(c, fiber.Map{
+		"User":    user,
+		"Jobs":    jobs,
+		"Sources": models.ImportSources,
+		"Error":   c.Query("error"),
+		"Success": c.Query("success"),
+		// Whether a Calibre library on the server can be imported from
+		"CalibreLibrary": h.Config.CalibrePath != "",
+		// SEO metadata
+		"PageTitle":  "Import & Export",
+		"MetaRobots": "noindex, nofollow",
+	}), "layouts/base")
+}
+
+// StartImport reads an uploaded export file and queues its books for import
+func (h *UserBooksHandler) StartImport(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	source := c.FormValue("source")
+	sourceName, ok := models.ImportSources[source]
+	if !ok {
+		return c.Redirect("/my-books/import?error=Invalid+import+format")
+	}
+
+	quiet := c.FormValue("quiet") == "1"
+	if source == "calibre" {
+		return h.startCalibreImport(c, user.ID, quiet)
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Redirect("/my-books/import?error=No+file+uploaded")
+	}
+	if file.Size > maxImportSize {
+		return c.Redirect("/my-books/import?error=File+too+large.+Maximum+size+is+4MB")
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return c.Redirect("/my-books/import?error=Failed+to+read+file")
+	}
+	defer src.Close()
+
+	if source == "csv" {
+		return h.uploadForMapping(c, user.ID, file.Filename, src, quiet)
+	}
+
+	entries, err := importer.Parse(source, src)
+	switch {
+	case err == importer.ErrTooManyRows:
+		return c.Redirect("/my-books/import?error=File+has+more+than+" + strconv.Itoa(importer.MaxRows) + "+books")
+	case err == importer.ErrUnrecognisedFile:
+		return c.Redirect("/my-books/import?error=" + url.QueryEscape("This isn't a "+sourceName+" export"))
+	case err != nil:
+		return c.Redirect("/my-books/import?error=Could+not+read+the+file+as+CSV")
+	case len(entries) == 0:
+		return c.Redirect("/my-books/import?error=No+books+found+in+the+file")
+	}
+
+	return h.queueImport(c, user.ID, source, file.Filename, entries, quiet)
+}
+
+// queueImport stores the entries read from an import as a job and starts it
+func (h *UserBooksHandler) queueImport(c *fiber.Ctx, userID int64, source, filename string, entries []models.ImportEntry, quiet bool) error {
+	review := c.FormValue("review") == "1"
+	jobID, err := models.CreateImportJob(userID, source, filename, entries, review, quiet)
+	if err != nil {
+		log.Printf("[import] Failed to create job for user %d: %v", userID, err)
+		return c.Redirect("/my-books/import?error=Failed+to+start+import")
+	}
+	importer.Start(userID, jobID, h.Config.GoogleBooksAPIKey)
+
+	return c.Redirect("/my-books/import/" + strconv.FormatInt(jobID, 10))
+}
+
+// startCalibreImport reads a Calibre library, either the one configured on
+// the server or an uploaded metadata.db, and queues its books for import.
+// The server's library is read in place, covers included.
+func (h *UserBooksHandler) startCalibreImport(c *fiber.Ctx, userID int64, quiet bool) error {
+	shelf := c.FormValue("shelf")
+	if !isValidShelf(shelf) {
+		return c.Redirect("/my-books/import?error=Invalid+shelf")
+	}
+
+	var entries []models.ImportEntry
+	var filename string
+	var err error
+	if c.FormValue("calibre_local") == "1" {
+		if h.Config.CalibrePath == "" {
+			return c.Redirect("/my-books/import?error=No+Calibre+library+is+set+up+on+the+server")
+		}
+		filename = "Calibre library"
+		entries, err = importer.ParseCalibre(filepath.Join(h.Config.CalibrePath, "metadata.db"), shelf, true)
+	} else {
+		file, ferr := c.FormFile("file")
+		if ferr != nil {
+			return c.Redirect("/my-books/import?error=No+file+uploaded")
+		}
+		if file.Size > maxImportSize {
+			return c.Redirect("/my-books/import?error=File+too+large.+Maximum+size+is+4MB")
+		}
+
+		// SQLite can only open files, so the upload is read from a temporary copy
+		tmp, ferr := os.CreateTemp("", "calibre-*.db")
+		if ferr != nil {
+			return c.Redirect("/my-books/import?error=Failed+to+read+file")
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name())
+		if err := c.SaveFile(file, tmp.Name()); err != nil {
+			return c.Redirect("/my-books/import?error=Failed+to+read+file")
+		}
+		filename = file.Filename
+		entries, err = importer.ParseCalibre(tmp.Name(), shelf, false)
+	}
+
+	switch {
+	case err == importer.ErrTooManyRows:
+		return c.Redirect("/my-books/import?error=Library+has+more+than+" + strconv.Itoa(importer.MaxRows) + "+books")
+	case err == importer.ErrUnrecognisedFile:
+		return c.Redirect("/my-books/import?error=" + url.QueryEscape("This isn't a Calibre metadata.db file"))
+	case err != nil:
+		log.Printf("[import] Failed to read Calibre library for user %d: %v", userID, err)
+		return c.Redirect("/my-books/import?error=Could+not+read+the+Calibre+library")
+	case len(entries) == 0:
+		return c.Redirect("/my-books/import?error=No+books+found+in+the+library")
+	}
+
+	return h.queueImport(c, userID, "calibre", filename, entries, quiet)
+}
+
+// uploadForMapping keeps a generic CSV file until the user has mapped its
+// columns
+func (h *UserBooksHandler) uploadForMapping(c *fiber.Ctx, userID int64, filename string, src io.Reader, quiet bool) error {
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return c.Redirect("/my-books/import?error=Failed+to+read+file")
+	}
+	_, sample, err := importer.ReadColumns(strings.NewReader(string(data)))
+	switch {
+	case err == importer.ErrTooManyRows:
+		return c.Redirect("/my-books/import?error=File+has+more+than+" + strconv.Itoa(importer.MaxRows) + "+books")
+	case err != nil:
+		return c.Redirect("/my-books/import?error=Could+not+read+the+file+as+CSV")
+	case len(sample) == 0:
+		return c.Redirect("/my-books/import?error=No+books+found+in+the+file")
+	}
+
+	jobID, err := models.CreateImportUpload(userID, "csv", filename, string(data), quiet)
+	if err != nil {
+		log.Printf("[import] Failed to store upload for user %d: %v", userID, err)
+		return c.Redirect("/my-books/import?error=Failed+to+start+import")
+	}
+	return c.Redirect("/my-books/import/" + strconv.FormatInt(jobID, 10))
+}
+
+// mappingField is a field offered on the column mapping page with the column
+// currently chosen for it
+type mappingField struct {
+	importer.MappingField
+	Column string
+}
+
+// ImportJobPage shows an import's column mapping, progress, matches while
+// under review, and once done the books that couldn't be imported
+func (h *UserBooksHandler) ImportJobPage(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	job, err := h.importJob(c, user.ID)
+	if err != nil {
+		return err
+	}
+
+	if job.Status == models.ImportMapping {
+		upload, err := models.GetImportUpload(job.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Error loading import")
+		}
+		header, sample, err := importer.ReadColumns(strings.NewReader(upload))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Error reading file")
+		}
+		guess := importer.GuessMapping(header)
+		fields := make([]mappingField, len(importer.MappingFields))
+		for i, field := range importer.MappingFields {
+			fields[i] = mappingField{MappingField: field, Column: guess.Columns[field.Key]}
+		}
+		return c.Render("pages/user/import_mapping", NavData(c, fiber.Map{
+			"User":        user,
+			"Job":         job,
+			"Header":      header,
+			"Sample":      sample,
+			"Fields":      fields,
+			"DateLayouts": importer.DateLayouts,
+			"Error":       c.Query("error"),
+			// SEO metadata
+			"PageTitle":  "Map Columns - " + job.Filename,
+			"MetaRobots": "noindex, nofollow",
+		}), "layouts/base")
+	}
+
+	if job.Status == models.ImportReview {
+		rows, err := models.GetImportRows(job.ID, "")
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Error loading import")
+		}
+		return c.Render("pages/user/import_review", NavData(c, fiber.Map{
+			"User":  user,
+			"Job":   job,
+			"Rows":  rows,
+			"Error": c.Query("error"),
+			// SEO metadata
+			"PageTitle":  "Review Import - " + job.Filename,
+			"MetaRobots": "noindex, nofollow",
+		}), "layouts/base")
+	}
+
+	var problems []models.ImportRow
+	if job.IsFinished() {
+		rows, err := models.GetImportRows(job.ID, "")
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Error loading import")
+		}
+		for _, row := range rows {
+			if row.Status == models.ImportRowUnmatched || row.Status == models.ImportRowFailed {
+				problems = append(problems, row)
+			}
+		}
+	}
+
+	return c.Render("pages/user/import_job", NavData(c, fiber.Map{
+		"User":     user,
+		"Job":      job,
+		"Problems": problems,
+		// SEO metadata
+		"PageTitle":  "Import - " + job.Filename,
+		"MetaRobots": "noindex, nofollow",
+	}), "layouts/base")
+}
+
+// MapImportColumns reads a generic CSV file with the columns the user chose
+// and queues its rows for matching and review
+func (h *UserBooksHandler) MapImportColumns(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	job, err := h.importJob(c, user.ID)
+	if err != nil {
+		return err
+	}
+	jobURL := "/my-books/import/" + strconv.FormatInt(job.ID, 10)
+	if job.Status != models.ImportMapping {
+		return c.Redirect(jobURL)
+	}
+
+	upload, err := models.GetImportUpload(job.ID)
+	if err != nil {
+		return c.Redirect(jobURL + "?error=Failed+to+read+file")
+	}
+	header, _, err := importer.ReadColumns(strings.NewReader(upload))
+	if err != nil {
+		return c.Redirect(jobURL + "?error=Failed+to+read+file")
+	}
+	columns := make(map[string]bool, len(header))
+	for _, column := range header {
+		columns[column] = true
+	}
+
+	mapping := importer.ColumnMapping{
+		Columns:      make(map[string]string),
+		DefaultShelf: c.FormValue("default_shelf"),
+		DateLayout:   c.FormValue("date_layout"),
+	}
+	mapping.RatingScale, _ = strconv.Atoi(c.FormValue("rating_scale"))
+	for _, field := range importer.MappingFields {
+		if column := c.FormValue("column_" + field.Key); columns[column] {
+			mapping.Columns[field.Key] = column
+		}
+	}
+	if !isValidShelf(mapping.DefaultShelf) {
+		return c.Redirect(jobURL + "?error=Invalid+shelf")
+	}
+	if mapping.RatingScale != 5 && mapping.RatingScale != 10 {
+		return c.Redirect(jobURL + "?error=Invalid+rating+scale")
+	}
+	if !isValidDateLayout(mapping.DateLayout) {
+		return c.Redirect(jobURL + "?error=Invalid+date+format")
+	}
+
+	entries, err := importer.ParseMapped(strings.NewReader(upload), mapping)
+	switch {
+	case err == importer.ErrNoTitleColumn:
+		return c.Redirect(jobURL + "?error=Choose+the+column+with+the+book+titles")
+	case err != nil:
+		return c.Redirect(jobURL + "?error=Failed+to+read+file")
+	case len(entries) == 0:
+		return c.Redirect(jobURL + "?error=No+books+found+in+the+title+column")
+	}
+
+	if err := models.AddImportRows(job.ID, entries); err != nil {
+		log.Printf("[import] Failed to add rows to job %d: %v", job.ID, err)
+		return c.Redirect(jobURL + "?error=Failed+to+start+import")
+	}
+	importer.Start(user.ID, job.ID, h.Config.GoogleBooksAPIKey)
+
+	return c.Redirect(jobURL)
+}
+
+// isValidDateLayout checks that a layout is one of importer.DateLayouts
+func isValidDateLayout(layout string) bool {
+	for _, l := range importer.DateLayouts {
+		if l.Layout == layout {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportProgress returns an import's progress bar, polled by htmx until the
+// job finishes
+func (h *UserBooksHandler) ImportProgress(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	job, err := h.importJob(c, user.ID)
+	if err != nil {
+		return err
+	}
+
+	// Reload the page once matching or importing stops so the review or report
+	// is shown
+	if !job.IsWorking() {
+		c.Set("HX-Refresh", "true")
+	}
+	return c.Render("partials/import_progress", job)
+}
+
+// ImportReport downloads the rows of an import that weren't imported as CSV,
+// so they can be fixed up and imported again or added by hand
+func (h *UserBooksHandler) ImportReport(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	job, err := h.importJob(c, user.ID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := models.GetImportRows(job.ID, "")
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading import")
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="import-`+strconv.FormatInt(job.ID, 10)+`-report.csv"`)
+
+	w := csv.NewWriter(c)
+	_ = w.Write([]string{"Line", "Title", "Author", "ISBN13", "ISBN", "Status", "Message"})
+	for _, row := range rows {
+		if row.Status != models.ImportRowUnmatched && row.Status != models.ImportRowFailed {
+			continue
+		}
+		_ = w.Write([]string{
+			strconv.Itoa(row.Line), row.Entry.Title, row.Entry.Authors,
+			row.Entry.ISBN13, row.Entry.ISBN10, row.Status, row.Message.String,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// ImportCandidates searches for books an import row could be, so the user
+// can correct its match while reviewing
+func (h *UserBooksHandler) ImportCandidates(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	row, err := h.reviewRow(c, user.ID)
+	if err != nil {
+		return err
+	}
+
+	query := c.Query("q")
+	if query == "" {
+		query = importer.SearchQuery(row.Entry)
+	}
+	results, err := services.SearchBooks(query, h.Config.GoogleBooksAPIKey)
+
+	return c.Render("partials/import_candidates", fiber.Map{
+		"Row":     row,
+		"Query":   query,
+		"Results": results,
+		"Failed":  err != nil,
+	})
+}
+
+// MatchImportRow sets the book an import row is matched to, from a search
+// result chosen while reviewing
+func (h *UserBooksHandler) MatchImportRow(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	row, err := h.reviewRow(c, user.ID)
+	if err != nil {
+		return err
+	}
+
+	googleBooksID := c.FormValue("google_books_id")
+	title := c.FormValue("title")
+	if googleBooksID == "" || title == "" {
+		return c.Status(fiber.StatusBadRequest).SendString("Missing required fields")
+	}
+	pageCount, _ := strconv.Atoi(c.FormValue("page_count"))
+
+	book, err := models.GetOrCreateBookWithISBN(googleBooksID, title, c.FormValue("authors"), c.FormValue("description"),
+		c.FormValue("thumbnail_url"), c.FormValue("isbn_13"), c.FormValue("isbn_10"), pageCount, h.Config.GoogleBooksAPIKey)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to create book")
+	}
+	_ = models.FetchBookCategories(book.ID, book.GoogleBooksID, h.Config.GoogleBooksAPIKey)
+
+	bookID := sql.NullInt64{Int64: book.ID, Valid: true}
+	if err := models.SetImportRowResult(row.ID, models.ImportRowMatched, bookID, ""); err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to update import")
+	}
+	return h.renderReviewRow(c, row.JobID, row.ID)
+}
+
+// SkipImportRow leaves a row out of the import, or brings a skipped row back
+func (h *UserBooksHandler) SkipImportRow(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	row, err := h.reviewRow(c, user.ID)
+	if err != nil {
+		return err
+	}
+
+	status := models.ImportRowSkipped
+	if row.Status == models.ImportRowSkipped {
+		status = models.ImportRowUnmatched
+		if row.Book != nil {
+			status = models.ImportRowMatched
+		}
+	}
+	if err := models.SetImportRowResult(row.ID, status, row.BookID, row.Message.String); err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to update import")
+	}
+	return h.renderReviewRow(c, row.JobID, row.ID)
+}
+
+// ConfirmImport shelves the matched rows of a reviewed import
+func (h *UserBooksHandler) ConfirmImport(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	job, err := h.importJob(c, user.ID)
+	if err != nil {
+		return err
+	}
+	jobURL := "/my-books/import/" + strconv.FormatInt(job.ID, 10)
+	if job.Status != models.ImportReview {
+		return c.Redirect(jobURL)
+	}
+
+	if err := models.ConfirmImportJob(job.ID); err != nil {
+		return c.Redirect(jobURL + "?error=Failed+to+start+import")
+	}
+	importer.Start(user.ID, job.ID, h.Config.GoogleBooksAPIKey)
+
+	return c.Redirect(jobURL)
+}
+
+// DeleteImport removes an import from the list. Books it already shelved stay
+// where they are.
+func (h *UserBooksHandler) DeleteImport(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	job, err := h.importJob(c, user.ID)
+	if err != nil {
+		return err
+	}
+	if job.IsWorking() {
+		return c.Redirect("/my-books/import?error=Wait+for+the+import+to+finish+before+removing+it")
+	}
+
+	if err := models.DeleteImportJob(user.ID, job.ID); err != nil {
+		return c.Redirect("/my-books/import?error=Failed+to+remove+import")
+	}
+	return c.Redirect("/my-books/import?success=Import+removed")
+}
+
+// renderReviewRow returns a row of the review page after it changed
+func (h *UserBooksHandler) renderReviewRow(c *fiber.Ctx, jobID, rowID int64) error {
+	row, err := models.GetImportRow(jobID, rowID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading import")
+	}
+	if c.Get("HX-Request") != "true" {
+		return c.Redirect("/my-books/import/" + strconv.FormatInt(jobID, 10))
+	}
+	return c.Render("partials/import_review_row", row)
+}
+
+// reviewRow loads the import row named in the URL, which must belong to one
+// of the user's jobs under review
+func (h *UserBooksHandler) reviewRow(c *fiber.Ctx, userID int64) (*models.ImportRow, error) {
+	job, err := h.importJob(c, userID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != models.ImportReview {
+		return nil, fiber.NewError(fiber.StatusConflict, "Import is no longer being reviewed")
+	}
+
+	rowID, err := strconv.ParseInt(c.Params("row_id"), 10, 64)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid row ID")
+	}
+	row, err := models.GetImportRow(job.ID, rowID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fiber.NewError(fiber.StatusNotFound, "Row not found")
+		}
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Error loading import")
+	}
+	return row, nil
+}
+
+// ImportCover serves the cover of a row imported from the Calibre library on
+// the server, shown while reviewing matches. The cover is only read from the
+// library; shelved books show their matched edition's cover.
+func (h *UserBooksHandler) ImportCover(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	job, err := h.importJob(c, user.ID)
+	if err != nil {
+		return err
+	}
+	rowID, err := strconv.ParseInt(c.Params("row_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid row ID")
+	}
+	row, err := models.GetImportRow(job.ID, rowID)
+	if err != nil || job.Source != "calibre" || row.Entry.Cover == "" || h.Config.CalibrePath == "" {
+		return c.Status(fiber.StatusNotFound).SendString("Cover not found")
+	}
+
+	// The cover path came from the library's own database, but make sure it
+	// can't point outside the library
+	library := filepath.Clean(h.Config.CalibrePath)
+	cover := filepath.Join(library, filepath.FromSlash(row.Entry.Cover))
+	if rel, err := filepath.Rel(library, cover); err != nil || strings.HasPrefix(rel, "..") {
+		return c.Status(fiber.StatusNotFound).SendString("Cover not found")
+	}
+	data, err := os.ReadFile(cover)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).SendString("Cover not found")
+	}
+
+	c.Set(fiber.HeaderContentType, "image/jpeg")
+	c.Set(fiber.HeaderCacheControl, "private, max-age=3600")
+	return c.Send(data)
+}
+
+// importJob loads the import job named in the URL, returning a fiber error
+// with the response status if it can't
+func (h *UserBooksHandler) importJob(c *fiber.Ctx, userID int64) (*models.ImportJob, error) {
+	jobID, err := strconv.ParseInt(c.Params("job_id"), 10, 64)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid import ID")
+	}
+
+	job, err := models.GetImportJob(userID, jobID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fiber.NewError(fiber.StatusNotFound, "Import not found")
+		}
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Error loading import")
+	}
+	return job, nil
+}
@@ -15,6 +15,9 @@ func NavData(c *fiber.Ctx, data fiber.Map) fiber.Map {
 	}
 	if user, ok := c.Locals("CurrentUser").(*models.User); ok {
 		data["CurrentUser"] = user
+		if _, set := data["UnreadNotifications"]; !set {
+			data["UnreadNotifications"], _ = models.CountUnreadNotifications(user.ID)
+		}
 	}
 	// Add CSRF token for forms
 	if csrfToken, ok := c.Locals("CSRFToken").(string); ok {
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nuuner/spines/internal/models"
+)
+
+// Maximum lengths for a book's private notes and for a single quote
+const (
+	maxNotesLength   = 20000
+	maxQuoteLength   = 5000
+	maxCommentLength = 2000
+)
+
+// Number of results shown when searching quotes and notes
+const quoteSearchLimit = 50
+
+// Number of public quotes shown on the public user page
+const publicQuotesLimit = 6
+
+// NotesPage shows the private notes and saved quotes for a shelved book
+func (h *UserBooksHandler) NotesPage(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	bookID, err := strconv.ParseInt(c.Params("book_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid book ID")
+	}
+
+	userBook, err := models.GetUserBook(user.ID, bookID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(fiber.StatusNotFound).SendString("Book not found on your shelves")
+		}
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading book")
+	}
+
+	book, err := models.GetBookByID(bookID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading book")
+	}
+	userBook.Book = book
+
+	notes, err := models.GetUserBookNotes(userBook.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading notes")
+	}
+
+	quotes, err := models.GetBookQuotes(userBook.ID)
+	if err != nil {
+		quotes = []models.Quote{}
+	}
+
+	return c.Render("pages/user/notes", NavData(c, fiber.Map{
+		"User":     user,
+		"UserBook": userBook,
+		"Notes":    notes,
+		"Quotes":   quotes,
+		"Success":  c.Query("success"),
+		"Error":    c.Query("error"),
+		// SEO metadata
+		"PageTitle":  "Notes - " + book.Title,
+		"MetaRobots": "noindex, nofollow",
+	}), "layouts/base")
+}
+
+// SaveNotes replaces the private notes for a shelved book
+func (h *UserBooksHandler) SaveNotes(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	bookID, err := strconv.ParseInt(c.Params("book_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid book ID")
+	}
+
+	notesURL := "/my-books/" + c.Params("book_id") + "/notes"
+
+	if _, err := models.GetUserBook(user.ID, bookID); err != nil {
+		return c.Redirect("/my-books?error=Book+not+found+on+your+shelves")
+	}
+
+	notes := strings.TrimSpace(c.FormValue("notes"))
+	if len(notes) > maxNotesLength {
+		return c.Redirect(notesURL + "?error=Notes+are+too+long")
+	}
+
+	if err := models.UpdateUserBookNotes(user.ID, bookID, notes); err != nil {
+		return c.Redirect(notesURL + "?error=Failed+to+save+notes")
+	}
+
+	return c.Redirect(notesURL + "?success=Notes+saved")
+}
+
+// AddQuote saves a new quote for a shelved book
+func (h *UserBooksHandler) AddQuote(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	bookID, err := strconv.ParseInt(c.Params("book_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid book ID")
+	}
+
+	notesURL := "/my-books/" + c.Params("book_id") + "/notes"
+
+	userBook, err := models.GetUserBook(user.ID, bookID)
+	if err != nil {
+		return c.Redirect("/my-books?error=Book+not+found+on+your+shelves")
+	}
+
+	text := strings.TrimSpace(c.FormValue("text"))
+	if text == "" {
+		return c.Redirect(notesURL + "?error=Quote+text+is+required")
+	}
+	if len(text) > maxQuoteLength {
+		return c.Redirect(notesURL + "?error=Quote+is+too+long")
+	}
+
+	var page sql.NullInt64
+	if pageStr := strings.TrimSpace(c.FormValue("page")); pageStr != "" {
+		p, err := strconv.ParseInt(pageStr, 10, 64)
+		if err != nil || p < 1 {
+			return c.Redirect(notesURL + "?error=Invalid+page+number")
+		}
+		page = sql.NullInt64{Int64: p, Valid: true}
+	}
+
+	var comment sql.NullString
+	if commentStr := strings.TrimSpace(c.FormValue("comment")); commentStr != "" {
+		if len(commentStr) > maxCommentLength {
+			return c.Redirect(notesURL + "?error=Comment+is+too+long")
+		}
+		comment = sql.NullString{String: commentStr, Valid: true}
+	}
+
+	if _, err := models.CreateQuote(userBook.ID, text, page, comment); err != nil {
+		return c.Redirect(notesURL + "?error=Failed+to+save+quote")
+	}
+
+	return c.Redirect(notesURL + "?success=Quote+saved")
+}
+
+// SetQuotePublic shows or hides a quote on the user's public page
+func (h *UserBooksHandler) SetQuotePublic(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	quoteID, err := strconv.ParseInt(c.Params("quote_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid quote ID")
+	}
+
+	public := c.FormValue("public") == "1"
+	if err := models.SetQuotePublic(user.ID, quoteID, public); err != nil {
+		if c.Get("HX-Request") == "true" {
+			return c.Status(fiber.StatusInternalServerError).SendString("Failed to update quote")
+		}
+		return c.Redirect("/my-books/quotes?error=Failed+to+update+quote")
+	}
+
+	// Swap in the updated toggle for HTMX requests
+	if c.Get("HX-Request") == "true" {
+		return c.Render("quote_public_toggle", models.Quote{ID: quoteID, IsPublic: public})
+	}
+
+	return c.Redirect("/my-books/quotes")
+}
+
+// DeleteQuote removes a saved quote
+func (h *UserBooksHandler) DeleteQuote(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	quoteID, err := strconv.ParseInt(c.Params("quote_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid quote ID")
+	}
+
+	if err := models.DeleteQuote(user.ID, quoteID); err != nil {
+		if c.Get("HX-Request") == "true" {
+			return c.Status(fiber.StatusInternalServerError).SendString("Failed to delete quote")
+		}
+		return c.Redirect("/my-books/quotes?error=Failed+to+delete+quote")
+	}
+
+	// An empty response removes the quote from the page for HTMX requests
+	if c.Get("HX-Request") == "true" {
+		return c.SendString("")
+	}
+
+	return c.Redirect("/my-books/quotes")
+}
+
+// QuotesPage searches the user's quotes and private notes across their library
+func (h *UserBooksHandler) QuotesPage(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+	query := strings.TrimSpace(c.Query("q"))
+
+	quotes, err := models.SearchUserQuotes(user.ID, query, quoteSearchLimit)
+	if err != nil {
+		quotes = []models.Quote{}
+	}
+
+	// Notes are only listed when searching, as they can be long
+	var notes []models.BookNotes
+	if query != "" {
+		notes, err = models.SearchUserNotes(user.ID, query, quoteSearchLimit)
+		if err != nil {
+			notes = []models.BookNotes{}
+		}
+	}
+
+	data := fiber.Map{
+		"Query":  query,
+		"Quotes": quotes,
+		"Notes":  notes,
+	}
+
+	// Return just the results for HTMX requests
+	if c.Get("HX-Request") == "true" {
+		return c.Render("partials/quote_results", data)
+	}
+
+	data["User"] = user
+	data["Error"] = c.Query("error")
+	// SEO metadata
+	data["PageTitle"] = "Quotes & Notes"
+	data["MetaRobots"] = "noindex, nofollow"
+	return c.Render("pages/user/quotes", NavData(c, data), "layouts/base")
+}
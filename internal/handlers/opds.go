@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nuuner/spines/internal/models"
+	"github.com/nuuner/spines/internal/services"
+)
+
+// OPDS 1.2 catalogs are Atom feeds with these media types
+const (
+	opdsNavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+)
+
+// OPDS 2.0 catalogs are JSON documents of this media type
+const opds2Type = "application/opds+json"
+
+// Number of books per page of a shelf's OPDS feed
+const opdsPageSize = 50
+
+// feedShelf is a shelf offered in the OPDS catalog and activity feeds
+type feedShelf struct {
+	value string
+	title string
+}
+
+// feedShelves lists the shelves shown on public profiles, in catalog order.
+// Books on hold or not finished stay private as they do elsewhere.
+var feedShelves = []feedShelf{
+	{value: "currently_reading", title: "Currently Reading"},
+	{value: "want_to_read", title: "Want to Read"},
+	{value: "read", title: "Read"},
+}
+
+// findFeedShelf returns the feed shelf with the given value, or nil
+func findFeedShelf(value string) *feedShelf {
+	for i := range feedShelves {
+		if feedShelves[i].value == value {
+			return &feedShelves[i]
+		}
+	}
+	return nil
+}
+
+type opdsFeed struct {
+	XMLName   xml.Name    `xml:"feed"`
+	Xmlns     string      `xml:"xmlns,attr"`
+	XmlnsDC   string      `xml:"xmlns:dc,attr"`
+	XmlnsOPDS string      `xml:"xmlns:opds,attr"`
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Icon      string      `xml:"icon,omitempty"`
+	Author    *atomAuthor `xml:"author,omitempty"`
+	Links     []atomLink  `xml:"link"`
+	Entries   []opdsEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomLink struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type opdsEntry struct {
+	ID          string       `xml:"id"`
+	Title       string       `xml:"title"`
+	Updated     string       `xml:"updated"`
+	Authors     []atomAuthor `xml:"author"`
+	Identifiers []string     `xml:"dc:identifier"`
+	Categories  []string     `xml:"dc:subject"`
+	Summary     *atomText    `xml:"summary,omitempty"`
+	Content     *atomText    `xml:"content,omitempty"`
+	Links       []atomLink   `xml:"link"`
+}
+
+// newOPDSFeed starts a feed of a user's catalog, linking back to its start
+func newOPDSFeed(c *fiber.Ctx, user *models.User, id, title string) *opdsFeed {
+	base := baseURL(c)
+	start := base + "/u/" + user.Username + "/opds"
+	return &opdsFeed{
+		Xmlns:     "http://www.w3.org/2005/Atom",
+		XmlnsDC:   "http://purl.org/dc/terms/",
+		XmlnsOPDS: "http://opds-spec.org/2010/catalog",
+		ID:        id,
+		Title:     title,
+		Icon:      base + user.GetProfilePictureURL(),
+		Author:    &atomAuthor{Name: user.DisplayName, URI: base + "/u/" + user.Username},
+		Links: []atomLink{
+			{Rel: "start", Href: start, Type: opdsNavigationType, Title: user.DisplayName + "'s books"},
+			{Rel: "alternate", Href: base + "/u/" + user.Username, Type: "text/html"},
+		},
+	}
+}
+
+// sendOPDS writes a feed with the given OPDS media type
+func sendOPDS(c *fiber.Ctx, feed *opdsFeed, mediaType string) error {
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error building catalog")
+	}
+	c.Set("Content-Type", mediaType+";charset=utf-8")
+	return c.Send(append([]byte(xml.Header), data...))
+}
+
+// publicUser loads the user whose catalog or feed is requested
+func publicUser(c *fiber.Ctx) (*models.User, error) {
+	user, err := models.GetUserByUsername(c.Params("username"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fiber.NewError(fiber.StatusNotFound, "User not found")
+		}
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Error loading user")
+	}
+	return user, nil
+}
+
+// catalogUser loads the user whose OPDS catalog is requested. Users who
+// turned their catalog off are treated as having none.
+func catalogUser(c *fiber.Ctx) (*models.User, error) {
+	user, err := publicUser(c)
+	if err != nil {
+		return nil, err
+	}
+	public, err := models.IsCatalogPublic(user.ID)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Error loading user")
+	}
+	if !public {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Catalog not found")
+	}
+	return user, nil
+}
+
+// opdsTime reads a stored timestamp, falling back to the given time
+func opdsTime(value sql.NullString, fallback time.Time) time.Time {
+	if value.Valid {
+		for _, layout := range []string{"2006-01-02 15:04:05", time.RFC3339, "2006-01-02"} {
+			if t, err := time.ParseInLocation(layout, value.String, time.UTC); err == nil {
+				return t
+			}
+		}
+	}
+	return fallback
+}
+
+// OPDSCatalog serves the navigation feed of a user's catalog, with an entry
+// for each public shelf, for e-reader apps that browse OPDS catalogs
+func OPDSCatalog(c *fiber.Ctx) error {
+	user, err := catalogUser(c)
+	if err != nil {
+		return err
+	}
+	counts, updated, err := catalogShelfCounts(user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading books")
+	}
+
+	base := baseURL(c)
+	self := base + "/u/" + user.Username + "/opds"
+	feed := newOPDSFeed(c, user, self, user.DisplayName+"'s books")
+	feed.Links = append(feed.Links,
+		atomLink{Rel: "self", Href: self, Type: opdsNavigationType},
+		atomLink{Rel: "alternate", Href: base + "/u/" + user.Username + "/opds2", Type: opds2Type},
+	)
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	for _, shelf := range feedShelves {
+		href := self + "/" + shelf.value
+		feed.Entries = append(feed.Entries, opdsEntry{
+			ID:      href,
+			Title:   shelf.title,
+			Updated: feed.Updated,
+			Content: &atomText{Type: "text", Text: formatBookCount(counts[shelf.value])},
+			Links: []atomLink{
+				{Rel: "subsection", Href: href, Type: opdsAcquisitionType},
+			},
+		})
+	}
+	return sendOPDS(c, feed, opdsNavigationType)
+}
+
+// OPDSShelf serves the acquisition feed of one of a user's public shelves, a
+// page at a time. Spines keeps no book files, so each entry's acquisition
+// link leads to the book on Google Books.
+func OPDSShelf(c *fiber.Ctx) error {
+	user, shelf, page, books, total, err := catalogShelfPage(c)
+	if err != nil {
+		return err
+	}
+
+	base := baseURL(c)
+	href := base + "/u/" + user.Username + "/opds/" + shelf.value
+	pageHref := func(n int) string {
+		if n == 1 {
+			return href
+		}
+		return href + "?page=" + strconv.Itoa(n)
+	}
+	feed := newOPDSFeed(c, user, href, user.DisplayName+"'s books: "+shelf.title)
+	feed.Links = append(feed.Links,
+		atomLink{Rel: "self", Href: pageHref(page), Type: opdsAcquisitionType},
+		atomLink{Rel: "up", Href: base + "/u/" + user.Username + "/opds", Type: opdsNavigationType},
+	)
+	if page > 1 {
+		feed.Links = append(feed.Links, atomLink{Rel: "previous", Href: pageHref(page - 1), Type: opdsAcquisitionType})
+	}
+	if page*opdsPageSize < total {
+		feed.Links = append(feed.Links, atomLink{Rel: "next", Href: pageHref(page + 1), Type: opdsAcquisitionType})
+	}
+
+	updated := user.CreatedAt
+	for _, ub := range books {
+		if t := opdsTime(ub.AddedAt, ub.Book.CreatedAt); t.After(updated) {
+			updated = t
+		}
+		feed.Entries = append(feed.Entries, opdsBookEntry(base, ub))
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	return sendOPDS(c, feed, opdsAcquisitionType)
+}
+
+// opdsBookEntry describes a shelved book for an acquisition feed
+func opdsBookEntry(base string, ub models.UserBook) opdsEntry {
+	b := ub.Book
+	entry := opdsEntry{
+		ID:      "urn:spines:book:" + strconv.FormatInt(b.ID, 10),
+		Title:   b.Title,
+		Updated: opdsTime(ub.AddedAt, b.CreatedAt).UTC().Format(time.RFC3339),
+	}
+	for _, name := range strings.Split(b.Authors, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			entry.Authors = append(entry.Authors, atomAuthor{Name: name})
+		}
+	}
+	for _, isbn := range []sql.NullString{b.ISBN13, b.ISBN10} {
+		if isbn.Valid && isbn.String != "" {
+			entry.Identifiers = append(entry.Identifiers, "urn:isbn:"+isbn.String)
+		}
+	}
+	if b.Categories.Valid && b.Categories.String != "" {
+		entry.Categories = strings.Split(b.Categories.String, services.CategorySeparator)
+	}
+	if description := b.DescriptionText(); description != "" {
+		entry.Summary = &atomText{Type: "text", Text: description}
+	}
+	if b.GoogleBooksID != "" {
+		cover := base + "/api/images/book/" + b.GoogleBooksID
+		entry.Links = append(entry.Links,
+			atomLink{Rel: "http://opds-spec.org/image", Href: cover, Type: "image/jpeg"},
+			atomLink{Rel: "http://opds-spec.org/image/thumbnail", Href: cover, Type: "image/jpeg"},
+			atomLink{Rel: "http://opds-spec.org/acquisition", Href: "https://books.google.com/books?id=" + b.GoogleBooksID, Type: "text/html", Title: "Google Books"},
+		)
+	}
+	return entry
+}
+
+// catalogShelfCounts counts the books on each catalog shelf, and returns when
+// the catalog last changed, which it does whenever a book is shelved
+func catalogShelfCounts(user *models.User) (map[string]int, time.Time, error) {
+	shelves, err := models.GetUserBooks(user.ID)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	counts := map[string]int{
+		"currently_reading": len(shelves.CurrentlyReading),
+		"want_to_read":      len(shelves.WantToRead),
+		"read":              len(shelves.Read),
+	}
+	updated := user.CreatedAt
+	for _, shelf := range [][]models.UserBook{shelves.CurrentlyReading, shelves.WantToRead, shelves.Read} {
+		for _, ub := range shelf {
+			if t := opdsTime(ub.AddedAt, updated); t.After(updated) {
+				updated = t
+			}
+		}
+	}
+	return counts, updated, nil
+}
+
+// catalogShelfPage loads the requested page of a catalog shelf
+func catalogShelfPage(c *fiber.Ctx) (*models.User, *feedShelf, int, []models.UserBook, int, error) {
+	user, err := catalogUser(c)
+	if err != nil {
+		return nil, nil, 0, nil, 0, err
+	}
+	shelf := findFeedShelf(c.Params("shelf"))
+	if shelf == nil {
+		return nil, nil, 0, nil, 0, fiber.NewError(fiber.StatusNotFound, "Shelf not found")
+	}
+
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+	books, total, err := models.GetShelfBooksPaginated(user.ID, shelf.value, models.ShelfFilter{}, (page-1)*opdsPageSize, opdsPageSize)
+	if err != nil {
+		return nil, nil, 0, nil, 0, fiber.NewError(fiber.StatusInternalServerError, "Error loading books")
+	}
+	return user, shelf, page, books, total, nil
+}
+
+type opds2Feed struct {
+	Metadata     opds2FeedMetadata  `json:"metadata"`
+	Links        []opds2Link        `json:"links"`
+	Navigation   []opds2Link        `json:"navigation,omitempty"`
+	Publications []opds2Publication `json:"publications,omitempty"`
+}
+
+type opds2FeedMetadata struct {
+	Title         string `json:"title"`
+	Modified      string `json:"modified,omitempty"`
+	NumberOfItems *int   `json:"numberOfItems,omitempty"`
+	ItemsPerPage  int    `json:"itemsPerPage,omitempty"`
+	CurrentPage   int    `json:"currentPage,omitempty"`
+}
+
+type opds2Link struct {
+	Rel   string `json:"rel,omitempty"`
+	Href  string `json:"href"`
+	Type  string `json:"type,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+type opds2Publication struct {
+	Metadata opds2Metadata `json:"metadata"`
+	Links    []opds2Link   `json:"links"`
+	Images   []opds2Link   `json:"images,omitempty"`
+}
+
+type opds2Metadata struct {
+	Type        string             `json:"@type"`
+	Identifier  string             `json:"identifier,omitempty"`
+	Title       string             `json:"title"`
+	Author      []opds2Contributor `json:"author,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Subject     []string           `json:"subject,omitempty"`
+	Modified    string             `json:"modified"`
+}
+
+type opds2Contributor struct {
+	Name string `json:"name"`
+}
+
+// opds2Links are the links every OPDS 2.0 feed of a user's catalog has
+func opds2Links(base string, user *models.User, self string) []opds2Link {
+	return []opds2Link{
+		{Rel: "self", Href: self, Type: opds2Type},
+		{Rel: "start", Href: base + "/u/" + user.Username + "/opds2", Type: opds2Type, Title: user.DisplayName + "'s books"},
+		{Rel: "alternate", Href: base + "/u/" + user.Username, Type: "text/html"},
+	}
+}
+
+// sendOPDS2 writes an OPDS 2.0 feed
+func sendOPDS2(c *fiber.Ctx, feed *opds2Feed) error {
+	data, err := json.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error building catalog")
+	}
+	c.Set("Content-Type", opds2Type+";charset=utf-8")
+	return c.Send(data)
+}
+
+// OPDS2Catalog serves the OPDS 2.0 version of OPDSCatalog
+func OPDS2Catalog(c *fiber.Ctx) error {
+	user, err := catalogUser(c)
+	if err != nil {
+		return err
+	}
+	counts, updated, err := catalogShelfCounts(user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading books")
+	}
+
+	base := baseURL(c)
+	self := base + "/u/" + user.Username + "/opds2"
+	feed := &opds2Feed{
+		Metadata: opds2FeedMetadata{Title: user.DisplayName + "'s books", Modified: updated.UTC().Format(time.RFC3339)},
+		Links:    opds2Links(base, user, self),
+	}
+	for _, shelf := range feedShelves {
+		feed.Navigation = append(feed.Navigation, opds2Link{
+			Rel:   "subsection",
+			Href:  self + "/" + shelf.value,
+			Type:  opds2Type,
+			Title: shelf.title + " (" + formatBookCount(counts[shelf.value]) + ")",
+		})
+	}
+	return sendOPDS2(c, feed)
+}
+
+// OPDS2Shelf serves the OPDS 2.0 version of OPDSShelf
+func OPDS2Shelf(c *fiber.Ctx) error {
+	user, shelf, page, books, total, err := catalogShelfPage(c)
+	if err != nil {
+		return err
+	}
+
+	base := baseURL(c)
+	href := base + "/u/" + user.Username + "/opds2/" + shelf.value
+	pageHref := func(n int) string {
+		if n == 1 {
+			return href
+		}
+		return href + "?page=" + strconv.Itoa(n)
+	}
+	feed := &opds2Feed{
+		Metadata: opds2FeedMetadata{
+			Title:         user.DisplayName + "'s books: " + shelf.title,
+			NumberOfItems: &total,
+			ItemsPerPage:  opdsPageSize,
+			CurrentPage:   page,
+		},
+		Links:        opds2Links(base, user, pageHref(page)),
+		Publications: []opds2Publication{},
+	}
+	feed.Links = append(feed.Links, opds2Link{Rel: "up", Href: base + "/u/" + user.Username + "/opds2", Type: opds2Type})
+	if page > 1 {
+		feed.Links = append(feed.Links, opds2Link{Rel: "previous", Href: pageHref(page - 1), Type: opds2Type})
+	}
+	if page*opdsPageSize < total {
+		feed.Links = append(feed.Links, opds2Link{Rel: "next", Href: pageHref(page + 1), Type: opds2Type})
+	}
+
+	for _, ub := range books {
+		feed.Publications = append(feed.Publications, opds2BookPublication(base, ub))
+	}
+	return sendOPDS2(c, feed)
+}
+
+// opds2BookPublication describes a shelved book for an OPDS 2.0 feed, with
+// the same details as opdsBookEntry
+func opds2BookPublication(base string, ub models.UserBook) opds2Publication {
+	entry := opdsBookEntry(base, ub)
+	pub := opds2Publication{
+		Metadata: opds2Metadata{
+			Type:     "http://schema.org/Book",
+			Title:    entry.Title,
+			Modified: entry.Updated,
+			Subject:  entry.Categories,
+		},
+		Links: []opds2Link{},
+	}
+	if len(entry.Identifiers) > 0 {
+		pub.Metadata.Identifier = entry.Identifiers[0]
+	}
+	for _, author := range entry.Authors {
+		pub.Metadata.Author = append(pub.Metadata.Author, opds2Contributor{Name: author.Name})
+	}
+	if entry.Summary != nil {
+		pub.Metadata.Description = entry.Summary.Text
+	}
+	for _, link := range entry.Links {
+		switch link.Rel {
+		case "http://opds-spec.org/image":
+			pub.Images = append(pub.Images, opds2Link{Href: link.Href, Type: link.Type})
+		case "http://opds-spec.org/acquisition":
+			pub.Links = append(pub.Links, opds2Link{Rel: link.Rel, Href: link.Href, Type: link.Type, Title: link.Title})
+		}
+	}
+	return pub
+}
@@ -8,15 +8,20 @@ import (
 func ProfilePage(c *fiber.Ctx) error {
 	user := c.Locals("user").(*models.User)
 
-	return c.Render("pages/user/profile", NavData(c, fiber.Map{
-		"User":    user,
-		"Themes":  models.ValidThemes,
-		"Error":   c.Query("error"),
-		"Success": c.Query("success"),
-		// SEO metadata
-		"PageTitle":  "My Profile",
-		"MetaRobots": "noindex, nofollow",
-	}), "layouts/base")
+	data, err := dataExportsData(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading profile")
+	}
+	data["User"] = user
+	data["Themes"] = models.ValidThemes
+	data["CatalogPublic"], _ = models.IsCatalogPublic(user.ID)
+	data["Error"] = c.Query("error")
+	data["Success"] = c.Query("success")
+	// SEO metadata
+	data["PageTitle"] = "My Profile"
+	data["MetaRobots"] = "noindex, nofollow"
+
+	return c.Render("pages/user/profile", NavData(c, data), "layouts/base")
 }
 
 func UpdateProfile(c *fiber.Ctx) error {
@@ -84,3 +89,14 @@ func UpdateTheme(c *fiber.Ctx) error {
 
 	return c.Redirect("/profile?success=Theme+updated+successfully")
 }
+
+// UpdateCatalogVisibility turns the user's OPDS catalog on or off
+func UpdateCatalogVisibility(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	if err := models.SetCatalogPublic(user.ID, c.FormValue("catalog_public") == "1"); err != nil {
+		return c.Redirect("/profile?error=Failed+to+update+catalog+setting")
+	}
+
+	return c.Redirect("/profile?success=Catalog+setting+updated")
+}
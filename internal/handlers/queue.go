@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nuuner/spines/internal/models"
+)
+
+// Number of queued books shown in "Up next" on the public user page
+const publicUpNextLimit = 3
+
+// ReorderQueue saves a new order for the want to read shelf. The form holds
+// one book_id value per book, in queue order.
+func (h *UserBooksHandler) ReorderQueue(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	var bookIDs []int64
+	for _, value := range c.Request().PostArgs().PeekMulti("book_id") {
+		bookID, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid book ID")
+		}
+		bookIDs = append(bookIDs, bookID)
+	}
+
+	if err := models.ReorderQueue(user.ID, bookIDs); err != nil {
+		if c.Get("HX-Request") == "true" {
+			return c.Status(fiber.StatusInternalServerError).SendString("Failed to reorder queue")
+		}
+		return c.Redirect("/my-books?error=Failed+to+reorder+queue")
+	}
+
+	// The page is already in the new order for HTMX requests
+	if c.Get("HX-Request") == "true" {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+
+	return c.Redirect("/my-books")
+}
+
+// MoveToTopOfQueue makes a want to read book the next one to read
+func (h *UserBooksHandler) MoveToTopOfQueue(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	bookID, err := strconv.ParseInt(c.Params("book_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid book ID")
+	}
+
+	userBook, err := models.GetUserBook(user.ID, bookID)
+	if err != nil || userBook.Shelf != "want_to_read" {
+		return c.Redirect("/my-books?error=Book+is+not+in+your+want+to+read+queue")
+	}
+
+	if err := models.MoveToTopOfQueue(user.ID, bookID); err != nil {
+		return c.Redirect("/my-books?error=Failed+to+reorder+queue")
+	}
+
+	return c.Redirect("/my-books")
+}
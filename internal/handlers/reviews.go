@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nuuner/spines/internal/markdown"
+	"github.com/nuuner/spines/internal/models"
+)
+
+// Maximum length of a review's Markdown source
+const maxReviewLength = 20000
+
+// Number of reviews shown on the public user page
+const publicReviewsLimit = 5
+
+// ReviewPage shows the Markdown editor for a shelved book's review
+func (h *UserBooksHandler) ReviewPage(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	bookID, err := strconv.ParseInt(c.Params("book_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid book ID")
+	}
+
+	userBook, err := models.GetUserBook(user.ID, bookID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(fiber.StatusNotFound).SendString("Book not found on your shelves")
+		}
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading book")
+	}
+
+	book, err := models.GetBookByID(bookID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading book")
+	}
+	userBook.Book = book
+
+	review, err := models.GetReview(userBook.ID)
+	if err != nil && err != sql.ErrNoRows {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading review")
+	}
+
+	return c.Render("pages/user/review", NavData(c, fiber.Map{
+		"User":     user,
+		"UserBook": userBook,
+		"Review":   review,
+		"Error":    c.Query("error"),
+		// SEO metadata
+		"PageTitle":  "Review - " + book.Title,
+		"MetaRobots": "noindex, nofollow",
+	}), "layouts/base")
+}
+
+// PreviewReview renders the submitted Markdown for the editor's live preview
+func (h *UserBooksHandler) PreviewReview(c *fiber.Ctx) error {
+	return c.Render("partials/review_preview", fiber.Map{
+		"Body": markdown.Render(c.FormValue("body")),
+	})
+}
+
+// SaveReview creates, updates or (when the body is empty) deletes a review
+func (h *UserBooksHandler) SaveReview(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	bookID, err := strconv.ParseInt(c.Params("book_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid book ID")
+	}
+
+	reviewURL := "/my-books/" + c.Params("book_id") + "/review"
+
+	userBook, err := models.GetUserBook(user.ID, bookID)
+	if err != nil {
+		return c.Redirect("/my-books?error=Book+not+found+on+your+shelves")
+	}
+
+	body := strings.TrimSpace(c.FormValue("body"))
+	if len(body) > maxReviewLength {
+		return c.Redirect(reviewURL + "?error=Review+is+too+long")
+	}
+
+	if body == "" {
+		if err := models.DeleteReview(userBook.ID); err != nil {
+			return c.Redirect(reviewURL + "?error=Failed+to+delete+review")
+		}
+		return c.Redirect("/my-books")
+	}
+
+	created, err := models.SaveReview(userBook.ID, body)
+	if err != nil {
+		return c.Redirect(reviewURL + "?error=Failed+to+save+review")
+	}
+
+	// Only announce the first version of a review, not later edits
+	if created {
+		_ = models.CreateReviewPostedEvent(user.ID, bookID, userBook.Shelf)
+	}
+
+	return c.Redirect("/my-books")
+}
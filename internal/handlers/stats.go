@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"database/sql"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nuuner/spines/internal/models"
+)
+
+// UserStatsPage shows a user's public reading stats
+func UserStatsPage(c *fiber.Ctx) error {
+	user, err := models.GetUserByUsername(c.Params("username"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(fiber.StatusNotFound).SendString("User not found")
+		}
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading user")
+	}
+
+	stats, err := models.GetReadingStats(user.ID, false)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading stats")
+	}
+
+	metaDesc := user.DisplayName + " has read " + formatBookCount(stats.BooksRead) + " on Spines"
+	return c.Render("pages/user_stats", NavData(c, fiber.Map{
+		"User":  user,
+		"Stats": stats,
+		// SEO metadata
+		"PageTitle":       user.DisplayName + "'s Reading Stats",
+		"MetaDescription": metaDesc,
+		"OGTitle":         user.DisplayName + "'s Reading Stats - Spines",
+		"OGDescription":   metaDesc,
+		"OGImage":         user.GetProfilePictureURL(),
+		"OGType":          "profile",
+	}), "layouts/base")
+}
+
+// UserStatsJSON returns a user's public reading stats as JSON, for building
+// charts elsewhere
+func UserStatsJSON(c *fiber.Ctx) error {
+	user, err := models.GetUserByUsername(c.Params("username"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load user",
+		})
+	}
+
+	stats, err := models.GetReadingStats(user.ID, false)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load stats",
+		})
+	}
+	return c.JSON(stats)
+}
+
+// StatsPage shows the user's own reading stats, including private shelves,
+// formats, ownership and tags
+func (h *UserBooksHandler) StatsPage(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	stats, err := models.GetReadingStats(user.ID, true)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading stats")
+	}
+
+	return c.Render("pages/user/stats", NavData(c, fiber.Map{
+		"User":  user,
+		"Stats": stats,
+		// SEO metadata
+		"PageTitle":  "Reading Stats",
+		"MetaRobots": "noindex, nofollow",
+	}), "layouts/base")
+}
+
+// StatsJSON returns the user's own reading stats as JSON
+func (h *UserBooksHandler) StatsJSON(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	stats, err := models.GetReadingStats(user.ID, true)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load stats",
+		})
+	}
+	return c.JSON(stats)
+}
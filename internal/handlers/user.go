@@ -28,11 +28,22 @@ func UserPage(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).SendString("Error loading user")
 	}
 
-	shelves, err := models.GetUserBooks(user.ID)
+	filter := parsePublicShelfFilter(c)
+	shelves, err := models.GetUserBooksFiltered(user.ID, filter)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString("Error loading books")
 	}
 
+	// Choices for the tag and year filters
+	tags, err := models.GetUserTags(user.ID)
+	if err != nil {
+		tags = []string{}
+	}
+	years, err := models.GetFinishedYears(user.ID)
+	if err != nil {
+		years = []int{}
+	}
+
 	// Build meta description
 	metaDesc := user.DisplayName + "'s book collection on Spines"
 	if user.Description != "" {
@@ -51,10 +62,54 @@ func UserPage(c *fiber.Ctx) error {
 		events = []models.Event{}
 	}
 
+	reviews, err := models.GetUserReviews(user.ID, publicReviewsLimit)
+	if err != nil {
+		reviews = []models.Review{}
+	}
+
+	quotes, err := models.GetPublicQuotes(user.ID, publicQuotesLimit)
+	if err != nil {
+		quotes = []models.Quote{}
+	}
+
+	// The activity calendar is left out if it fails to load
+	activity, _ := models.GetActivityCalendar(user.ID)
+
+	// The want to read shelf is in queue order unless filtered or sorted,
+	// so its first books are up next
+	var upNext []models.UserBook
+	if filter.IsEmpty() {
+		upNext = shelves.WantToRead
+		if len(upNext) > publicUpNextLimit {
+			upNext = upNext[:publicUpNextLimit]
+		}
+	}
+
+	// The catalog link is only shown for users who offer one
+	catalogPublic, _ := models.IsCatalogPublic(user.ID)
+
+	// Whether the signed in reader follows this user, for the follow button
+	followers, _ := models.CountFollowers(user.ID)
+	isFollowing := false
+	if current, ok := c.Locals("CurrentUser").(*models.User); ok {
+		isFollowing, _ = models.IsFollowing(current.ID, user.ID)
+	}
+
 	return c.Render("pages/user", NavData(c, fiber.Map{
 		"User":                    user,
+		"Followers":               followers,
+		"IsFollowing":             isFollowing,
+		"CatalogPublic":           catalogPublic,
 		"Shelves":                 shelves,
+		"UpNext":                  upNext,
+		"Filter":                  filter,
+		"Tags":                    tags,
+		"Years":                   years,
+		"Goal":                    currentReadingGoal(user.ID),
+		"Activity":                activity,
 		"Events":                  events,
+		"Reviews":                 reviews,
+		"Quotes":                  quotes,
 		"WantToReadTotal":         len(shelves.WantToRead),
 		"ReadTotal":               len(shelves.Read),
 		"PublicShelfInitialLimit": publicShelfInitialLimit,
@@ -65,9 +120,19 @@ func UserPage(c *fiber.Ctx) error {
 		"OGDescription":   metaDesc,
 		"OGImage":         user.GetProfilePictureURL(),
 		"OGType":          "profile",
+		"FeedLinks":       userFeedLinks(user, catalogPublic),
 	}), "layouts/base")
 }
 
+// parsePublicShelfFilter reads the shelf filters available on public pages.
+// Format and ownership are private to the owner, so they are never applied here.
+func parsePublicShelfFilter(c *fiber.Ctx) models.ShelfFilter {
+	filter := parseShelfFilter(c)
+	filter.Format = ""
+	filter.Owned = sql.NullBool{}
+	return filter
+}
+
 func formatBookCount(count int) string {
 	if count == 1 {
 		return "1 book"
@@ -94,7 +159,8 @@ func GetPublicShelfBooks(c *fiber.Ctx) error {
 	offset := c.QueryInt("offset", 0)
 	limit := 20 // load 20 more each time
 
-	books, total, err := models.GetShelfBooksPaginated(user.ID, shelf, offset, limit)
+	filter := parsePublicShelfFilter(c)
+	books, total, err := models.GetShelfBooksPaginated(user.ID, shelf, filter, offset, limit)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString("Error loading books")
 	}
@@ -108,6 +174,7 @@ func GetPublicShelfBooks(c *fiber.Ctx) error {
 		"Books":      books,
 		"Shelf":      shelf,
 		"Username":   username,
+		"Filter":     filter,
 		"NextOffset": offset + len(books),
 		"Remaining":  remaining,
 	})
@@ -3,7 +3,9 @@ package handlers
 import (
 	"database/sql"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/nuuner/spines/internal/config"
@@ -20,6 +22,8 @@ var ValidShelves = map[string]bool{
 	"want_to_read":      true,
 	"currently_reading": true,
 	"read":              true,
+	"on_hold":           true,
+	"did_not_finish":    true,
 }
 
 // isValidShelf checks if the provided shelf name is valid
@@ -27,6 +31,102 @@ func isValidShelf(shelf string) bool {
 	return ValidShelves[shelf]
 }
 
+// parseRating converts a submitted rating (in points, see models.RatingOptions)
+// into its stored form. Missing or out-of-range values are treated as no rating.
+func parseRating(value string) sql.NullInt64 {
+	if value == "" {
+		return sql.NullInt64{}
+	}
+	points, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || !models.IsValidRating(points) {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: points, Valid: true}
+}
+
+// parseOwnership reads a submitted format and ownership ("1", "0" or "" for unknown).
+// Unknown formats are treated as not recorded.
+func parseOwnership(c *fiber.Ctx) (sql.NullString, sql.NullBool) {
+	var format sql.NullString
+	if f := c.FormValue("format"); models.IsValidFormat(f) {
+		format = sql.NullString{String: f, Valid: true}
+	}
+	var owned sql.NullBool
+	switch c.FormValue("owned") {
+	case "1":
+		owned = sql.NullBool{Bool: true, Valid: true}
+	case "0":
+		owned = sql.NullBool{Bool: false, Valid: true}
+	}
+	return format, owned
+}
+
+// Maximum length of the text filter on shelves
+const maxShelfQueryLength = 100
+
+// parseShelfFilter reads the shelf filters and sort from the query string.
+// Invalid values are ignored rather than rejected, as they come from shared URLs.
+func parseShelfFilter(c *fiber.Ctx) models.ShelfFilter {
+	var filter models.ShelfFilter
+	if f := c.Query("format"); models.IsValidFormat(f) {
+		filter.Format = f
+	}
+	switch c.Query("owned") {
+	case "1":
+		filter.Owned = sql.NullBool{Bool: true, Valid: true}
+	case "0":
+		filter.Owned = sql.NullBool{Bool: false, Valid: true}
+	}
+	if rating := c.QueryInt("rating"); models.IsValidRating(int64(rating)) {
+		filter.MinRating = rating
+	}
+	if year := c.QueryInt("year"); year >= 1000 && year <= 9999 {
+		filter.Year = year
+	}
+	filter.Tag = models.NormalizeTag(c.Query("tag"))
+	if q := strings.TrimSpace(c.Query("q")); len(q) <= maxShelfQueryLength {
+		filter.Query = q
+	}
+	if sort := c.Query("sort"); models.IsValidSort(sort) {
+		filter.Sort = sort
+	}
+	return filter
+}
+
+// Maximum length of the reason given for stopping a book, in characters
+const maxStoppedReasonLength = 500
+
+// parseStopDetails reads where the user stopped reading (page and/or percent)
+// and why. Invalid numbers are ignored rather than rejected.
+func parseStopDetails(c *fiber.Ctx) (page, percent sql.NullInt64, reason sql.NullString) {
+	if p, err := strconv.ParseInt(strings.TrimSpace(c.FormValue("stopped_page")), 10, 64); err == nil && p > 0 {
+		page = sql.NullInt64{Int64: p, Valid: true}
+	}
+	if p, err := strconv.ParseInt(strings.TrimSpace(c.FormValue("stopped_percent")), 10, 64); err == nil && p >= 0 && p <= 100 {
+		percent = sql.NullInt64{Int64: p, Valid: true}
+	}
+	if r := strings.TrimSpace(c.FormValue("stopped_reason")); r != "" {
+		if utf8.RuneCountInString(r) > maxStoppedReasonLength {
+			r = string([]rune(r)[:maxStoppedReasonLength])
+		}
+		reason = sql.NullString{String: r, Valid: true}
+	}
+	return page, percent, reason
+}
+
+// createShelfChangeEvent records a book moving between shelves. Pausing or
+// abandoning a book gets its own event type instead of book_moved.
+func createShelfChangeEvent(userID, bookID int64, oldShelf, newShelf string, page, percent sql.NullInt64) {
+	if models.IsStoppedShelf(newShelf) {
+		_ = models.CreateBookStoppedEvent(userID, bookID, oldShelf, newShelf, page, percent)
+		return
+	}
+	_ = models.CreateBookMovedEvent(userID, bookID, oldShelf, newShelf)
+	if newShelf == "read" {
+		_ = models.CheckReadingGoals(userID)
+	}
+}
+
 func NewUserBooksHandler(cfg *config.Config) *UserBooksHandler {
 	return &UserBooksHandler{Config: cfg}
 }
@@ -34,15 +134,32 @@ func NewUserBooksHandler(cfg *config.Config) *UserBooksHandler {
 func (h *UserBooksHandler) MyBooks(c *fiber.Ctx) error {
 	user := c.Locals("user").(*models.User)
 
-	shelves, err := models.GetUserBooks(user.ID)
+	filter := parseShelfFilter(c)
+	shelves, err := models.GetUserBooksFiltered(user.ID, filter)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString("Error loading books")
 	}
 
+	// Choices for the tag and year filters
+	tags, err := models.GetUserTags(user.ID)
+	if err != nil {
+		tags = []string{}
+	}
+	years, err := models.GetFinishedYears(user.ID)
+	if err != nil {
+		years = []int{}
+	}
+
 	return c.Render("pages/user/my_books", NavData(c, fiber.Map{
 		"User":    user,
 		"Shelves": shelves,
+		"Filter":  filter,
+		"Tags":    tags,
+		"Years":   years,
+		"Goal":    currentReadingGoal(user.ID),
+		"Year":    time.Now().Year(),
 		"Error":   c.Query("error"),
+		"Success": c.Query("success"),
 		// SEO metadata
 		"PageTitle":  "My Books",
 		"MetaRobots": "noindex, nofollow",
@@ -156,19 +273,14 @@ func (h *UserBooksHandler) AddBook(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Redirect("/my-books?error=Failed+to+create+book")
 	}
+	_ = models.FetchBookCategories(book.ID, book.GoogleBooksID, h.Config.GoogleBooksAPIKey)
 
 	var nullSubStatus sql.NullString
 	if subStatus != "" {
 		nullSubStatus = sql.NullString{String: subStatus, Valid: true}
 	}
 
-	var nullRating sql.NullInt64
-	if ratingStr != "" {
-		rating, err := strconv.ParseInt(ratingStr, 10, 64)
-		if err == nil && rating >= 1 && rating <= 5 {
-			nullRating = sql.NullInt64{Int64: rating, Valid: true}
-		}
-	}
+	nullRating := parseRating(ratingStr)
 
 	// Check if user already has this book on any shelf
 	existingBook, _ := models.GetUserBook(user.ID, book.ID)
@@ -182,7 +294,7 @@ func (h *UserBooksHandler) AddBook(c *fiber.Ctx) error {
 
 		// Create event for book moved (if shelf changed)
 		if oldShelf != shelf {
-			_ = models.CreateBookMovedEvent(user.ID, book.ID, oldShelf, shelf)
+			createShelfChangeEvent(user.ID, book.ID, oldShelf, shelf, sql.NullInt64{}, sql.NullInt64{})
 		}
 	} else {
 		// Book doesn't exist - add it
@@ -193,6 +305,17 @@ func (h *UserBooksHandler) AddBook(c *fiber.Ctx) error {
 
 		// Create event for book added to shelf
 		_ = models.CreateBookAddedEvent(user.ID, book.ID, shelf)
+		if shelf == "read" {
+			_ = models.CheckReadingGoals(user.ID)
+		}
+	}
+
+	// Only touch ownership when the form says something about it, so re-adding
+	// a book to move it doesn't forget what was recorded before
+	if format, owned := parseOwnership(c); format.Valid || owned.Valid {
+		if err := models.UpdateUserBookOwnership(user.ID, book.ID, format, owned); err != nil {
+			return c.Redirect("/my-books?error=Failed+to+save+format+and+ownership")
+		}
 	}
 
 	return c.Redirect("/my-books")
@@ -238,15 +361,20 @@ func (h *UserBooksHandler) UpdateBook(c *fiber.Ctx) error {
 		nullSubStatus = sql.NullString{String: subStatus, Valid: true}
 	}
 
-	var nullRating sql.NullInt64
-	if ratingStr != "" {
-		rating, err := strconv.ParseInt(ratingStr, 10, 64)
-		if err == nil && rating >= 1 && rating <= 5 {
-			nullRating = sql.NullInt64{Int64: rating, Valid: true}
+	nullRating := parseRating(ratingStr)
+
+	var stoppedPage, stoppedPercent sql.NullInt64
+	if models.IsStoppedShelf(shelf) {
+		var stoppedReason sql.NullString
+		stoppedPage, stoppedPercent, stoppedReason = parseStopDetails(c)
+		// Fall back to the last reported progress when stopping a book being read
+		if !stoppedPage.Valid && !stoppedPercent.Valid && currentBook != nil && currentBook.ReadingProgress() >= 0 {
+			stoppedPercent = sql.NullInt64{Int64: int64(currentBook.ReadingProgress()), Valid: true}
 		}
+		err = models.StopReading(user.ID, bookID, shelf, stoppedPage, stoppedPercent, stoppedReason)
+	} else {
+		err = models.UpdateUserBook(user.ID, bookID, shelf, nullSubStatus, nullRating)
 	}
-
-	err = models.UpdateUserBook(user.ID, bookID, shelf, nullSubStatus, nullRating)
 	if err != nil {
 		return c.Redirect("/my-books?error=Failed+to+update+book")
 	}
@@ -254,8 +382,8 @@ func (h *UserBooksHandler) UpdateBook(c *fiber.Ctx) error {
 	// Create appropriate event based on what changed
 	if currentBook != nil {
 		if oldShelf != shelf {
-			// Shelf changed - create book moved event
-			_ = models.CreateBookMovedEvent(user.ID, bookID, oldShelf, shelf)
+			// Shelf changed - create book moved (or paused/DNF) event
+			createShelfChangeEvent(user.ID, bookID, oldShelf, shelf, stoppedPage, stoppedPercent)
 		} else if oldSubStatus != subStatus && shelf == "currently_reading" {
 			// Reading progress changed
 			_ = models.CreateReadingProgressEvent(user.ID, bookID, oldSubStatus, subStatus)
@@ -265,6 +393,40 @@ func (h *UserBooksHandler) UpdateBook(c *fiber.Ctx) error {
 	return c.Redirect("/my-books")
 }
 
+// UpdateOwnership sets a book's format and whether the user owns it, without changing its shelf
+func (h *UserBooksHandler) UpdateOwnership(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	bookID, err := strconv.ParseInt(c.Params("book_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid book ID")
+	}
+
+	format, owned := parseOwnership(c)
+	if err := models.UpdateUserBookOwnership(user.ID, bookID, format, owned); err != nil {
+		return c.Redirect("/my-books?error=Failed+to+update+format+and+ownership")
+	}
+
+	return c.Redirect("/my-books")
+}
+
+// UpdateTags replaces the tags on a shelved book
+func (h *UserBooksHandler) UpdateTags(c *fiber.Ctx) error {
+	user := c.Locals("user").(*models.User)
+
+	bookID, err := strconv.ParseInt(c.Params("book_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid book ID")
+	}
+
+	tags := models.ParseTags(c.FormValue("tags"))
+	if err := models.SetUserBookTags(user.ID, bookID, tags); err != nil {
+		return c.Redirect("/my-books?error=Failed+to+update+tags")
+	}
+
+	return c.Redirect("/my-books")
+}
+
 func (h *UserBooksHandler) RemoveBook(c *fiber.Ctx) error {
 	user := c.Locals("user").(*models.User)
 
@@ -321,6 +483,7 @@ func (h *UserBooksHandler) UpdateBookDates(c *fiber.Ctx) error {
 	if err != nil {
 		return c.Redirect("/my-books?error=Failed+to+update+dates")
 	}
+	_ = models.CheckReadingGoals(user.ID)
 
 	return c.Redirect("/my-books")
 }
@@ -337,7 +500,8 @@ func (h *UserBooksHandler) GetShelfBooks(c *fiber.Ctx) error {
 	offset := c.QueryInt("offset", 0)
 	limit := 20 // load 20 more each time
 
-	books, total, err := models.GetShelfBooksPaginated(user.ID, shelf, offset, limit)
+	filter := parseShelfFilter(c)
+	books, total, err := models.GetShelfBooksPaginated(user.ID, shelf, filter, offset, limit)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString("Error loading books")
 	}
@@ -350,6 +514,7 @@ func (h *UserBooksHandler) GetShelfBooks(c *fiber.Ctx) error {
 	return c.Render("partials/shelf_books", fiber.Map{
 		"Books":      books,
 		"Shelf":      shelf,
+		"Filter":     filter,
 		"NextOffset": offset + len(books),
 		"Remaining":  remaining,
 	})
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"image"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/nuuner/spines/internal/cache"
+	"github.com/nuuner/spines/internal/models"
+	"github.com/nuuner/spines/internal/recap"
+)
+
+// recapImageCache stores rendered year in review images for 10 minutes, so
+// shared links don't re-render on every preview fetch
+var recapImageCache = cache.New(10 * time.Minute)
+
+// YearReviewPage shows the books a user finished in a year as a recap
+func YearReviewPage(c *fiber.Ctx) error {
+	year, ok := parseReviewYear(c.Params("year"))
+	if !ok {
+		return c.Status(fiber.StatusNotFound).SendString("Invalid year")
+	}
+
+	user, err := models.GetUserByUsername(c.Params("username"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(fiber.StatusNotFound).SendString("User not found")
+		}
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading user")
+	}
+
+	review, err := models.GetYearReview(user.ID, year)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading year in review")
+	}
+
+	// Other years with finished books, for navigation
+	years, err := models.GetFinishedYears(user.ID)
+	if err != nil {
+		years = []int{}
+	}
+
+	metaDesc := user.DisplayName + " read " + formatBookCount(len(review.Books)) + " in " + strconv.Itoa(year)
+	return c.Render("pages/year_review", NavData(c, fiber.Map{
+		"User":   user,
+		"Review": review,
+		"Years":  years,
+		// SEO metadata
+		"PageTitle":       user.DisplayName + "'s " + strconv.Itoa(year) + " in Books",
+		"MetaDescription": metaDesc,
+		"OGTitle":         user.DisplayName + "'s " + strconv.Itoa(year) + " in Books - Spines",
+		"OGDescription":   metaDesc,
+		"OGImage":         baseURL(c) + "/u/" + user.Username + "/year/" + strconv.Itoa(year) + "/share.png",
+		"OGType":          "profile",
+	}), "layouts/base")
+}
+
+// YearReviewImage renders a shareable PNG summary of a user's year
+func YearReviewImage(c *fiber.Ctx) error {
+	year, ok := parseReviewYear(c.Params("year"))
+	if !ok {
+		return c.Status(fiber.StatusNotFound).SendString("Invalid year")
+	}
+
+	user, err := models.GetUserByUsername(c.Params("username"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(fiber.StatusNotFound).SendString("User not found")
+		}
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading user")
+	}
+
+	cacheKey := "year_review:" + strconv.FormatInt(user.ID, 10) + ":" + strconv.Itoa(year)
+	if cached, found := recapImageCache.Get(cacheKey); found {
+		c.Set("Content-Type", "image/png")
+		c.Set("Cache-Control", "public, max-age=600")
+		return c.Send(cached.([]byte))
+	}
+
+	review, err := models.GetYearReview(user.ID, year)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Error loading year in review")
+	}
+
+	var buf bytes.Buffer
+	if err := recap.Render(&buf, yearReviewCard(user, review)); err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to render image")
+	}
+	recapImageCache.Set(cacheKey, buf.Bytes())
+
+	c.Set("Content-Type", "image/png")
+	c.Set("Cache-Control", "public, max-age=600")
+	return c.Send(buf.Bytes())
+}
+
+// parseReviewYear reads the year from the URL, rejecting years before 1900
+// or in the future
+func parseReviewYear(value string) (int, bool) {
+	year, err := strconv.Atoi(value)
+	if err != nil || year < 1900 || year > time.Now().Year() {
+		return 0, false
+	}
+	return year, true
+}
+
+// yearReviewCard lays out the recap image for a year
+func yearReviewCard(user *models.User, review *models.YearReview) recap.Card {
+	card := recap.Card{
+		Title: user.DisplayName + "'s " + strconv.Itoa(review.Year) + " in books",
+		Stats: []recap.Stat{
+			{Value: strconv.Itoa(len(review.Books)), Label: pluralLabel(len(review.Books), "book")},
+			{Value: strconv.Itoa(review.TotalPages), Label: pluralLabel(review.TotalPages, "page")},
+		},
+	}
+	if month := review.BusiestMonth(); month != "" {
+		card.Stats = append(card.Stats, recap.Stat{Value: month[:3], Label: "busiest month"})
+	}
+
+	if review.HighestRated != nil {
+		card.Highlights = append(card.Highlights, "Highest rated: "+review.HighestRated.Book.Title+" ("+review.HighestRated.RatingDisplay()+")")
+	}
+	if review.Longest != nil {
+		card.Highlights = append(card.Highlights, "Longest: "+review.Longest.Book.Title+" ("+strconv.FormatInt(review.Longest.Book.PageCount.Int64, 10)+" pages)")
+	}
+	if review.First != nil {
+		card.Highlights = append(card.Highlights, "First: "+review.First.Book.Title)
+	}
+	if review.Last != nil && review.Last != review.First {
+		card.Highlights = append(card.Highlights, "Last: "+review.Last.Book.Title)
+	}
+
+	card.Covers = fetchRecapCovers(review.Books)
+	return card
+}
+
+// fetchRecapCovers fetches the covers for the cover wall in parallel. Books
+// without a cover, or whose cover fails to load, get a nil entry.
+func fetchRecapCovers(books []models.UserBook) []image.Image {
+	if len(books) > recap.MaxCovers {
+		books = books[:recap.MaxCovers]
+	}
+
+	covers := make([]image.Image, len(books))
+	var wg sync.WaitGroup
+	for i, ub := range books {
+		if ub.Book == nil || ub.Book.GoogleBooksID == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, googleBooksID string) {
+			defer wg.Done()
+			cover, _, err := fetchBookCover(googleBooksID, "1", "curl")
+			if err != nil {
+				return
+			}
+			if img, _, err := image.Decode(bytes.NewReader(cover.Data)); err == nil {
+				covers[i] = img
+			}
+		}(i, ub.Book.GoogleBooksID)
+	}
+	wg.Wait()
+	return covers
+}
+
+func pluralLabel(count int, unit string) string {
+	if count == 1 {
+		return unit
+	}
+	return unit + "s"
+}
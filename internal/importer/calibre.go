@@ -0,0 +1,199 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+// calibreDateLayouts are the ways Calibre stores timestamps, depending on
+// its version
+var calibreDateLayouts = []string{
+	"2006-01-02 15:04:05.999999-07:00",
+	"2006-01-02 15:04:05-07:00",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+}
+
+// calibreTables are the tables calibreBooksQuery reads. An uploaded database
+// could define any of them as a view, which runs whatever query it likes, so
+// each must be a real table.
+var calibreTables = []string{
+	"books", "authors", "books_authors_link", "ratings", "books_ratings_link",
+	"tags", "books_tags_link", "identifiers",
+}
+
+// sqliteHeader begins every SQLite database file
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// calibreTimeout limits how long reading a library may take, as an uploaded
+// database can be built to make queries slow
+const calibreTimeout = time.Minute
+
+// calibreBooksQuery reads each book with its authors in order, rating, tags
+// and ISBNs. Older libraries keep the ISBN in books.isbn rather than in
+// identifiers.
+const calibreBooksQuery = `
+	SELECT b.title, CAST(b.timestamp AS TEXT), b.path, b.has_cover,
+		COALESCE((SELECT GROUP_CONCAT(name, ', ') FROM (
+			SELECT a.name FROM books_authors_link l JOIN authors a ON a.id = l.author WHERE l.book = b.id ORDER BY l.id
+		)), ''),
+		COALESCE((SELECT r.rating FROM books_ratings_link l JOIN ratings r ON r.id = l.rating WHERE l.book = b.id), 0),
+		COALESCE((SELECT GROUP_CONCAT(t.name) FROM books_tags_link l JOIN tags t ON t.id = l.tag WHERE l.book = b.id), ''),
+		COALESCE((SELECT GROUP_CONCAT(i.val) FROM identifiers i WHERE i.book = b.id AND i.type = 'isbn'), ''),
+		COALESCE(b.isbn, '')
+	FROM books b
+	ORDER BY b.id
+`
+
+// ParseCalibre reads the books of a Calibre library's metadata.db, opened
+// read-only so a library Calibre is using isn't disturbed. Calibre doesn't
+// track reading, so every book goes on the given shelf as an owned ebook.
+// With covers set, entries note where their cover is inside the library
+// directory, for showing next to the matches while reviewing; books keep
+// the cover of the edition they're matched to, as every book's cover comes
+// from Google Books. An uploaded metadata.db has no covers next to it.
+func ParseCalibre(dbPath, shelf string, covers bool) ([]models.ImportEntry, error) {
+	// The driver fails with its own error on opening a file that isn't an
+	// SQLite database at all
+	isSQLite, err := hasSQLiteHeader(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if !isSQLite {
+		return nil, ErrUnrecognisedFile
+	}
+
+	dsn := url.URL{Scheme: "file", Opaque: (&url.URL{Path: dbPath}).EscapedPath(), RawQuery: "mode=ro"}
+	db, err := sql.Open("sqlite3", dsn.String())
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), calibreTimeout)
+	defer cancel()
+
+	// The pragma applies to one connection, so everything runs on the same one
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Functions named in the file's schema, such as in views, triggers and
+	// generated columns, are not to be trusted
+	if _, err := conn.ExecContext(ctx, "PRAGMA trusted_schema = OFF"); err != nil {
+		return nil, err
+	}
+
+	var tables int
+	err = conn.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' AND name IN (`+strings.TrimSuffix(strings.Repeat("?, ", len(calibreTables)), ", ")+`)
+	`, calibreTableArgs()...).Scan(&tables)
+	if err != nil {
+		return nil, ErrUnrecognisedFile
+	}
+	if tables < len(calibreTables) {
+		return nil, ErrUnrecognisedFile
+	}
+
+	rows, err := conn.QueryContext(ctx, calibreBooksQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.ImportEntry
+	for rows.Next() {
+		var title, bookPath, authors, tags, isbns, legacyISBN string
+		var timestamp sql.NullString
+		var hasCover bool
+		var rating int64
+		if err := rows.Scan(&title, &timestamp, &bookPath, &hasCover, &authors, &rating, &tags, &isbns, &legacyISBN); err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(title) == "" {
+			continue
+		}
+		if len(entries) >= MaxRows {
+			return nil, ErrTooManyRows
+		}
+
+		entry := models.ImportEntry{
+			// Numbered by position, as the report refers to lines
+			Line:    len(entries) + 1,
+			Title:   strings.TrimSpace(title),
+			Authors: authors,
+			Shelf:   shelf,
+			// Calibre rates out of 10 like rating points, 0 meaning unrated
+			Rating:    rating,
+			DateAdded: calibreDate(timestamp.String),
+			Format:    "ebook",
+			Owned:     true,
+			Tags:      splitTags(tags),
+		}
+		for _, isbn := range strings.FieldsFunc(isbns+","+legacyISBN, isListSeparator) {
+			isbn13, isbn10 := cleanISBN(isbn)
+			if entry.ISBN13 == "" {
+				entry.ISBN13 = isbn13
+			}
+			if entry.ISBN10 == "" {
+				entry.ISBN10 = isbn10
+			}
+		}
+		if covers && hasCover {
+			// Calibre always uses forward slashes in book paths
+			entry.Cover = path.Join(bookPath, "cover.jpg")
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// hasSQLiteHeader reports whether a file starts like an SQLite database
+func hasSQLiteHeader(dbPath string) (bool, error) {
+	f, err := os.Open(dbPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	header := make([]byte, len(sqliteHeader))
+	if _, err := io.ReadFull(f, header); err != nil {
+		// Too short to be a database
+		return false, nil
+	}
+	return bytes.Equal(header, sqliteHeader), nil
+}
+
+func calibreTableArgs() []interface{} {
+	args := make([]interface{}, len(calibreTables))
+	for i, name := range calibreTables {
+		args[i] = name
+	}
+	return args
+}
+
+// calibreDate converts a Calibre timestamp to local time in user_books' format
+func calibreDate(s string) string {
+	s = strings.TrimSpace(s)
+	for _, layout := range calibreDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			// Calibre uses 0101-01-01 for unknown dates
+			if t.Year() < 1000 {
+				return ""
+			}
+			return t.Local().Format(dateFormat)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,174 @@
+package importer
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+// calibreSchema is the part of a Calibre library's schema ParseCalibre reads
+const calibreSchema = `
+	CREATE TABLE books (id INTEGER PRIMARY KEY, title TEXT, timestamp TIMESTAMP, path TEXT, has_cover BOOL, isbn TEXT);
+	CREATE TABLE authors (id INTEGER PRIMARY KEY, name TEXT);
+	CREATE TABLE books_authors_link (id INTEGER PRIMARY KEY, book INTEGER, author INTEGER);
+	CREATE TABLE ratings (id INTEGER PRIMARY KEY, rating INTEGER);
+	CREATE TABLE books_ratings_link (id INTEGER PRIMARY KEY, book INTEGER, rating INTEGER);
+	CREATE TABLE tags (id INTEGER PRIMARY KEY, name TEXT);
+	CREATE TABLE books_tags_link (id INTEGER PRIMARY KEY, book INTEGER, tag INTEGER);
+	CREATE TABLE identifiers (id INTEGER PRIMARY KEY, book INTEGER, type TEXT, val TEXT);
+`
+
+// writeCalibreLibrary creates a metadata.db from the given statements
+func writeCalibreLibrary(t *testing.T, statements string) string {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "metadata.db")
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(statements); err != nil {
+		t.Fatal(err)
+	}
+	return dbPath
+}
+
+func TestParseCalibre(t *testing.T) {
+	dbPath := writeCalibreLibrary(t, calibreSchema+`
+		INSERT INTO books VALUES
+			(1, 'Good Omens', '2023-01-04 10:30:00+00:00', 'Terry Pratchett/Good Omens (1)', 1, ''),
+			(2, 'Dune', '2023-02-10 08:00:00.123456+00:00', 'Frank Herbert/Dune (2)', 0, '0441013597'),
+			(3, '  ', '2023-02-10 08:00:00+00:00', 'Untitled/Untitled (3)', 0, ''),
+			(4, 'Emma', '0101-01-01 00:00:00+00:00', 'Jane Austen/Emma (4)', 1, NULL);
+		INSERT INTO authors VALUES (1, 'Neil Gaiman'), (2, 'Terry Pratchett'), (3, 'Frank Herbert'), (4, 'Jane Austen');
+		INSERT INTO books_authors_link VALUES (1, 1, 2), (2, 1, 1), (3, 2, 3), (4, 4, 4);
+		INSERT INTO ratings VALUES (1, 8), (2, 10);
+		INSERT INTO books_ratings_link VALUES (1, 1, 2), (2, 2, 1);
+		INSERT INTO tags VALUES (1, 'Fantasy'), (2, 'Humour');
+		INSERT INTO books_tags_link VALUES (1, 1, 1), (2, 1, 2);
+		INSERT INTO identifiers VALUES (1, 1, 'isbn', '978-0-06-085398-3'), (2, 1, 'goodreads', '12067'), (3, 2, 'amazon', 'B00B7NPRY8');
+	`)
+
+	tests := []struct {
+		name   string
+		shelf  string
+		covers bool
+		want   []models.ImportEntry
+	}{
+		{
+			name:   "with covers",
+			shelf:  "want_to_read",
+			covers: true,
+			want: []models.ImportEntry{
+				{
+					Line: 1, Title: "Good Omens", Authors: "Terry Pratchett, Neil Gaiman", ISBN13: "9780060853983",
+					Shelf: "want_to_read", Rating: 10, DateAdded: localDate(2023, 1, 4, 10, 30, 0),
+					Format: "ebook", Owned: true, Tags: []string{"fantasy", "humour"},
+					Cover: "Terry Pratchett/Good Omens (1)/cover.jpg",
+				},
+				{
+					Line: 2, Title: "Dune", Authors: "Frank Herbert", ISBN10: "0441013597",
+					Shelf: "want_to_read", Rating: 8, DateAdded: localDate(2023, 2, 10, 8, 0, 0),
+					Format: "ebook", Owned: true,
+				},
+				{
+					Line: 3, Title: "Emma", Authors: "Jane Austen", Shelf: "want_to_read", Format: "ebook", Owned: true,
+					Cover: "Jane Austen/Emma (4)/cover.jpg",
+				},
+			},
+		},
+		{
+			name:  "without covers",
+			shelf: "read",
+			want: []models.ImportEntry{
+				{
+					Line: 1, Title: "Good Omens", Authors: "Terry Pratchett, Neil Gaiman", ISBN13: "9780060853983",
+					Shelf: "read", Rating: 10, DateAdded: localDate(2023, 1, 4, 10, 30, 0),
+					Format: "ebook", Owned: true, Tags: []string{"fantasy", "humour"},
+				},
+				{
+					Line: 2, Title: "Dune", Authors: "Frank Herbert", ISBN10: "0441013597",
+					Shelf: "read", Rating: 8, DateAdded: localDate(2023, 2, 10, 8, 0, 0),
+					Format: "ebook", Owned: true,
+				},
+				{Line: 3, Title: "Emma", Authors: "Jane Austen", Shelf: "read", Format: "ebook", Owned: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := ParseCalibre(dbPath, tt.shelf, tt.covers)
+			if err != nil {
+				t.Fatalf("ParseCalibre: %v", err)
+			}
+			if !reflect.DeepEqual(entries, tt.want) {
+				t.Errorf("ParseCalibre\n got: %+v\nwant: %+v", entries, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCalibreRejectsOtherFiles(t *testing.T) {
+	notSQLite := filepath.Join(t.TempDir(), "metadata.db")
+	if err := os.WriteFile(notSQLite, []byte("Title,Author\nDune,Frank Herbert\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		dbPath string
+	}{
+		{name: "not an SQLite database", dbPath: notSQLite},
+		{name: "missing tables", dbPath: writeCalibreLibrary(t, "CREATE TABLE books (id INTEGER PRIMARY KEY, title TEXT);")},
+		{
+			name: "table replaced by a view",
+			dbPath: writeCalibreLibrary(t, calibreSchema+`
+				DROP TABLE ratings;
+				CREATE VIEW ratings AS SELECT 1 AS id, 10 AS rating;
+			`),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseCalibre(tt.dbPath, "read", false); err != ErrUnrecognisedFile {
+				t.Errorf("got %v, want ErrUnrecognisedFile", err)
+			}
+		})
+	}
+}
+
+func TestCalibreDate(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "with microseconds", in: "2023-01-04 10:30:00.123456+00:00", want: localDate(2023, 1, 4, 10, 30, 0)},
+		{name: "with an offset", in: "2023-01-04 12:30:00+02:00", want: localDate(2023, 1, 4, 10, 30, 0)},
+		{name: "RFC 3339", in: "2023-01-04T10:30:00Z", want: localDate(2023, 1, 4, 10, 30, 0)},
+		{name: "without a zone", in: "2023-01-04 10:30:00", want: localDate(2023, 1, 4, 10, 30, 0)},
+		{name: "unknown date", in: "0101-01-01 00:00:00+00:00", want: ""},
+		{name: "empty", in: "", want: ""},
+		{name: "unreadable", in: "yesterday", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := calibreDate(tt.in); got != tt.want {
+				t.Errorf("calibreDate(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// localDate formats a UTC time the way calibreDate returns it
+func localDate(year int, month time.Month, day, hour, min, sec int) string {
+	return time.Date(year, month, day, hour, min, sec, 0, time.UTC).Local().Format(dateFormat)
+}
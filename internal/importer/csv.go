@@ -0,0 +1,161 @@
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+// dateFormat is how user_books stores dates
+const dateFormat = "2006-01-02 15:04:05"
+
+// MaxRows is the most entries a single import accepts
+const MaxRows = 10000
+
+// ErrTooManyRows is returned for files with more than MaxRows entries
+var ErrTooManyRows = errors.New("file has too many rows")
+
+// record is a CSV row keyed by column name
+type record struct {
+	line   int
+	fields map[string]string
+}
+
+// get returns a column's value with surrounding space trimmed
+func (r record) get(column string) string {
+	return strings.TrimSpace(r.fields[column])
+}
+
+// readCSV reads a CSV file with a header row. Rows may have fewer or more
+// fields than the header; missing columns read as empty.
+func readCSV(r io.Reader) ([]string, []record, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, column := range header {
+		if i == 0 {
+			column = strings.TrimPrefix(column, "\ufeff")
+		}
+		header[i] = strings.TrimSpace(column)
+	}
+
+	var records []record
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(records) >= MaxRows {
+			return nil, nil, ErrTooManyRows
+		}
+		line, _ := reader.FieldPos(0)
+		rec := record{line: line, fields: make(map[string]string, len(header))}
+		for i, column := range header {
+			if i < len(fields) {
+				rec.fields[column] = fields[i]
+			}
+		}
+		records = append(records, rec)
+	}
+	return header, records, nil
+}
+
+// hasColumns reports whether a header includes all of the given columns
+func hasColumns(header []string, columns ...string) bool {
+	present := make(map[string]bool, len(header))
+	for _, column := range header {
+		present[column] = true
+	}
+	for _, column := range columns {
+		if !present[column] {
+			return false
+		}
+	}
+	return true
+}
+
+// cleanISBN strips spreadsheet wrapping such as ="9780441013593", brackets,
+// hyphens and spaces, returning the ISBN as ISBN-13 or ISBN-10. Anything that
+// isn't an ISBN comes back empty.
+func cleanISBN(s string) (isbn13, isbn10 string) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "=")
+	s = strings.Trim(s, `"[]`)
+	s = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(s))
+
+	for i, r := range s {
+		isCheckDigit := r == 'X' && i == len(s)-1 && len(s) == 10
+		if (r < '0' || r > '9') && !isCheckDigit {
+			return "", ""
+		}
+	}
+	switch len(s) {
+	case 13:
+		return s, ""
+	case 10:
+		return "", s
+	}
+	return "", ""
+}
+
+// parseDate reads a date in any of the given layouts, returning it in
+// dateFormat, or "" if it is empty or unreadable
+func parseDate(s string, layouts ...string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ""
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t.Format(dateFormat)
+		}
+	}
+	return ""
+}
+
+// formatFromBinding maps a binding or format description to one of
+// models.BookFormats, or "" if it isn't recognised
+func formatFromBinding(binding string) string {
+	b := strings.ToLower(binding)
+	switch {
+	case strings.Contains(b, "audio"):
+		return "audiobook"
+	case strings.Contains(b, "kindle"), strings.Contains(b, "ebook"), strings.Contains(b, "e-book"),
+		strings.Contains(b, "digital"), strings.Contains(b, "nook"):
+		return "ebook"
+	case strings.Contains(b, "hardcover"), strings.Contains(b, "hardback"):
+		return "hardcover"
+	case strings.Contains(b, "paperback"), strings.Contains(b, "softcover"):
+		return "paperback"
+	case strings.Contains(b, "library"):
+		return "library_loan"
+	}
+	return ""
+}
+
+// isListSeparator reports whether r separates the items of a list column
+func isListSeparator(r rune) bool {
+	return r == ',' || r == ';'
+}
+
+// splitTags turns a comma or semicolon separated column into tags
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.FieldsFunc(s, isListSeparator) {
+		if tag = models.NormalizeTag(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,68 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCleanISBN(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		want13 string
+		want10 string
+	}{
+		{name: "ISBN-13", in: "9780441013593", want13: "9780441013593"},
+		{name: "ISBN-10", in: "0441013597", want10: "0441013597"},
+		{name: "spreadsheet formula wrapping", in: `="9780441013593"`, want13: "9780441013593"},
+		{name: "empty formula wrapping", in: `=""`},
+		{name: "brackets", in: "[0441013597]", want10: "0441013597"},
+		{name: "hyphens and spaces", in: " 978-0 441-01359-3 ", want13: "9780441013593"},
+		{name: "lowercase check digit", in: "080442957x", want10: "080442957X"},
+		{name: "check digit only allowed last", in: "08044295X7"},
+		{name: "check digit not allowed in ISBN-13", in: "978044101359X"},
+		{name: "wrong length", in: "97804410135"},
+		{name: "not an ISBN", in: "B00ABCDEFG"},
+		{name: "empty", in: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got13, got10 := cleanISBN(tt.in)
+			if got13 != tt.want13 || got10 != tt.want10 {
+				t.Errorf("cleanISBN(%q) = %q, %q, want %q, %q", tt.in, got13, got10, tt.want13, tt.want10)
+			}
+		})
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        string
+		wantTitle string
+	}{
+		{name: "plain", in: "Title\nDune\n", wantTitle: "Dune"},
+		{name: "byte order mark", in: "\ufeffTitle\nDune\n", wantTitle: "Dune"},
+		{name: "CRLF line endings", in: "Title\r\nDune\r\n", wantTitle: "Dune"},
+		{name: "surrounding spaces", in: "Title\n  Dune  \n", wantTitle: "Dune"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, records, err := readCSV(strings.NewReader(tt.in))
+			if err != nil {
+				t.Fatalf("readCSV: %v", err)
+			}
+			if len(records) != 1 {
+				t.Fatalf("got %d records, want 1", len(records))
+			}
+			if got := records[0].get("Title"); got != tt.wantTitle {
+				t.Errorf("Title = %q, want %q", got, tt.wantTitle)
+			}
+			if records[0].line != 2 {
+				t.Errorf("line = %d, want 2", records[0].line)
+			}
+		})
+	}
+}
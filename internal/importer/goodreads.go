@@ -0,0 +1,115 @@
+package importer
+
+import (
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+// goodreadsDateLayouts are the date formats seen in Goodreads exports
+var goodreadsDateLayouts = []string{"2006/01/02", "2006-01-02", "01/02/2006"}
+
+// goodreadsDefaultShelves are Goodreads' built-in exclusive shelves, which
+// aren't carried over as tags
+var goodreadsDefaultShelves = map[string]string{
+	"read":              "read",
+	"currently-reading": "currently_reading",
+	"to-read":           "want_to_read",
+}
+
+// ParseGoodreads reads a Goodreads library export ("goodreads_library_export.csv")
+func ParseGoodreads(r io.Reader) ([]models.ImportEntry, error) {
+	header, records, err := readCSV(r)
+	if err != nil {
+		return nil, err
+	}
+	if !hasColumns(header, "Title", "Author", "Exclusive Shelf") {
+		return nil, ErrUnrecognisedFile
+	}
+
+	entries := make([]models.ImportEntry, 0, len(records))
+	for _, rec := range records {
+		title := goodreadsText(rec.get("Title"))
+		if title == "" {
+			continue
+		}
+
+		authors := goodreadsText(rec.get("Author"))
+		if additional := goodreadsText(rec.get("Additional Authors")); additional != "" {
+			authors += ", " + additional
+		}
+
+		isbn13, _ := cleanISBN(rec.get("ISBN13"))
+		_, isbn10 := cleanISBN(rec.get("ISBN"))
+		pages, _ := strconv.Atoi(rec.get("Number of Pages"))
+
+		exclusive := rec.get("Exclusive Shelf")
+		entry := models.ImportEntry{
+			Line:         rec.line,
+			Title:        title,
+			Authors:      authors,
+			ISBN13:       isbn13,
+			ISBN10:       isbn10,
+			PageCount:    pages,
+			Shelf:        goodreadsShelf(exclusive),
+			DateAdded:    parseDate(rec.get("Date Added"), goodreadsDateLayouts...),
+			DateFinished: parseDate(rec.get("Date Read"), goodreadsDateLayouts...),
+			Format:       formatFromBinding(rec.get("Binding")),
+			Tags:         goodreadsTags(goodreadsText(rec.get("Bookshelves")), exclusive),
+		}
+
+		// Goodreads rates in whole stars, 0 meaning unrated
+		if stars, err := strconv.Atoi(rec.get("My Rating")); err == nil && stars > 0 {
+			entry.Rating = int64(stars * 2)
+		}
+		if copies, err := strconv.Atoi(rec.get("Owned Copies")); err == nil && copies > 0 {
+			entry.Owned = true
+		}
+
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// goodreadsShelf maps a Goodreads exclusive shelf to one of ours. Custom
+// exclusive shelves are recognised by common names for stopped books and
+// otherwise treated as want to read.
+func goodreadsShelf(exclusive string) string {
+	if shelf, ok := goodreadsDefaultShelves[exclusive]; ok {
+		return shelf
+	}
+	name := strings.ToLower(exclusive)
+	switch {
+	case strings.Contains(name, "dnf"), strings.Contains(name, "did-not-finish"), strings.Contains(name, "abandon"):
+		return "did_not_finish"
+	case strings.Contains(name, "hold"), strings.Contains(name, "paused"):
+		return "on_hold"
+	}
+	return "want_to_read"
+}
+
+// goodreadsTags turns the non-exclusive shelves a book is on into tags
+func goodreadsTags(shelves, exclusive string) []string {
+	var tags []string
+	for _, shelf := range strings.Split(shelves, ",") {
+		shelf = strings.TrimSpace(shelf)
+		if shelf == "" || shelf == exclusive || goodreadsDefaultShelves[shelf] != "" {
+			continue
+		}
+		if tag := models.NormalizeTag(shelf); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// goodreadsText undoes the quote our export puts before text that would
+// otherwise be read as a spreadsheet formula
+func goodreadsText(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
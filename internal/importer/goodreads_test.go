@@ -0,0 +1,104 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+const goodreadsHeader = "Book Id,Title,Author,Additional Authors,ISBN,ISBN13,My Rating,Binding,Number of Pages,Date Read,Date Added,Bookshelves,Exclusive Shelf,Owned Copies\n"
+
+func TestParseGoodreads(t *testing.T) {
+	tests := []struct {
+		name string
+		row  string
+		want models.ImportEntry
+	}{
+		{
+			name: "read book",
+			row:  `1,Dune,Frank Herbert,,"=""0441013597""","=""9780441013593""",4,Paperback,688,2023/02/10,2023/01/04,"sci-fi, favourites",read,1`,
+			want: models.ImportEntry{
+				Title: "Dune", Authors: "Frank Herbert", ISBN13: "9780441013593", ISBN10: "0441013597",
+				PageCount: 688, Shelf: "read", Rating: 8,
+				DateAdded: "2023-01-04 00:00:00", DateFinished: "2023-02-10 00:00:00",
+				Format: "paperback", Owned: true, Tags: []string{"sci-fi", "favourites"},
+			},
+		},
+		{
+			name: "empty ISBNs and no rating",
+			row:  `2,Dune Messiah,Frank Herbert,,"=""""","=""""",0,Kindle Edition,,,2023-03-01,to-read,to-read,0`,
+			want: models.ImportEntry{
+				Title: "Dune Messiah", Authors: "Frank Herbert", Shelf: "want_to_read",
+				DateAdded: "2023-03-01 00:00:00", Format: "ebook",
+			},
+		},
+		{
+			name: "additional authors",
+			row:  `3,Good Omens,Terry Pratchett,Neil Gaiman,,,5,Hardcover,,,03/31/2025,currently-reading,currently-reading,`,
+			want: models.ImportEntry{
+				Title: "Good Omens", Authors: "Terry Pratchett, Neil Gaiman", Shelf: "currently_reading", Rating: 10,
+				DateAdded: "2025-03-31 00:00:00", Format: "hardcover",
+			},
+		},
+		{
+			name: "custom did not finish shelf",
+			row:  `4,Ulysses,James Joyce,,,,,,,,,DNF,DNF,`,
+			want: models.ImportEntry{Title: "Ulysses", Authors: "James Joyce", Shelf: "did_not_finish"},
+		},
+		{
+			name: "custom on hold shelf is not a tag",
+			row:  `5,Middlemarch,George Eliot,,,,,,,,,"on-hold, classics",on-hold,`,
+			want: models.ImportEntry{Title: "Middlemarch", Authors: "George Eliot", Shelf: "on_hold", Tags: []string{"classics"}},
+		},
+		{
+			name: "unknown custom shelf",
+			row:  `6,Emma,Jane Austen,,,,,,,,,someday,someday,`,
+			want: models.ImportEntry{Title: "Emma", Authors: "Jane Austen", Shelf: "want_to_read"},
+		},
+		{
+			name: "formula guard from our own export",
+			row:  `7,'=Equals,'-Author,,,,,,,,,'+plus,to-read,`,
+			want: models.ImportEntry{Title: "=Equals", Authors: "-Author", Shelf: "want_to_read", Tags: []string{"+plus"}},
+		},
+		{
+			name: "quote not guarding a formula is kept",
+			row:  `8,'Salem's Lot,Stephen King,,,,,,,,,,to-read,`,
+			want: models.ImportEntry{Title: "'Salem's Lot", Authors: "Stephen King", Shelf: "want_to_read"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := ParseGoodreads(strings.NewReader(goodreadsHeader + tt.row + "\n"))
+			if err != nil {
+				t.Fatalf("ParseGoodreads: %v", err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(entries))
+			}
+			tt.want.Line = 2
+			if !reflect.DeepEqual(entries[0], tt.want) {
+				t.Errorf("ParseGoodreads\n got: %+v\nwant: %+v", entries[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGoodreadsSkipsUntitledRows(t *testing.T) {
+	entries, err := ParseGoodreads(strings.NewReader(goodreadsHeader + ",,,,,,,,,,,,to-read,\n1,Dune,Frank Herbert,,,,,,,,,,read,\n"))
+	if err != nil {
+		t.Fatalf("ParseGoodreads: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Title != "Dune" || entries[0].Line != 3 {
+		t.Errorf("got %+v, want only Dune from line 3", entries)
+	}
+}
+
+func TestParseGoodreadsRejectsOtherFiles(t *testing.T) {
+	_, err := ParseGoodreads(strings.NewReader("Title,Authors,Read Status\nDune,Frank Herbert,read\n"))
+	if err != ErrUnrecognisedFile {
+		t.Errorf("got %v, want ErrUnrecognisedFile", err)
+	}
+}
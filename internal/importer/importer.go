@@ -0,0 +1,37 @@
+// Package importer brings books from other reading trackers onto a user's
+// shelves. Each supported format has a parser turning its export into
+// models.ImportEntry values; the entries are stored as an import job and
+// matched to books in the background.
+package importer
+
+import (
+	"errors"
+	"io"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+var (
+	// ErrUnknownSource is returned for formats without a parser
+	ErrUnknownSource = errors.New("unknown import format")
+	// ErrUnrecognisedFile is returned for files missing the columns of the
+	// format they were uploaded as
+	ErrUnrecognisedFile = errors.New("file is not an export in this format")
+)
+
+// parsers maps the export formats of models.ImportSources to their parsers.
+// Generic CSV files and Calibre libraries need more than a file to read, see
+// ParseMapped and ParseCalibre.
+var parsers = map[string]func(io.Reader) ([]models.ImportEntry, error){
+	"goodreads":  ParseGoodreads,
+	"storygraph": ParseStoryGraph,
+}
+
+// Parse reads an export file in the given format
+func Parse(source string, r io.Reader) ([]models.ImportEntry, error) {
+	parse, ok := parsers[source]
+	if !ok {
+		return nil, ErrUnknownSource
+	}
+	return parse(r)
+}
@@ -0,0 +1,342 @@
+package importer
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+// clippingSeparator ends each clipping in "My Clippings.txt"
+const clippingSeparator = "=========="
+
+var (
+	clippingPage     = regexp.MustCompile(`(?i)\bpage (\d+)`)
+	clippingLocation = regexp.MustCompile(`(?i)\blocation (\d+)(?:-(\d+))?`)
+)
+
+// clippingDateLayouts are the "Added on" formats of US and UK English Kindles
+var clippingDateLayouts = []string{
+	"Monday, January 2, 2006 3:04:05 PM",
+	"Monday, 2 January 2006 15:04:05",
+}
+
+// clipping is one entry of a clippings file
+type clipping struct {
+	kind          string // "highlight", "note" or "bookmark"
+	page          int64
+	start, end    int64 // location range, 0 if unknown
+	location      string
+	addedAt       string
+	text          string
+	title, author string
+	// For highlights, the notes made on them
+	note string
+}
+
+// ParseClippings reads a Kindle "My Clippings.txt" file into each book's
+// highlights, in the order the books first appear. Notes are attached to the
+// highlight they were made on; bookmarks are left out, as are notes without a
+// highlight, which are counted. Only English Kindles are understood.
+func ParseClippings(r io.Reader) ([]models.ClippingBook, int, error) {
+	entries, err := readClippings(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Books by title and author, with their highlights
+	var books []models.ClippingBook
+	var highlights [][]clipping
+	index := make(map[string]int)
+	for _, e := range entries {
+		key := e.title + "\x00" + e.author
+		i, ok := index[key]
+		if !ok {
+			i = len(books)
+			index[key] = i
+			books = append(books, models.ClippingBook{Title: e.title, Authors: e.author})
+			highlights = append(highlights, nil)
+		}
+		if e.kind == "highlight" {
+			highlights[i] = append(highlights[i], e)
+		}
+	}
+
+	for i := range highlights {
+		highlights[i] = dedupeHighlights(highlights[i])
+	}
+	skipped := 0
+	for _, e := range entries {
+		if e.kind == "note" && !attachNote(highlights[index[e.title+"\x00"+e.author]], e) {
+			skipped++
+		}
+	}
+
+	var result []models.ClippingBook
+	for i, book := range books {
+		for _, h := range highlights[i] {
+			book.Clippings = append(book.Clippings, models.Clipping{
+				Text:     h.text,
+				Note:     h.note,
+				Page:     h.page,
+				Location: h.location,
+				AddedAt:  h.addedAt,
+			})
+		}
+		if len(book.Clippings) > 0 {
+			result = append(result, book)
+		}
+	}
+	return result, skipped, nil
+}
+
+// readClippings splits a clippings file into its entries
+func readClippings(r io.Reader) ([]clipping, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var entries []clipping
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(entries) == 0 && len(lines) == 0 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if strings.TrimSpace(line) != clippingSeparator {
+			lines = append(lines, line)
+			continue
+		}
+		if e, ok := parseClipping(lines); ok {
+			if len(entries) >= MaxRows {
+				return nil, ErrTooManyRows
+			}
+			entries = append(entries, e)
+		}
+		lines = nil
+	}
+	return entries, scanner.Err()
+}
+
+// parseClipping reads an entry: a "Title (Author)" line, a line describing
+// the clipping, a blank line and the clipped text
+func parseClipping(lines []string) (clipping, bool) {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	if len(lines) < 2 {
+		return clipping{}, false
+	}
+
+	var e clipping
+	e.title, e.author = splitClippingTitle(strings.TrimSpace(lines[0]))
+	meta := strings.ToLower(lines[1])
+	switch {
+	case strings.Contains(meta, "bookmark"):
+		e.kind = "bookmark"
+	case strings.Contains(meta, "note"):
+		e.kind = "note"
+	case strings.Contains(meta, "highlight"), strings.Contains(meta, "clip"):
+		e.kind = "highlight"
+	default:
+		return clipping{}, false
+	}
+
+	if m := clippingPage.FindStringSubmatch(lines[1]); m != nil {
+		e.page, _ = strconv.ParseInt(m[1], 10, 64)
+	}
+	if m := clippingLocation.FindStringSubmatch(lines[1]); m != nil {
+		e.location = m[1]
+		e.start, _ = strconv.ParseInt(m[1], 10, 64)
+		e.end = e.start
+		if m[2] != "" {
+			e.location += "-" + m[2]
+			e.end, _ = strconv.ParseInt(m[2], 10, 64)
+		}
+	}
+	if _, added, found := strings.Cut(lines[1], "Added on "); found {
+		e.addedAt = parseDate(added, clippingDateLayouts...)
+	}
+
+	e.text = strings.TrimSpace(strings.Join(lines[2:], "\n"))
+	if e.title == "" || (e.text == "" && e.kind != "bookmark") {
+		return clipping{}, false
+	}
+	return e, true
+}
+
+// splitClippingTitle splits "Leviathan Wakes (The Expanse Book 1) (Corey,
+// James S. A.)" into its title and "James S. A. Corey". The author is the
+// last parenthesised part; books without one have none.
+func splitClippingTitle(line string) (title, author string) {
+	if !strings.HasSuffix(line, ")") {
+		return line, ""
+	}
+	i := strings.LastIndex(line, "(")
+	if i <= 0 {
+		return line, ""
+	}
+	title = strings.TrimSpace(line[:i])
+	var authors []string
+	for _, name := range strings.Split(line[i+1:len(line)-1], ";") {
+		name = strings.TrimSpace(name)
+		// Kindles list authors as "Last, First"
+		if last, first, found := strings.Cut(name, ","); found && !strings.Contains(first, ",") {
+			name = strings.TrimSpace(first) + " " + strings.TrimSpace(last)
+		}
+		if name != "" {
+			authors = append(authors, name)
+		}
+	}
+	return title, strings.Join(authors, ", ")
+}
+
+// attachNote adds a note to the highlight it was made on: the one whose
+// location range ends at or contains the note's location. It reports whether
+// one was found.
+func attachNote(highlights []clipping, note clipping) bool {
+	if note.start == 0 {
+		return false
+	}
+	for i := len(highlights) - 1; i >= 0; i-- {
+		h := &highlights[i]
+		if note.start >= h.start && note.start <= h.end {
+			if h.note != "" {
+				h.note += "\n\n"
+			}
+			h.note += note.text
+			return true
+		}
+	}
+	return false
+}
+
+// dedupeNeighbours is how many highlights either side in location order a
+// highlight is compared with when looking for a longer version of it
+const dedupeNeighbours = 8
+
+// dedupeHighlights drops highlights repeated in the file. Extending a
+// highlight on a Kindle adds a new clipping without removing the old one, so
+// a highlight contained in another at an overlapping location is dropped
+// too. Extended highlights sit next to the original in location order, so
+// only the nearest few are compared, keeping large files quick.
+func dedupeHighlights(highlights []clipping) []clipping {
+	dropped := make([]bool, len(highlights))
+
+	// Of identical highlights the first is kept
+	seen := make(map[string]bool, len(highlights))
+	var order []int
+	for i, h := range highlights {
+		if seen[h.text] {
+			dropped[i] = true
+			continue
+		}
+		seen[h.text] = true
+		order = append(order, i)
+	}
+
+	// Highlights without a location sort first, next to each other
+	sort.SliceStable(order, func(a, b int) bool {
+		ha, hb := highlights[order[a]], highlights[order[b]]
+		if ha.start != hb.start {
+			return ha.start < hb.start
+		}
+		return ha.end < hb.end
+	})
+	for n, i := range order {
+		h := highlights[i]
+		for m := max(0, n-dedupeNeighbours); m < min(len(order), n+dedupeNeighbours+1); m++ {
+			other := highlights[order[m]]
+			overlaps := h.start == 0 || other.start == 0 || (h.start <= other.end && other.start <= h.end)
+			if m != n && overlaps && strings.Contains(other.text, h.text) {
+				dropped[i] = true
+				break
+			}
+		}
+	}
+
+	var kept []clipping
+	for i, h := range highlights {
+		if !dropped[i] {
+			kept = append(kept, h)
+		}
+	}
+	return kept
+}
+
+// MatchClippingBook finds the book on the user's shelves that a clippings
+// file's book most likely is. Kindle titles often carry a series or edition
+// in parentheses and a subtitle, so titles are compared loosely: equal main
+// titles match, otherwise most of the words must be shared. The author's
+// surname must match when both sides have one.
+func MatchClippingBook(book models.ClippingBook, shelved []models.UserBook) *models.UserBook {
+	title := clippingTitle(book.Title)
+	author := firstAuthor(book.Authors)
+
+	var best *models.UserBook
+	bestScore := 0.0
+	for i := range shelved {
+		ub := &shelved[i]
+		if ub.Book == nil || (ub.Book.Authors != "" && !authorsMatch(ub.Book.Authors, author)) {
+			continue
+		}
+		score := titleSimilarity(title, clippingTitle(ub.Book.Title))
+		if score > bestScore {
+			best, bestScore = ub, score
+		}
+	}
+	if bestScore < 0.75 {
+		return nil
+	}
+	return best
+}
+
+// clippingTitle drops parenthesised and bracketed parts such as "(The
+// Expanse Book 1)" or "[Kindle Edition]" and normalises the rest
+func clippingTitle(title string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range title {
+		switch {
+		case r == '(' || r == '[':
+			depth++
+		case (r == ')' || r == ']') && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+	return normalizeTitle(b.String())
+}
+
+// titleSimilarity scores normalised titles from 0 to 1: 1 when their main
+// titles are the same, otherwise the share of words they have in common
+func titleSimilarity(a, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	if titlesMatch(a, b) {
+		return 1
+	}
+	wordsA := strings.Fields(strings.ReplaceAll(a, ":", " "))
+	wordsB := strings.Fields(strings.ReplaceAll(b, ":", " "))
+	inB := make(map[string]bool, len(wordsB))
+	for _, w := range wordsB {
+		inB[w] = true
+	}
+	union := make(map[string]bool, len(wordsA)+len(wordsB))
+	shared := 0
+	for _, w := range wordsA {
+		if inB[w] && !union[w] {
+			shared++
+		}
+		union[w] = true
+	}
+	for _, w := range wordsB {
+		union[w] = true
+	}
+	return float64(shared) / float64(len(union))
+}
@@ -0,0 +1,167 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+// clippingsFile joins clippings the way a Kindle writes them
+func clippingsFile(clippings ...string) string {
+	var b strings.Builder
+	for _, c := range clippings {
+		b.WriteString(c + "\n" + clippingSeparator + "\n")
+	}
+	return b.String()
+}
+
+const (
+	usHighlight = "Leviathan Wakes (The Expanse Book 1) (Corey, James S. A.)\n" +
+		"- Your Highlight on page 12 | Location 170-172 | Added on Monday, January 2, 2023 3:04:05 PM\n\n" +
+		"The stars were very far away."
+	usNote = "Leviathan Wakes (The Expanse Book 1) (Corey, James S. A.)\n" +
+		"- Your Note on page 12 | Location 172 | Added on Monday, January 2, 2023 3:05:00 PM\n\n" +
+		"Nice opening."
+	usBookmark = "Leviathan Wakes (The Expanse Book 1) (Corey, James S. A.)\n" +
+		"- Your Bookmark on page 40 | Location 600 | Added on Monday, January 2, 2023 9:00:00 PM\n\n"
+	ukHighlight = "Middlemarch (Eliot, George)\n" +
+		"- Your Highlight at location 500-501 | Added on Monday, 2 January 2023 15:04:05\n\n" +
+		"It is a narrow mind."
+)
+
+var leviathanWakes = models.ClippingBook{
+	Title:   "Leviathan Wakes (The Expanse Book 1)",
+	Authors: "James S. A. Corey",
+	Clippings: []models.Clipping{{
+		Text: "The stars were very far away.", Note: "Nice opening.",
+		Page: 12, Location: "170-172", AddedAt: "2023-01-02 15:04:05",
+	}},
+}
+
+func TestParseClippings(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          string
+		want        []models.ClippingBook
+		wantSkipped int
+	}{
+		{
+			name: "highlight with its note, bookmark left out",
+			in:   clippingsFile(usHighlight, usNote, usBookmark),
+			want: []models.ClippingBook{leviathanWakes},
+		},
+		{
+			name: "note before its highlight",
+			in:   clippingsFile(usNote, usHighlight),
+			want: []models.ClippingBook{leviathanWakes},
+		},
+		{
+			name: "byte order mark",
+			in:   "\ufeff" + clippingsFile(usHighlight, usNote),
+			want: []models.ClippingBook{leviathanWakes},
+		},
+		{
+			name: "CRLF line endings",
+			in:   strings.ReplaceAll(clippingsFile(usHighlight, usNote), "\n", "\r\n"),
+			want: []models.ClippingBook{leviathanWakes},
+		},
+		{
+			name: "UK date format",
+			in:   clippingsFile(ukHighlight),
+			want: []models.ClippingBook{{
+				Title: "Middlemarch", Authors: "George Eliot",
+				Clippings: []models.Clipping{{Text: "It is a narrow mind.", Location: "500-501", AddedAt: "2023-01-02 15:04:05"}},
+			}},
+		},
+		{
+			name: "unreadable date is left out",
+			in: clippingsFile("Middlemarch (Eliot, George)\n" +
+				"- Your Highlight at location 500-501 | Added on 2023-01-02\n\nIt is a narrow mind."),
+			want: []models.ClippingBook{{
+				Title: "Middlemarch", Authors: "George Eliot",
+				Clippings: []models.Clipping{{Text: "It is a narrow mind.", Location: "500-501"}},
+			}},
+		},
+		{
+			name: "other languages are not understood",
+			in: clippingsFile("Middlemarch (Eliot, George)\n" +
+				"- Ihre Markierung bei Position 500-501 | Hinzugefügt am Montag, 2. Januar 2023 15:04:05\n\nIt is a narrow mind."),
+		},
+		{
+			name:        "note without a highlight is counted",
+			in:          clippingsFile(usNote, ukHighlight),
+			want:        []models.ClippingBook{{Title: "Middlemarch", Authors: "George Eliot", Clippings: []models.Clipping{{Text: "It is a narrow mind.", Location: "500-501", AddedAt: "2023-01-02 15:04:05"}}}},
+			wantSkipped: 1,
+		},
+		{
+			name: "repeated and extended highlights",
+			in: clippingsFile(
+				usHighlight,
+				usHighlight,
+				"Leviathan Wakes (The Expanse Book 1) (Corey, James S. A.)\n"+
+					"- Your Highlight on page 12 | Location 170-174 | Added on Monday, January 2, 2023 3:06:00 PM\n\n"+
+					"The stars were very far away. Closer than Ceres.",
+			),
+			want: []models.ClippingBook{{
+				Title: "Leviathan Wakes (The Expanse Book 1)", Authors: "James S. A. Corey",
+				Clippings: []models.Clipping{{
+					Text: "The stars were very far away. Closer than Ceres.",
+					Page: 12, Location: "170-174", AddedAt: "2023-01-02 15:06:00",
+				}},
+			}},
+		},
+		{
+			name: "multi-line highlight",
+			in:   clippingsFile("Poems\n- Your Highlight on page 3 | Added on Monday, January 2, 2023 3:04:05 PM\n\nFirst line\nSecond line\n"),
+			want: []models.ClippingBook{{
+				Title:     "Poems",
+				Clippings: []models.Clipping{{Text: "First line\nSecond line", Page: 3, AddedAt: "2023-01-02 15:04:05"}},
+			}},
+		},
+		{
+			name: "empty highlight is left out",
+			in:   clippingsFile("Poems\n- Your Highlight on page 3 | Added on Monday, January 2, 2023 3:04:05 PM\n\n"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, skipped, err := ParseClippings(strings.NewReader(tt.in))
+			if err != nil {
+				t.Fatalf("ParseClippings: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseClippings\n got: %+v\nwant: %+v", got, tt.want)
+			}
+			if skipped != tt.wantSkipped {
+				t.Errorf("skipped = %d, want %d", skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
+
+func TestSplitClippingTitle(t *testing.T) {
+	tests := []struct {
+		in         string
+		wantTitle  string
+		wantAuthor string
+	}{
+		{in: "Dune (Herbert, Frank)", wantTitle: "Dune", wantAuthor: "Frank Herbert"},
+		{in: "Dune (Frank Herbert)", wantTitle: "Dune", wantAuthor: "Frank Herbert"},
+		{in: "Leviathan Wakes (The Expanse Book 1) (Corey, James S. A.)", wantTitle: "Leviathan Wakes (The Expanse Book 1)", wantAuthor: "James S. A. Corey"},
+		{in: "Good Omens (Pratchett, Terry;Gaiman, Neil)", wantTitle: "Good Omens", wantAuthor: "Terry Pratchett, Neil Gaiman"},
+		{in: "Dune", wantTitle: "Dune"},
+		{in: "(Untitled)", wantTitle: "(Untitled)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			title, author := splitClippingTitle(tt.in)
+			if title != tt.wantTitle || author != tt.wantAuthor {
+				t.Errorf("splitClippingTitle(%q) = %q, %q, want %q, %q", tt.in, title, author, tt.wantTitle, tt.wantAuthor)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package importer
+
+import (
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+// ErrNoTitleColumn is returned when a mapping doesn't say which column holds
+// the title
+var ErrNoTitleColumn = errors.New("no title column chosen")
+
+// SampleRows is how many rows of a file are shown while mapping its columns
+const SampleRows = 5
+
+// MappingField is a piece of book data that can be read from a CSV column
+type MappingField struct {
+	Key   string
+	Label string
+	// Lowercased column names the field is guessed from, such as those used by
+	// LibraryThing exports
+	aliases []string
+}
+
+// MappingFields lists the fields a generic CSV file can provide, in the
+// order they are offered
+var MappingFields = []MappingField{
+	{Key: "title", Label: "Title", aliases: []string{"title", "book title", "name"}},
+	{Key: "author", Label: "Author", aliases: []string{"author", "authors", "primary author", "author(s)", "writer"}},
+	{Key: "isbn", Label: "ISBN", aliases: []string{"isbn", "isbns", "isbn13", "isbn-13", "isbn10", "isbn-10", "ean"}},
+	{Key: "shelf", Label: "Shelf or status", aliases: []string{"shelf", "status", "read status", "exclusive shelf", "collections", "collection"}},
+	{Key: "rating", Label: "Rating", aliases: []string{"rating", "my rating", "stars", "star rating", "score"}},
+	{Key: "date_added", Label: "Date added", aliases: []string{"date added", "date entered", "added", "entry date", "acquired"}},
+	{Key: "date_started", Label: "Date started", aliases: []string{"date started", "started", "start date"}},
+	{Key: "date_finished", Label: "Date finished", aliases: []string{"date finished", "date read", "finished", "read date", "end date", "last date read"}},
+	{Key: "tags", Label: "Tags", aliases: []string{"tags", "labels", "genres", "bookshelves"}},
+}
+
+// DateLayout is a date format a generic CSV file may use
+type DateLayout struct {
+	Layout  string
+	Example string
+}
+
+// DateLayouts lists the date formats offered for generic CSV files. Dates
+// with a time after them are read too.
+var DateLayouts = []DateLayout{
+	{Layout: "2006-01-02", Example: "2025-03-31"},
+	{Layout: "2006/01/02", Example: "2025/03/31"},
+	{Layout: "01/02/2006", Example: "03/31/2025"},
+	{Layout: "02/01/2006", Example: "31/03/2025"},
+	{Layout: "02.01.2006", Example: "31.03.2025"},
+	{Layout: "Jan 2, 2006", Example: "Mar 31, 2025"},
+}
+
+// ColumnMapping says which column of a generic CSV file holds each of the
+// MappingFields, keyed by field. Unmapped fields are left out.
+type ColumnMapping struct {
+	Columns map[string]string
+	// Shelf for rows without a recognised shelf or status
+	DefaultShelf string
+	// The rating column's maximum, 5 or 10
+	RatingScale int
+	// One of DateLayouts
+	DateLayout string
+}
+
+// ReadColumns returns a CSV file's header and its first few rows, for showing
+// while the user maps columns
+func ReadColumns(r io.Reader) ([]string, [][]string, error) {
+	header, records, err := readCSV(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) > SampleRows {
+		records = records[:SampleRows]
+	}
+	sample := make([][]string, len(records))
+	for i, rec := range records {
+		sample[i] = make([]string, len(header))
+		for j, column := range header {
+			sample[i][j] = rec.get(column)
+		}
+	}
+	return header, sample, nil
+}
+
+// GuessMapping picks the column for each field whose name matches one of the
+// field's usual column names
+func GuessMapping(header []string) ColumnMapping {
+	m := ColumnMapping{
+		Columns:      make(map[string]string),
+		DefaultShelf: "want_to_read",
+		RatingScale:  5,
+		DateLayout:   DateLayouts[0].Layout,
+	}
+	for _, field := range MappingFields {
+		for _, alias := range field.aliases {
+			for _, column := range header {
+				if _, taken := m.Columns[field.Key]; !taken && strings.ToLower(column) == alias {
+					m.Columns[field.Key] = column
+				}
+			}
+		}
+	}
+	return m
+}
+
+// ParseMapped reads a generic CSV file using the columns the user chose
+func ParseMapped(r io.Reader, m ColumnMapping) ([]models.ImportEntry, error) {
+	if m.Columns["title"] == "" {
+		return nil, ErrNoTitleColumn
+	}
+	_, records, err := readCSV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	layouts := []string{m.DateLayout, m.DateLayout + " 15:04:05", m.DateLayout + " 15:04"}
+	entries := make([]models.ImportEntry, 0, len(records))
+	for _, rec := range records {
+		field := func(key string) string {
+			if column := m.Columns[key]; column != "" {
+				return rec.get(column)
+			}
+			return ""
+		}
+
+		title := field("title")
+		if title == "" {
+			continue
+		}
+
+		entry := models.ImportEntry{
+			Line:         rec.line,
+			Title:        title,
+			Authors:      field("author"),
+			Shelf:        mappedShelf(field("shelf"), m.DefaultShelf),
+			Rating:       mappedRating(field("rating"), m.RatingScale),
+			DateAdded:    parseDate(field("date_added"), layouts...),
+			DateStarted:  parseDate(field("date_started"), layouts...),
+			DateFinished: parseDate(field("date_finished"), layouts...),
+			Tags:         splitTags(field("tags")),
+		}
+
+		// A column may list several ISBNs, such as LibraryThing's "ISBNs"
+		for _, isbn := range strings.FieldsFunc(field("isbn"), isListSeparator) {
+			isbn13, isbn10 := cleanISBN(isbn)
+			if entry.ISBN13 == "" {
+				entry.ISBN13 = isbn13
+			}
+			if entry.ISBN10 == "" {
+				entry.ISBN10 = isbn10
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// mappedShelf recognises the common ways of writing each shelf, falling back
+// to the default shelf
+func mappedShelf(value, fallback string) string {
+	v := strings.ToLower(strings.NewReplacer("-", " ", "_", " ").Replace(value))
+	switch {
+	case v == "":
+		return fallback
+	case strings.Contains(v, "did not finish"), strings.Contains(v, "dnf"), strings.Contains(v, "abandon"):
+		return "did_not_finish"
+	case strings.Contains(v, "hold"), strings.Contains(v, "paused"):
+		return "on_hold"
+	case strings.Contains(v, "currently"), strings.Contains(v, "reading"), strings.Contains(v, "in progress"):
+		return "currently_reading"
+	case strings.Contains(v, "to read"), strings.Contains(v, "want"), strings.Contains(v, "tbr"), strings.Contains(v, "wishlist"):
+		return "want_to_read"
+	case v == "read", strings.Contains(v, "finished"), strings.Contains(v, "done"), strings.Contains(v, "completed"):
+		return "read"
+	}
+	return fallback
+}
+
+// mappedRating converts a rating out of scale to rating points, 0 for none
+func mappedRating(value string, scale int) int64 {
+	rating, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || rating <= 0 || scale <= 0 {
+		return 0
+	}
+	points := int64(math.Round(rating * models.MaxRatingPoints / float64(scale)))
+	if points < 1 {
+		points = 1
+	}
+	if points > models.MaxRatingPoints {
+		points = models.MaxRatingPoints
+	}
+	return points
+}
@@ -0,0 +1,160 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+func TestGuessMapping(t *testing.T) {
+	header := []string{"Book Id", "TITLE", "Primary Author", "ISBNs", "Collections", "Rating", "Entry Date", "Date Started", "Date Read", "Tags", "Title"}
+	want := map[string]string{
+		"title":         "TITLE",
+		"author":        "Primary Author",
+		"isbn":          "ISBNs",
+		"shelf":         "Collections",
+		"rating":        "Rating",
+		"date_added":    "Entry Date",
+		"date_started":  "Date Started",
+		"date_finished": "Date Read",
+		"tags":          "Tags",
+	}
+
+	m := GuessMapping(header)
+	if !reflect.DeepEqual(m.Columns, want) {
+		t.Errorf("GuessMapping columns\n got: %v\nwant: %v", m.Columns, want)
+	}
+	if m.DefaultShelf != "want_to_read" || m.RatingScale != 5 || m.DateLayout != "2006-01-02" {
+		t.Errorf("GuessMapping defaults = %q, %d, %q", m.DefaultShelf, m.RatingScale, m.DateLayout)
+	}
+}
+
+func TestParseMapped(t *testing.T) {
+	const header = "Name,Writer,ISBNs,Status,Score,Added,Started,Finished,Labels\n"
+	mapping := ColumnMapping{
+		Columns: map[string]string{
+			"title": "Name", "author": "Writer", "isbn": "ISBNs", "shelf": "Status", "rating": "Score",
+			"date_added": "Added", "date_started": "Started", "date_finished": "Finished", "tags": "Labels",
+		},
+		DefaultShelf: "read",
+		RatingScale:  10,
+		DateLayout:   "02/01/2006",
+	}
+
+	tests := []struct {
+		name string
+		row  string
+		want models.ImportEntry
+	}{
+		{
+			name: "every column",
+			row:  `Dune,Frank Herbert,"[0441013597], [9780441013593]",Finished,7,31/03/2025,01/04/2025 20:15,10/04/2025 08:00:00,sci-fi; classics`,
+			want: models.ImportEntry{
+				Title: "Dune", Authors: "Frank Herbert", ISBN13: "9780441013593", ISBN10: "0441013597", Shelf: "read", Rating: 7,
+				DateAdded: "2025-03-31 00:00:00", DateStarted: "2025-04-01 20:15:00", DateFinished: "2025-04-10 08:00:00",
+				Tags: []string{"sci-fi", "classics"},
+			},
+		},
+		{
+			name: "first ISBN of each kind is kept",
+			row:  `Dune,,9780441013593;9780340960196,,,,,,`,
+			want: models.ImportEntry{Title: "Dune", ISBN13: "9780441013593", Shelf: "read"},
+		},
+		{
+			name: "dates in another layout are dropped",
+			row:  `Dune,,,,,2025-03-31,,,`,
+			want: models.ImportEntry{Title: "Dune", Shelf: "read"},
+		},
+		{
+			name: "rating above the scale is clamped",
+			row:  `Dune,,,currently reading,12,,,,`,
+			want: models.ImportEntry{Title: "Dune", Shelf: "currently_reading", Rating: 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := ParseMapped(strings.NewReader(header+tt.row+"\n"), mapping)
+			if err != nil {
+				t.Fatalf("ParseMapped: %v", err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(entries))
+			}
+			tt.want.Line = 2
+			if !reflect.DeepEqual(entries[0], tt.want) {
+				t.Errorf("ParseMapped\n got: %+v\nwant: %+v", entries[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMappedNeedsTitle(t *testing.T) {
+	_, err := ParseMapped(strings.NewReader("Name\nDune\n"), ColumnMapping{Columns: map[string]string{"author": "Name"}})
+	if err != ErrNoTitleColumn {
+		t.Errorf("got %v, want ErrNoTitleColumn", err)
+	}
+}
+
+func TestMappedShelf(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "", want: "on_hold"},
+		{in: "read", want: "read"},
+		{in: "Finished", want: "read"},
+		{in: "completed", want: "read"},
+		{in: "currently-reading", want: "currently_reading"},
+		{in: "Reading", want: "currently_reading"},
+		{in: "in progress", want: "currently_reading"},
+		{in: "to-read", want: "want_to_read"},
+		{in: "TBR", want: "want_to_read"},
+		{in: "Wishlist", want: "want_to_read"},
+		{in: "did_not_finish", want: "did_not_finish"},
+		{in: "DNF", want: "did_not_finish"},
+		{in: "abandoned", want: "did_not_finish"},
+		{in: "on-hold", want: "on_hold"},
+		{in: "paused", want: "on_hold"},
+		{in: "Your library", want: "on_hold"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := mappedShelf(tt.in, "on_hold"); got != tt.want {
+				t.Errorf("mappedShelf(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMappedRating(t *testing.T) {
+	tests := []struct {
+		in    string
+		scale int
+		want  int64
+	}{
+		{in: "", scale: 5, want: 0},
+		{in: "0", scale: 5, want: 0},
+		{in: "-1", scale: 5, want: 0},
+		{in: "n/a", scale: 5, want: 0},
+		{in: "4", scale: 5, want: 8},
+		{in: " 3.5 ", scale: 5, want: 7},
+		{in: "3.75", scale: 5, want: 8},
+		{in: "3.25", scale: 5, want: 7},
+		{in: "0.1", scale: 5, want: 1},
+		{in: "6", scale: 5, want: 10},
+		{in: "7", scale: 10, want: 7},
+		{in: "4", scale: 0, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := mappedRating(tt.in, tt.scale); got != tt.want {
+				t.Errorf("mappedRating(%q, %d) = %d, want %d", tt.in, tt.scale, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package importer
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+
+	"github.com/nuuner/spines/internal/models"
+	"github.com/nuuner/spines/internal/services"
+)
+
+// ErrNoMatch is returned when no book can be found for an entry
+var ErrNoMatch = errors.New("no matching book found")
+
+// FindBook resolves an entry to a book in the catalog, going through the same
+// ISBN deduplication as adding a book by hand:
+//  1. a book already in the catalog with the entry's ISBN
+//  2. a Google Books ISBN lookup, created with GetOrCreateBookWithISBN
+//  3. a book already in the catalog with the same title and author
+//  4. a Google Books title and author search, taking the first close match
+func FindBook(entry models.ImportEntry, apiKey string) (*models.Book, error) {
+	if entry.ISBN13 != "" || entry.ISBN10 != "" {
+		if book, err := models.GetBookByISBN(entry.ISBN13, entry.ISBN10); err == nil {
+			return book, nil
+		}
+		if result, err := services.GetBookByISBN(entry.ISBN13, entry.ISBN10, apiKey); err == nil && result != nil {
+			return createBook(*result, entry, apiKey)
+		}
+	}
+
+	author := firstAuthor(entry.Authors)
+	if book, err := models.FindBookByTitle(searchTitle(entry.Title), author); err == nil {
+		return book, nil
+	}
+
+	candidates, err := SearchCandidates(entry, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoMatch
+	}
+	return createBook(candidates[0], entry, apiKey)
+}
+
+// SearchCandidates searches Google Books by title and author, returning the
+// results that plausibly are the entry's book, best first
+func SearchCandidates(entry models.ImportEntry, apiKey string) ([]services.BookSearchResult, error) {
+	title := searchTitle(entry.Title)
+	author := firstAuthor(entry.Authors)
+
+	results, err := services.SearchBooks(SearchQuery(entry), apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []services.BookSearchResult
+	for _, r := range results {
+		if titlesMatch(r.Title, title) && authorsMatch(r.Authors, author) {
+			candidates = append(candidates, r)
+		}
+	}
+	return candidates, nil
+}
+
+// SearchQuery returns the Google Books query used to look up an entry by
+// title and author
+func SearchQuery(entry models.ImportEntry) string {
+	query := searchTitle(entry.Title)
+	if author := firstAuthor(entry.Authors); author != "" {
+		query += " inauthor:" + author
+	}
+	return query
+}
+
+// createBook adds a Google Books result to the catalog, or returns the book
+// already there, keeping the entry's page count if Google doesn't know it
+func createBook(r services.BookSearchResult, entry models.ImportEntry, apiKey string) (*models.Book, error) {
+	pageCount := r.PageCount
+	if pageCount == 0 {
+		pageCount = entry.PageCount
+	}
+	book, err := models.GetOrCreateBookWithISBN(r.GoogleBooksID, r.Title, r.Authors, r.Description, r.ThumbnailURL, r.ISBN13, r.ISBN10, pageCount, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	_ = models.UpdateBookCategories(book.ID, r.Categories)
+	return book, nil
+}
+
+// firstAuthor returns the first of a comma separated list of authors
+func firstAuthor(authors string) string {
+	first, _, _ := strings.Cut(authors, ",")
+	return strings.TrimSpace(first)
+}
+
+// searchTitle drops series information such as "(The Expanse, #1)" that
+// exports add to titles but catalogs don't
+func searchTitle(title string) string {
+	if i := strings.LastIndex(title, " ("); i > 0 && strings.HasSuffix(title, ")") && strings.Contains(title[i:], "#") {
+		title = title[:i]
+	}
+	return strings.TrimSpace(title)
+}
+
+// titlesMatch compares titles loosely: ignoring case, punctuation and
+// subtitles, so "Dune: Deluxe Edition" matches "Dune"
+func titlesMatch(a, b string) bool {
+	a, b = normalizeTitle(a), normalizeTitle(b)
+	if a == "" || b == "" {
+		return false
+	}
+	if a == b {
+		return true
+	}
+	mainA, _, _ := strings.Cut(a, ":")
+	mainB, _, _ := strings.Cut(b, ":")
+	return strings.TrimSpace(mainA) == strings.TrimSpace(mainB)
+}
+
+// normalizeTitle lowercases a title and strips punctuation other than the
+// colon separating a subtitle
+func normalizeTitle(title string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == ':':
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// authorsMatch reports whether a result's authors include the author's
+// surname. An unknown author matches anything.
+func authorsMatch(resultAuthors, author string) bool {
+	if author == "" {
+		return true
+	}
+	names := strings.Fields(strings.ToLower(author))
+	surname := names[len(names)-1]
+	return strings.Contains(strings.ToLower(resultAuthors), surname)
+}
@@ -0,0 +1,144 @@
+package importer
+
+import (
+	"database/sql"
+	"log"
+	"runtime/debug"
+	"sync"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+var (
+	// workMu runs one job at a time, keeping metadata lookups and database
+	// writes from piling up when several users import at once
+	workMu sync.Mutex
+
+	startedMu sync.Mutex
+	started   = make(map[int64]bool)
+)
+
+// Start runs an import job in the background, unless it is already running
+func Start(userID, jobID int64, apiKey string) {
+	startedMu.Lock()
+	if started[jobID] {
+		startedMu.Unlock()
+		return
+	}
+	started[jobID] = true
+	startedMu.Unlock()
+
+	go func() {
+		defer func() {
+			startedMu.Lock()
+			delete(started, jobID)
+			startedMu.Unlock()
+		}()
+		// A file that trips up the importer fails its own job rather than
+		// the server, which would otherwise resume it and fail again
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[import] Job %d panicked: %v\n%s", jobID, r, debug.Stack())
+				_ = models.SetImportJobStatus(jobID, models.ImportFailed, "The import stopped unexpectedly")
+			}
+		}()
+		if err := run(userID, jobID, apiKey); err != nil {
+			log.Printf("[import] Job %d failed: %v", jobID, err)
+			_ = models.SetImportJobStatus(jobID, models.ImportFailed, "The import stopped unexpectedly")
+		}
+	}()
+}
+
+// ResumeJobs restarts jobs left pending or running by a previous run of the
+// server. Rows already processed are skipped.
+func ResumeJobs(apiKey string) {
+	jobs, err := models.GetUnfinishedImportJobs()
+	if err != nil {
+		log.Printf("[import] Failed to load unfinished jobs: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		Start(job.UserID, job.ID, apiKey)
+	}
+}
+
+// run matches and shelves each pending row of a job, then records a single
+// activity event for the books imported unless the job is quiet. A job under
+// review stops after matching; once confirmed it runs again to shelve the
+// matched rows.
+func run(userID, jobID int64, apiKey string) error {
+	workMu.Lock()
+	defer workMu.Unlock()
+
+	job, err := models.GetImportJob(userID, jobID)
+	if err != nil {
+		return err
+	}
+	if err := models.SetImportJobStatus(jobID, models.ImportRunning, ""); err != nil {
+		return err
+	}
+
+	rows, err := models.GetImportRows(jobID, models.ImportRowPending)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		status, bookID, message := matchEntry(row.Entry, apiKey)
+		if status == models.ImportRowMatched && !job.Review {
+			status, message = shelveEntry(userID, bookID.Int64, row.Entry)
+		}
+		if err := models.SetImportRowResult(row.ID, status, bookID, message); err != nil {
+			return err
+		}
+	}
+	if job.Review {
+		return models.SetImportJobStatus(jobID, models.ImportReview, "")
+	}
+
+	// Rows matched during review, or left matched by an interrupted run
+	rows, err = models.GetImportRows(jobID, models.ImportRowMatched)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		status, message := shelveEntry(userID, row.BookID.Int64, row.Entry)
+		if err := models.SetImportRowResult(row.ID, status, row.BookID, message); err != nil {
+			return err
+		}
+	}
+
+	job, err = models.GetImportJob(userID, jobID)
+	if err != nil {
+		return err
+	}
+	// Quiet imports leave the activity feed alone, including goals reached
+	if job.Imported > 0 && !job.Quiet {
+		_ = models.CreateImportEvent(userID, job.Imported)
+		_ = models.CheckReadingGoals(userID)
+	}
+	return models.SetImportJobStatus(jobID, models.ImportDone, "")
+}
+
+// matchEntry finds the entry's book, returning the row's new status, the book
+// and a message for the report
+func matchEntry(entry models.ImportEntry, apiKey string) (string, sql.NullInt64, string) {
+	book, err := FindBook(entry, apiKey)
+	if err == ErrNoMatch {
+		return models.ImportRowUnmatched, sql.NullInt64{}, "No matching book found"
+	}
+	if err != nil {
+		log.Printf("[import] Lookup failed for %q: %v", entry.Title, err)
+		return models.ImportRowUnmatched, sql.NullInt64{}, "Book lookup failed"
+	}
+	return models.ImportRowMatched, sql.NullInt64{Int64: book.ID, Valid: true}, ""
+}
+
+// shelveEntry puts a matched book on the user's shelves, returning the row's
+// new status and a message for the report
+func shelveEntry(userID, bookID int64, entry models.ImportEntry) (string, string) {
+	if err := ShelveBook(userID, bookID, entry); err != nil {
+		log.Printf("[import] Failed to shelve %q: %v", entry.Title, err)
+		return models.ImportRowFailed, "Could not add the book to your shelves"
+	}
+	return models.ImportRowImported, ""
+}
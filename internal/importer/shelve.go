@@ -0,0 +1,88 @@
+package importer
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+// ShelveBook puts a book on the user's shelves as described by an import
+// entry, using the same model functions as adding a book by hand. A book the
+// user already has is moved if the shelf differs. Dates, format, ownership
+// and tags from the entry are applied on top, keeping what was recorded
+// before where the entry says nothing.
+func ShelveBook(userID, bookID int64, entry models.ImportEntry) error {
+	rating := sql.NullInt64{}
+	if entry.Shelf == "read" && models.IsValidRating(entry.Rating) {
+		rating = sql.NullInt64{Int64: entry.Rating, Valid: true}
+	}
+
+	existing, _ := models.GetUserBook(userID, bookID)
+	switch {
+	case existing == nil:
+		if err := models.AddBookToShelf(userID, bookID, entry.Shelf, sql.NullString{}, rating); err != nil {
+			return err
+		}
+	case existing.Shelf != entry.Shelf || rating.Valid:
+		// A sub-status belongs to its shelf, so it only survives staying put
+		subStatus := sql.NullString{}
+		if existing.Shelf == entry.Shelf {
+			subStatus = existing.SubStatus
+		}
+		if err := models.UpdateUserBook(userID, bookID, entry.Shelf, subStatus, rating); err != nil {
+			return err
+		}
+	}
+
+	ub, err := models.GetUserBook(userID, bookID)
+	if err != nil {
+		return err
+	}
+
+	// Adding or moving a book stamps its dates with the current time, which is
+	// wrong for history being imported: a read book without dates has unknown
+	// ones rather than being finished today
+	fresh := existing == nil || existing.Shelf != entry.Shelf
+	added, started, finished := ub.AddedAt, ub.StartedReadingAt, ub.FinishedReadingAt
+	if entry.DateAdded != "" {
+		added = sql.NullString{String: entry.DateAdded, Valid: true}
+	}
+	switch {
+	case entry.DateStarted != "" && entry.Shelf != "want_to_read":
+		started = sql.NullString{String: entry.DateStarted, Valid: true}
+	case fresh && entry.Shelf == "read":
+		started = sql.NullString{}
+	}
+	switch {
+	case entry.DateFinished != "" && entry.Shelf == "read":
+		finished = sql.NullString{String: entry.DateFinished, Valid: true}
+	case fresh && entry.Shelf == "read":
+		finished = sql.NullString{}
+	}
+	if err := models.UpdateUserBookDates(userID, bookID, added, started, finished); err != nil {
+		return err
+	}
+
+	if entry.Format != "" || entry.Owned {
+		format, owned := ub.Format, ub.Owned
+		if models.IsValidFormat(entry.Format) {
+			format = sql.NullString{String: entry.Format, Valid: true}
+		}
+		if entry.Owned {
+			owned = sql.NullBool{Bool: true, Valid: true}
+		}
+		if err := models.UpdateUserBookOwnership(userID, bookID, format, owned); err != nil {
+			return err
+		}
+	}
+
+	if len(entry.Tags) > 0 {
+		tags := models.ParseTags(strings.Join(append(ub.Tags(), entry.Tags...), ","))
+		if err := models.SetUserBookTags(userID, bookID, tags); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
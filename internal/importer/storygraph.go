@@ -0,0 +1,97 @@
+package importer
+
+import (
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+// storyGraphDateLayouts are the date formats seen in StoryGraph exports
+var storyGraphDateLayouts = []string{"2006/01/02", "2006-01-02", "2006/1/2"}
+
+// storyGraphReading matches one reading of a "Dates Read" column, a start and
+// end date separated by "-". Dates may use "-" themselves, so the range can't
+// simply be split on it.
+var storyGraphReading = regexp.MustCompile(`^(\d{4}[/-]\d{1,2}[/-]\d{1,2})?\s*(-)?\s*(\d{4}[/-]\d{1,2}[/-]\d{1,2})?$`)
+
+// storyGraphShelves maps StoryGraph read statuses to our shelves
+var storyGraphShelves = map[string]string{
+	"read":              "read",
+	"currently-reading": "currently_reading",
+	"to-read":           "want_to_read",
+	"did-not-finish":    "did_not_finish",
+	"paused":            "on_hold",
+}
+
+// ParseStoryGraph reads a StoryGraph export CSV
+func ParseStoryGraph(r io.Reader) ([]models.ImportEntry, error) {
+	header, records, err := readCSV(r)
+	if err != nil {
+		return nil, err
+	}
+	if !hasColumns(header, "Title", "Authors", "Read Status") {
+		return nil, ErrUnrecognisedFile
+	}
+
+	entries := make([]models.ImportEntry, 0, len(records))
+	for _, rec := range records {
+		title := rec.get("Title")
+		if title == "" {
+			continue
+		}
+
+		// StoryGraph puts either ISBN in one column, or its own ID for books
+		// without one, which cleanISBN discards
+		isbn13, isbn10 := cleanISBN(rec.get("ISBN/UID"))
+
+		shelf, ok := storyGraphShelves[strings.ToLower(rec.get("Read Status"))]
+		if !ok {
+			shelf = "want_to_read"
+		}
+
+		entry := models.ImportEntry{
+			Line:      rec.line,
+			Title:     title,
+			Authors:   rec.get("Authors"),
+			ISBN13:    isbn13,
+			ISBN10:    isbn10,
+			Shelf:     shelf,
+			DateAdded: parseDate(rec.get("Date Added"), storyGraphDateLayouts...),
+			Format:    formatFromBinding(rec.get("Format")),
+			Owned:     strings.EqualFold(rec.get("Owned?"), "yes"),
+			Tags:      splitTags(rec.get("Tags")),
+		}
+		entry.DateStarted, entry.DateFinished = storyGraphDates(rec.get("Dates Read"))
+		if entry.DateFinished == "" {
+			entry.DateFinished = parseDate(rec.get("Last Date Read"), storyGraphDateLayouts...)
+		}
+		// StoryGraph allows quarter stars, which round to the nearest half star
+		entry.Rating = mappedRating(rec.get("Star Rating"), 5)
+
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// storyGraphDates reads the most recent reading of a "Dates Read" column such
+// as "2023/01/04-2023/02/10, 2024/05/01-2024/05/20". Either end may be
+// missing.
+func storyGraphDates(datesRead string) (started, finished string) {
+	readings := strings.Split(datesRead, ",")
+	last := strings.TrimSpace(readings[len(readings)-1])
+	if last == "" {
+		return "", ""
+	}
+	m := storyGraphReading.FindStringSubmatch(last)
+	if m == nil {
+		return "", ""
+	}
+	start, dash, end := m[1], m[2], m[3]
+	if dash == "" {
+		// A single date is when the book was finished
+		return "", parseDate(start, storyGraphDateLayouts...)
+	}
+	return parseDate(start, storyGraphDateLayouts...), parseDate(end, storyGraphDateLayouts...)
+}
@@ -0,0 +1,116 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nuuner/spines/internal/models"
+)
+
+const storyGraphHeader = "Title,Authors,Contributors,ISBN/UID,Format,Read Status,Date Added,Last Date Read,Dates Read,Read Count,Moods,Pace,Character- or Plot-Driven?,Strong Character Development?,Loveable Characters?,Diverse Characters?,Flawed Characters?,Star Rating,Review,Content Warnings,Content Warning Description,Tags,Owned?\n"
+
+func TestParseStoryGraph(t *testing.T) {
+	tests := []struct {
+		name string
+		row  string
+		want models.ImportEntry
+	}{
+		{
+			name: "read book",
+			row:  `Dune,Frank Herbert,,9780441013593,paperback,read,2023/01/01,2023/02/10,2023/01/04-2023/02/10,1,,,,,,,,4.0,,,,"sci-fi, classics",Yes`,
+			want: models.ImportEntry{
+				Title: "Dune", Authors: "Frank Herbert", ISBN13: "9780441013593", Shelf: "read", Rating: 8,
+				DateAdded: "2023-01-01 00:00:00", DateStarted: "2023-01-04 00:00:00", DateFinished: "2023-02-10 00:00:00",
+				Format: "paperback", Owned: true, Tags: []string{"sci-fi", "classics"},
+			},
+		},
+		{
+			name: "quarter star rating rounds up to a half star",
+			row:  `Emma,Jane Austen,,0141439580,digital,read,,,,,,,,,,,,3.75,,,,,No`,
+			want: models.ImportEntry{
+				Title: "Emma", Authors: "Jane Austen", ISBN10: "0141439580", Shelf: "read", Rating: 8, Format: "ebook",
+			},
+		},
+		{
+			name: "quarter star rating rounds to a whole star",
+			row:  `Emma,Jane Austen,,,audio,read,,,,,,,,,,,,4.25,,,,,`,
+			want: models.ImportEntry{Title: "Emma", Authors: "Jane Austen", Shelf: "read", Rating: 9, Format: "audiobook"},
+		},
+		{
+			name: "lowest rating is kept",
+			row:  `Emma,Jane Austen,,,,read,,,,,,,,,,,,0.25,,,,,`,
+			want: models.ImportEntry{Title: "Emma", Authors: "Jane Austen", Shelf: "read", Rating: 1},
+		},
+		{
+			name: "StoryGraph ID is not an ISBN",
+			row:  `Zine,Someone,,ae3b7c1e-0c6f-4b1f-9d1e-2f1d3c4b5a69,,to-read,,,,,,,,,,,,,,,,,`,
+			want: models.ImportEntry{Title: "Zine", Authors: "Someone", Shelf: "want_to_read"},
+		},
+		{
+			name: "paused",
+			row:  `Middlemarch,George Eliot,,,,paused,,,2024/05/01-,,,,,,,,,,,,,,`,
+			want: models.ImportEntry{Title: "Middlemarch", Authors: "George Eliot", Shelf: "on_hold", DateStarted: "2024-05-01 00:00:00"},
+		},
+		{
+			name: "did not finish",
+			row:  `Ulysses,James Joyce,,,,did-not-finish,,,,,,,,,,,,,,,,,`,
+			want: models.ImportEntry{Title: "Ulysses", Authors: "James Joyce", Shelf: "did_not_finish"},
+		},
+		{
+			name: "unknown status",
+			row:  `Ulysses,James Joyce,,,,someday,,2024/05/20,,,,,,,,,,,,,,,`,
+			want: models.ImportEntry{Title: "Ulysses", Authors: "James Joyce", Shelf: "want_to_read", DateFinished: "2024-05-20 00:00:00"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := ParseStoryGraph(strings.NewReader(storyGraphHeader + tt.row + "\n"))
+			if err != nil {
+				t.Fatalf("ParseStoryGraph: %v", err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(entries))
+			}
+			tt.want.Line = 2
+			if !reflect.DeepEqual(entries[0], tt.want) {
+				t.Errorf("ParseStoryGraph\n got: %+v\nwant: %+v", entries[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestStoryGraphDates(t *testing.T) {
+	tests := []struct {
+		name         string
+		in           string
+		wantStarted  string
+		wantFinished string
+	}{
+		{name: "empty", in: ""},
+		{name: "one reading", in: "2023/01/04-2023/02/10", wantStarted: "2023-01-04 00:00:00", wantFinished: "2023-02-10 00:00:00"},
+		{
+			name:        "latest of several readings",
+			in:          "2023/01/04-2023/02/10, 2024/05/01-2024/05/20",
+			wantStarted: "2024-05-01 00:00:00", wantFinished: "2024-05-20 00:00:00",
+		},
+		{name: "hyphenated dates", in: "2024-05-01-2024-05-20", wantStarted: "2024-05-01 00:00:00", wantFinished: "2024-05-20 00:00:00"},
+		{name: "spaces around the dash", in: "2024/05/01 - 2024/05/20", wantStarted: "2024-05-01 00:00:00", wantFinished: "2024-05-20 00:00:00"},
+		{name: "unpadded dates", in: "2024/5/1-2024/5/20", wantStarted: "2024-05-01 00:00:00", wantFinished: "2024-05-20 00:00:00"},
+		{name: "not finished", in: "2024/05/01-", wantStarted: "2024-05-01 00:00:00"},
+		{name: "no start", in: "-2024/05/20", wantFinished: "2024-05-20 00:00:00"},
+		{name: "single date is when it was finished", in: "2024/05/20", wantFinished: "2024-05-20 00:00:00"},
+		{name: "trailing separator", in: "2024/05/01-2024/05/20, "},
+		{name: "unreadable", in: "last spring"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started, finished := storyGraphDates(tt.in)
+			if started != tt.wantStarted || finished != tt.wantFinished {
+				t.Errorf("storyGraphDates(%q) = %q, %q, want %q, %q", tt.in, started, finished, tt.wantStarted, tt.wantFinished)
+			}
+		})
+	}
+}
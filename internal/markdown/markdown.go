@@ -0,0 +1,257 @@
+package markdown
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Render converts a small, safe subset of Markdown into HTML.
+//
+// All input is HTML-escaped before any formatting is applied, so raw HTML in
+// the source is always shown as text. Supported syntax:
+//   - paragraphs, line breaks, "#" headings, "---" rules
+//   - "-", "*" and "1." lists, "> " blockquotes, ``` fenced code
+//   - **bold**, *italic*, ~~strikethrough~~, `code`, [links](https://...)
+//   - spoilers: inline >!hidden text!< and blocks fenced by ":::spoiler" / ":::"
+func Render(source string) template.HTML {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	// NUL marks placeholders while rendering, so it may not appear in the source
+	source = strings.ReplaceAll(source, "\x00", "")
+	return template.HTML(renderBlocks(strings.Split(source, "\n")))
+}
+
+var (
+	headingPattern     = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	orderedItemPattern = regexp.MustCompile(`^\d+[.)]\s+(.*)$`)
+	ruleLinePattern    = regexp.MustCompile(`^(\s*[-*_]\s*){3,}$`)
+
+	codeSpanPattern    = regexp.MustCompile("`([^`]+)`")
+	boldPattern        = regexp.MustCompile(`\*\*(.+?)\*\*`)
+	italicPattern      = regexp.MustCompile(`\*([^*\s][^*]*?)\*`)
+	strikePattern      = regexp.MustCompile(`~~(.+?)~~`)
+	inlineSpoilPattern = regexp.MustCompile(`&gt;!(.+?)!&lt;`)
+	placeholderPattern = regexp.MustCompile("\x00([0-9]+)\x00")
+)
+
+func renderBlocks(lines []string) string {
+	var out strings.Builder
+	var paragraph []string
+
+	flushParagraph := func() {
+		if len(paragraph) == 0 {
+			return
+		}
+		rendered := make([]string, len(paragraph))
+		for i, line := range paragraph {
+			rendered[i] = renderInline(strings.TrimSpace(line))
+		}
+		out.WriteString("<p>" + strings.Join(rendered, "<br>") + "</p>")
+		paragraph = nil
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			flushParagraph()
+
+		case strings.HasPrefix(trimmed, ":::spoiler"):
+			flushParagraph()
+			summary := strings.TrimSpace(strings.TrimPrefix(trimmed, ":::spoiler"))
+			if summary == "" {
+				summary = "Spoiler"
+			}
+			var inner []string
+			depth := 1
+			for i++; i < len(lines); i++ {
+				t := strings.TrimSpace(lines[i])
+				if strings.HasPrefix(t, ":::spoiler") {
+					depth++
+				} else if t == ":::" {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+				inner = append(inner, lines[i])
+			}
+			out.WriteString(`<details class="spoiler"><summary>` + renderInline(summary) + `</summary>` + renderBlocks(inner) + `</details>`)
+
+		case strings.HasPrefix(trimmed, "```"):
+			flushParagraph()
+			var code []string
+			for i++; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), "```") {
+					break
+				}
+				code = append(code, lines[i])
+			}
+			out.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>")
+
+		case headingPattern.MatchString(trimmed):
+			flushParagraph()
+			m := headingPattern.FindStringSubmatch(trimmed)
+			// Review headings sit below the page's own headings
+			level := len(m[1]) + 2
+			if level > 6 {
+				level = 6
+			}
+			tag := "h" + strconv.Itoa(level)
+			out.WriteString("<" + tag + ">" + renderInline(m[2]) + "</" + tag + ">")
+
+		case ruleLinePattern.MatchString(trimmed) && len(paragraph) == 0:
+			out.WriteString("<hr>")
+
+		case strings.HasPrefix(trimmed, ">") && !strings.HasPrefix(trimmed, ">!"):
+			flushParagraph()
+			var quoted []string
+			for ; i < len(lines); i++ {
+				t := strings.TrimSpace(lines[i])
+				if !strings.HasPrefix(t, ">") || strings.HasPrefix(t, ">!") {
+					break
+				}
+				quoted = append(quoted, strings.TrimPrefix(strings.TrimPrefix(t, ">"), " "))
+			}
+			i--
+			out.WriteString("<blockquote>" + renderBlocks(quoted) + "</blockquote>")
+
+		case isUnorderedItem(trimmed) || orderedItemPattern.MatchString(trimmed):
+			flushParagraph()
+			ordered := !isUnorderedItem(trimmed)
+			tag := "ul"
+			if ordered {
+				tag = "ol"
+			}
+			out.WriteString("<" + tag + ">")
+			for ; i < len(lines); i++ {
+				t := strings.TrimSpace(lines[i])
+				var item string
+				if ordered && orderedItemPattern.MatchString(t) {
+					item = orderedItemPattern.FindStringSubmatch(t)[1]
+				} else if !ordered && isUnorderedItem(t) {
+					item = t[2:]
+				} else {
+					break
+				}
+				out.WriteString("<li>" + renderInline(item) + "</li>")
+			}
+			i--
+			out.WriteString("</" + tag + ">")
+
+		default:
+			paragraph = append(paragraph, line)
+		}
+	}
+	flushParagraph()
+
+	return out.String()
+}
+
+func isUnorderedItem(line string) bool {
+	return strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "* ") || strings.HasPrefix(line, "+ ")
+}
+
+// renderInline escapes a line of text and applies inline formatting
+func renderInline(text string) string {
+	text = html.EscapeString(text)
+
+	// Code spans and link tags are swapped for placeholders while the other
+	// patterns run, so their contents and URLs are never formatted
+	var fragments []string
+	hold := func(fragment string) string {
+		fragments = append(fragments, fragment)
+		return "\x00" + strconv.Itoa(len(fragments)-1) + "\x00"
+	}
+
+	text = codeSpanPattern.ReplaceAllStringFunc(text, func(m string) string {
+		return hold("<code>" + codeSpanPattern.FindStringSubmatch(m)[1] + "</code>")
+	})
+	text = renderLinks(text, hold)
+	text = inlineSpoilPattern.ReplaceAllString(text, `<span class="spoiler" tabindex="0">$1</span>`)
+	text = boldPattern.ReplaceAllString(text, "<strong>$1</strong>")
+	text = italicPattern.ReplaceAllString(text, "<em>$1</em>")
+	text = strikePattern.ReplaceAllString(text, "<del>$1</del>")
+
+	return placeholderPattern.ReplaceAllStringFunc(text, func(m string) string {
+		i, _ := strconv.Atoi(placeholderPattern.FindStringSubmatch(m)[1])
+		return fragments[i]
+	})
+}
+
+// renderLinks turns [label](url) into links, handing the anchor tags to hold.
+// The label is left in place so it is still formatted; links nested in a
+// label are reduced to their own label, as links cannot contain links.
+func renderLinks(text string, hold func(string) string) string {
+	var out strings.Builder
+	for i := 0; i < len(text); {
+		if text[i] != '[' {
+			out.WriteByte(text[i])
+			i++
+			continue
+		}
+		label, href, end, ok := parseLink(text, i)
+		if !ok {
+			out.WriteByte(text[i])
+			i++
+			continue
+		}
+		label = renderLinks(label, func(string) string { return "" })
+		if isSafeURL(html.UnescapeString(href)) {
+			out.WriteString(hold(`<a href="`+href+`" rel="nofollow noopener noreferrer">`) + label + hold("</a>"))
+		} else {
+			out.WriteString(label)
+		}
+		i = end
+	}
+	return out.String()
+}
+
+// parseLink reads a [label](url) starting at the "[" at start. Brackets in the
+// label and parentheses in the URL may nest as long as they are balanced.
+func parseLink(text string, start int) (label, href string, end int, ok bool) {
+	depth := 0
+	closeLabel := -1
+	for i := start; i < len(text) && closeLabel < 0; i++ {
+		switch text[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				closeLabel = i
+			}
+		}
+	}
+	if closeLabel <= start+1 || closeLabel+1 >= len(text) || text[closeLabel+1] != '(' {
+		return "", "", 0, false
+	}
+
+	depth = 0
+	for i := closeLabel + 2; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == ')':
+			href = text[closeLabel+2 : i]
+			if href == "" {
+				return "", "", 0, false
+			}
+			return text[start+1 : closeLabel], href, i + 1, true
+		case c == '\x00' || c == ' ' || c == '\t' || c == '\n':
+			return "", "", 0, false
+		}
+	}
+	return "", "", 0, false
+}
+
+func isSafeURL(href string) bool {
+	lower := strings.ToLower(href)
+	return strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "mailto:")
+}
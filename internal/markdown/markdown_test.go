@@ -0,0 +1,83 @@
+package markdown
+
+import "testing"
+
+func TestRenderInline(t *testing.T) {
+	const rel = `" rel="nofollow noopener noreferrer">`
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "plain link",
+			in:   "[x](https://a.example)",
+			want: `<a href="https://a.example` + rel + `x</a>`,
+		},
+		{
+			name: "emphasis markers in the URL are left alone",
+			in:   "[x](https://a*b*c)",
+			want: `<a href="https://a*b*c` + rel + `x</a>`,
+		},
+		{
+			name: "strike and bold markers in the URL are left alone",
+			in:   "[x](https://a~~b~~c**d**)",
+			want: `<a href="https://a~~b~~c**d**` + rel + `x</a>`,
+		},
+		{
+			name: "label is still formatted",
+			in:   "[**x**](https://a.example)",
+			want: `<a href="https://a.example` + rel + `<strong>x</strong></a>`,
+		},
+		{
+			name: "balanced parentheses in the URL",
+			in:   "see [x](https://en.wikipedia.org/wiki/Dune_(novel)) now",
+			want: `see <a href="https://en.wikipedia.org/wiki/Dune_(novel)` + rel + `x</a> now`,
+		},
+		{
+			name: "unbalanced parentheses end the URL at the first closing one",
+			in:   "([x](https://a.example))",
+			want: `(<a href="https://a.example` + rel + `x</a>)`,
+		},
+		{
+			name: "link in a link label becomes its label",
+			in:   "[![img](https://x.example)](https://y.example)",
+			want: `<a href="https://y.example` + rel + `!img</a>`,
+		},
+		{
+			name: "unsafe scheme keeps only the label",
+			in:   "[x](javascript:alert(1))",
+			want: "x",
+		},
+		{
+			name: "URL with a space is not a link",
+			in:   "[x](https://a b)",
+			want: "[x](https://a b)",
+		},
+		{
+			name: "code span contents are not formatted",
+			in:   "`*a*` *b*",
+			want: "<code>*a*</code> <em>b</em>",
+		},
+		{
+			name: "NUL bytes cannot reach another placeholder",
+			in:   "`a` \x000\x00",
+			want: "<code>a</code> 0",
+		},
+		{
+			name: "HTML is escaped",
+			in:   `<b>"x"</b>`,
+			want: "&lt;b&gt;&#34;x&#34;&lt;/b&gt;",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := "<p>" + tt.want + "</p>"
+			if got := string(Render(tt.in)); got != want {
+				t.Errorf("Render(%q)\n got: %s\nwant: %s", tt.in, got, want)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"log"
+	"strings"
 	"sync"
 	"time"
 
@@ -123,6 +124,12 @@ func CSRFProtection(c *fiber.Ctx) error {
 		return c.Next()
 	}
 
+	// ActivityPub inboxes are posted to by other servers, which sign their
+	// requests instead of holding a cookie
+	if c.Method() == "POST" && strings.HasPrefix(c.Path(), "/u/") && strings.HasSuffix(c.Path(), "/inbox") {
+		return c.Next()
+	}
+
 	// POST/PUT/DELETE: validate token
 	if c.Method() == "POST" || c.Method() == "PUT" || c.Method() == "DELETE" {
 		cookieToken := c.Cookies(CSRFCookieName)
@@ -0,0 +1,165 @@
+package models
+
+import (
+	"time"
+
+	"github.com/nuuner/spines/internal/database"
+)
+
+// activityCondition selects the events aliased as e that count as reading
+// activity: progress updates, and books started or finished
+const activityCondition = `(e.event_type = 'reading_progress'
+	OR (e.event_type IN ('book_added', 'book_moved') AND e.shelf IN ('currently_reading', 'read')))`
+
+// activityDay groups events by day in the server's time zone (events are stored in UTC)
+const activityDay = "date(e.created_at, 'localtime')"
+
+// Number of weeks shown in the activity calendar
+const activityWeeks = 53
+
+// ActivityDay is one day in the activity calendar
+type ActivityDay struct {
+	Date  time.Time
+	Count int
+	// Shade from 0 (no activity) to 4 (the busiest days)
+	Level int
+}
+
+// ActivityCalendar is a user's reading activity per day over the last year,
+// GitHub style. Days run from a Sunday, so the calendar fills columns of weeks.
+type ActivityCalendar struct {
+	Days          []ActivityDay
+	Total         int
+	ActiveDays    int
+	CurrentStreak int
+	LongestStreak int
+}
+
+// GetActivityCalendar builds a user's activity calendar for the year up to today.
+// Streaks count consecutive active days over all time; the current streak
+// is still running if the user was active yesterday but not yet today.
+func GetActivityCalendar(userID int64) (*ActivityCalendar, error) {
+	rows, err := database.DB.Query(`
+		SELECT `+activityDay+` AS day, COUNT(*)
+		FROM events e
+		WHERE e.user_id = ? AND `+activityCondition+`
+		GROUP BY day
+		ORDER BY day
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	var days []string
+	for rows.Next() {
+		var day string
+		var count int
+		if err := rows.Scan(&day, &count); err != nil {
+			return nil, err
+		}
+		counts[day] = count
+		days = append(days, day)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	cal := &ActivityCalendar{}
+
+	// Longest streak over all active days, which are in date order
+	streak := 0
+	var previous time.Time
+	for _, day := range days {
+		date, err := time.ParseInLocation("2006-01-02", day, time.Local)
+		if err != nil {
+			continue
+		}
+		if !previous.IsZero() && date.Equal(previous.AddDate(0, 0, 1)) {
+			streak++
+		} else {
+			streak = 1
+		}
+		cal.LongestStreak = max(cal.LongestStreak, streak)
+		previous = date
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+
+	day := today
+	if counts[day.Format("2006-01-02")] == 0 {
+		day = day.AddDate(0, 0, -1)
+	}
+	for counts[day.Format("2006-01-02")] > 0 {
+		cal.CurrentStreak++
+		day = day.AddDate(0, 0, -1)
+	}
+
+	start := today.AddDate(0, 0, -7*(activityWeeks-1))
+	start = start.AddDate(0, 0, -int(start.Weekday()))
+	most := 0
+	for d := start; !d.After(today); d = d.AddDate(0, 0, 1) {
+		count := counts[d.Format("2006-01-02")]
+		cal.Days = append(cal.Days, ActivityDay{Date: d, Count: count})
+		cal.Total += count
+		if count > 0 {
+			cal.ActiveDays++
+		}
+		most = max(most, count)
+	}
+	for i := range cal.Days {
+		cal.Days[i].Level = activityLevel(cal.Days[i].Count, most)
+	}
+
+	return cal, nil
+}
+
+// activityLevel buckets a day's count into quarters of the busiest day
+func activityLevel(count, most int) int {
+	if count == 0 || most == 0 {
+		return 0
+	}
+	level := (count*4 + most - 1) / most
+	return min(max(level, 1), 4)
+}
+
+// GetUserActivityOn returns a user's reading activity events on a day, oldest first
+func GetUserActivityOn(userID int64, day time.Time) ([]Event, error) {
+	rows, err := database.DB.Query(`
+		SELECT e.id, e.user_id, e.event_type, e.book_id, e.shelf, e.old_value, e.new_value, e.created_at,
+		       u.id, u.username, u.display_name, u.description, u.password_hash, u.profile_picture, COALESCE(u.theme, 'light'), u.created_at,
+		       b.id, b.google_books_id, b.title, b.authors, b.thumbnail_url, b.isbn_13, b.isbn_10, b.page_count, b.created_at
+		FROM events e
+		INNER JOIN users u ON e.user_id = u.id
+		LEFT JOIN books b ON e.book_id = b.id
+		WHERE e.user_id = ? AND `+activityCondition+` AND `+activityDay+` = ?
+		ORDER BY e.created_at, e.id
+	`, userID, day.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanEvents(rows)
+}
+
+// DateParam returns the day as used in activity URLs, e.g. "2025-03-05"
+func (d ActivityDay) DateParam() string {
+	return d.Date.Format("2006-01-02")
+}
+
+// Description summarises the day for tooltips, e.g. "3 updates on Mar 5, 2025"
+func (d ActivityDay) Description() string {
+	count := "No activity"
+	if d.Count > 0 {
+		count = pluralize(d.Count, "update")
+	}
+	return count + " on " + d.Date.Format("Jan 2, 2006")
+}
+
+// TimeOfDay returns when the event happened in the server's time zone, e.g. "3:04 PM"
+func (e Event) TimeOfDay() string {
+	return e.CreatedAt.Local().Format("3:04 PM")
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/nuuner/spines/internal/database"
@@ -19,9 +20,18 @@ type Book struct {
 	ISBN13        sql.NullString
 	ISBN10        sql.NullString
 	PageCount     sql.NullInt64
+	Categories    sql.NullString // separated by services.CategorySeparator
 	CreatedAt     time.Time
 }
 
+// bookColumns lists the books columns read by Book.scanFields
+const bookColumns = `b.id, b.google_books_id, b.title, b.authors, b.description, b.thumbnail_url, b.isbn_13, b.isbn_10, b.page_count, b.categories, b.created_at`
+
+// scanFields returns scan destinations matching bookColumns
+func (b *Book) scanFields() []interface{} {
+	return []interface{}{&b.ID, &b.GoogleBooksID, &b.Title, &b.Authors, &b.Description, &b.ThumbnailURL, &b.ISBN13, &b.ISBN10, &b.PageCount, &b.Categories, &b.CreatedAt}
+}
+
 func GetBookByGoogleID(googleBooksID string) (*Book, error) {
 	var b Book
 	err := database.DB.QueryRow(
@@ -102,6 +112,34 @@ func GetBookByISBN(isbn13, isbn10 string) (*Book, error) {
 	return nil, sql.ErrNoRows
 }
 
+// FindBookByTitle looks up a book by title, ignoring case. When an author is
+// given, only a book whose authors include them matches.
+func FindBookByTitle(title, author string) (*Book, error) {
+	rows, err := database.DB.Query(
+		"SELECT "+bookColumns+" FROM books b WHERE b.title = ? COLLATE NOCASE ORDER BY b.id",
+		title,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	author = strings.ToLower(author)
+	for rows.Next() {
+		var b Book
+		if err := rows.Scan(b.scanFields()...); err != nil {
+			return nil, err
+		}
+		if author == "" || strings.Contains(strings.ToLower(b.Authors), author) {
+			return &b, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return nil, sql.ErrNoRows
+}
+
 // UpdateBookISBN updates the ISBN and page count for an existing book
 func UpdateBookISBN(bookID int64, isbn13, isbn10 string, pageCount int) error {
 	var nullISBN13, nullISBN10 sql.NullString
@@ -124,6 +162,81 @@ func UpdateBookISBN(bookID int64, isbn13, isbn10 string, pageCount int) error {
 	return err
 }
 
+// UpdateBookCategories records a book's categories (genres) if it has none yet
+func UpdateBookCategories(bookID int64, categories string) error {
+	if categories == "" {
+		return nil
+	}
+	_, err := database.DB.Exec(
+		"UPDATE books SET categories = COALESCE(categories, ?) WHERE id = ?",
+		categories, bookID,
+	)
+	return err
+}
+
+// FetchBookCategories records a book's categories (genres) from Google Books
+// if none are recorded yet. They are always looked up by the server, as the
+// book is shared by every user. A book Google has none for gets an empty
+// list, so it isn't looked up again.
+func FetchBookCategories(bookID int64, googleBooksID, apiKey string) error {
+	var known bool
+	if err := database.DB.QueryRow("SELECT categories IS NOT NULL FROM books WHERE id = ?", bookID).Scan(&known); err != nil {
+		return err
+	}
+	if known || googleBooksID == "" {
+		return nil
+	}
+	categories, err := services.GetVolumeCategories(googleBooksID, apiKey)
+	if err != nil {
+		return err
+	}
+	_, err = database.DB.Exec("UPDATE books SET categories = COALESCE(categories, ?) WHERE id = ?", categories, bookID)
+	return err
+}
+
+// categoryBackfillDelay spaces out the lookups of BackfillBookCategories, to
+// stay well inside Google Books' rate limits
+const categoryBackfillDelay = time.Second
+
+// BackfillBookCategories looks up the categories of books added before they
+// were recorded. It stops at the first failed lookup, such as when the API
+// quota runs out, and carries on from there the next time it runs.
+func BackfillBookCategories(apiKey string) {
+	rows, err := database.DB.Query("SELECT id, google_books_id FROM books WHERE categories IS NULL AND google_books_id != '' ORDER BY id")
+	if err != nil {
+		log.Printf("Book category backfill failed: %v", err)
+		return
+	}
+	type pendingBook struct {
+		id            int64
+		googleBooksID string
+	}
+	var books []pendingBook
+	for rows.Next() {
+		var b pendingBook
+		if err := rows.Scan(&b.id, &b.googleBooksID); err != nil {
+			rows.Close()
+			log.Printf("Book category backfill failed: %v", err)
+			return
+		}
+		books = append(books, b)
+	}
+	rows.Close()
+
+	for i, b := range books {
+		if i > 0 {
+			time.Sleep(categoryBackfillDelay)
+		}
+		if err := FetchBookCategories(b.id, b.googleBooksID, apiKey); err != nil {
+			log.Printf("Book category backfill stopped after %d of %d books: %v", i, len(books), err)
+			return
+		}
+	}
+	if len(books) > 0 {
+		log.Printf("Looked up categories for %d books", len(books))
+	}
+}
+
 // GetOrCreateBook creates a book or returns existing one (legacy function without ISBN support)
 func GetOrCreateBook(googleBooksID, title, authors, thumbnailURL string) (*Book, error) {
 	return GetOrCreateBookWithISBN(googleBooksID, title, authors, "", thumbnailURL, "", "", 0, "")
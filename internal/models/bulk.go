@@ -0,0 +1,167 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"github.com/nuuner/spines/internal/database"
+)
+
+// execer is satisfied by both *sql.DB and *sql.Tx, so updates can run on
+// their own or as part of a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Bulk actions, stored as the old_value of a books_bulk_updated event
+const (
+	BulkMove   = "move"
+	BulkRemove = "remove"
+	BulkTag    = "tag"
+	BulkDates  = "dates"
+	BulkImport = "import"
+)
+
+// BulkDateFields maps the dates that can be set in bulk to their columns
+var BulkDateFields = map[string]string{
+	"added_at":            "added_at",
+	"started_reading_at":  "started_reading_at",
+	"finished_reading_at": "finished_reading_at",
+}
+
+// BulkMoveBooks moves books to a shelf in a single transaction. Books already on
+// the shelf are left alone. Returns how many books were moved.
+func BulkMoveBooks(userID int64, bookIDs []int64, shelf string) (int, error) {
+	return runBulk(userID, BulkMove, shelf, func(tx *sql.Tx) (int, error) {
+		moved := 0
+		for _, bookID := range bookIDs {
+			var current string
+			err := tx.QueryRow("SELECT shelf FROM user_books WHERE user_id = ? AND book_id = ?", userID, bookID).Scan(&current)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+			if current == shelf {
+				continue
+			}
+			if err := updateUserBook(tx, userID, bookID, shelf, sql.NullString{}, sql.NullInt64{}); err != nil {
+				return 0, err
+			}
+			moved++
+		}
+		return moved, nil
+	})
+}
+
+// BulkRemoveBooks removes books from the user's shelves in a single transaction.
+// Returns how many books were removed.
+func BulkRemoveBooks(userID int64, bookIDs []int64) (int, error) {
+	return runBulk(userID, BulkRemove, "", func(tx *sql.Tx) (int, error) {
+		removed := 0
+		for _, bookID := range bookIDs {
+			result, err := tx.Exec("DELETE FROM user_books WHERE user_id = ? AND book_id = ?", userID, bookID)
+			if err != nil {
+				return 0, err
+			}
+			if n, _ := result.RowsAffected(); n > 0 {
+				removed++
+			}
+		}
+		return removed, nil
+	})
+}
+
+// BulkTagBooks adds a tag to books in a single transaction, keeping their other
+// tags. Returns how many books were tagged.
+func BulkTagBooks(userID int64, bookIDs []int64, tag string) (int, error) {
+	return runBulk(userID, BulkTag, "", func(tx *sql.Tx) (int, error) {
+		tagged := 0
+		for _, bookID := range bookIDs {
+			result, err := tx.Exec(`
+				INSERT OR IGNORE INTO user_book_tags (user_book_id, tag)
+				SELECT id, ? FROM user_books WHERE user_id = ? AND book_id = ?
+			`, tag, userID, bookID)
+			if err != nil {
+				return 0, err
+			}
+			if n, _ := result.RowsAffected(); n > 0 {
+				tagged++
+			}
+		}
+		return tagged, nil
+	})
+}
+
+// BulkSetDates sets one of the dates in BulkDateFields on books in a single
+// transaction. Start dates are only set on books that have been started and
+// finish dates only on read books. Returns how many books were updated.
+func BulkSetDates(userID int64, bookIDs []int64, field, value string) (int, error) {
+	column, ok := BulkDateFields[field]
+	if !ok {
+		return 0, errors.New("unknown date field: " + field)
+	}
+	condition := ""
+	switch column {
+	case "started_reading_at":
+		condition = " AND shelf != 'want_to_read'"
+	case "finished_reading_at":
+		condition = " AND shelf = 'read'"
+	}
+	return runBulk(userID, BulkDates, "", func(tx *sql.Tx) (int, error) {
+		updated := 0
+		for _, bookID := range bookIDs {
+			result, err := tx.Exec("UPDATE user_books SET "+column+" = ? WHERE user_id = ? AND book_id = ?"+condition,
+				value, userID, bookID)
+			if err != nil {
+				return 0, err
+			}
+			if n, _ := result.RowsAffected(); n > 0 {
+				updated++
+			}
+		}
+		return updated, nil
+	})
+}
+
+// CreateImportEvent records a single event for the books added by an import,
+// rather than one per book
+func CreateImportEvent(userID int64, count int) error {
+	return CreateEvent(userID, EventBulkUpdate,
+		sql.NullInt64{},
+		sql.NullString{},
+		sql.NullString{String: BulkImport, Valid: true},
+		sql.NullString{String: strconv.Itoa(count), Valid: true},
+	)
+}
+
+// runBulk runs a bulk action in a transaction and records a single event
+// summarising it, unless no books were changed
+func runBulk(userID int64, action, shelf string, apply func(tx *sql.Tx) (int, error)) (int, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	count, err := apply(tx)
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, tx.Commit()
+	}
+
+	err = createEvent(tx, userID, EventBulkUpdate,
+		sql.NullInt64{},
+		sql.NullString{String: shelf, Valid: shelf != ""},
+		sql.NullString{String: action, Valid: true},
+		sql.NullString{String: strconv.Itoa(count), Valid: true},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return count, tx.Commit()
+}
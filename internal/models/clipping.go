@@ -0,0 +1,151 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/nuuner/spines/internal/database"
+)
+
+// Clipping is a highlight read from a Kindle's "My Clippings.txt", with the
+// note made on it if any
+type Clipping struct {
+	Text     string `json:"text"`
+	Note     string `json:"note,omitempty"`
+	Page     int64  `json:"page,omitempty"`
+	Location string `json:"location,omitempty"`
+	// When it was highlighted, as "2006-01-02 15:04:05"
+	AddedAt string `json:"added_at,omitempty"`
+}
+
+// ClippingBook is a book's highlights from a clippings file. Those whose book
+// isn't on the user's shelves are kept as pending until the user picks the
+// book or discards them.
+type ClippingBook struct {
+	ID        int64
+	Title     string
+	Authors   string
+	Clippings []Clipping
+	CreatedAt time.Time
+}
+
+// SaveClippings adds highlights to a user's book as private quotes, with
+// their notes as comments, returning how many were added. Highlights already
+// saved with the same text are skipped, so a clippings file can be imported
+// again as it grows.
+func SaveClippings(userBookID int64, clippings []Clipping) (int, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	saved := 0
+	for _, clip := range clippings {
+		var exists int
+		err := tx.QueryRow("SELECT COUNT(*) FROM quotes WHERE user_book_id = ? AND text = ?", userBookID, clip.Text).Scan(&exists)
+		if err != nil {
+			return 0, err
+		}
+		if exists > 0 {
+			continue
+		}
+
+		page := sql.NullInt64{Int64: clip.Page, Valid: clip.Page > 0}
+		comment := sql.NullString{String: clip.Note, Valid: clip.Note != ""}
+		createdAt := time.Now().UTC().Format("2006-01-02 15:04:05")
+		if t, err := time.ParseInLocation("2006-01-02 15:04:05", clip.AddedAt, time.Local); err == nil {
+			createdAt = t.UTC().Format("2006-01-02 15:04:05")
+		}
+		_, err = tx.Exec(
+			"INSERT INTO quotes (user_book_id, text, page, comment, created_at) VALUES (?, ?, ?, ?, ?)",
+			userBookID, clip.Text, page, comment, createdAt,
+		)
+		if err != nil {
+			return 0, err
+		}
+		saved++
+	}
+	return saved, tx.Commit()
+}
+
+// SavePendingClippings keeps a book's highlights for the user to review,
+// replacing any kept from an earlier import of the same book, as a clippings
+// file includes everything highlighted before
+func SavePendingClippings(userID int64, book ClippingBook) error {
+	data, err := json.Marshal(book.Clippings)
+	if err != nil {
+		return err
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM pending_clippings WHERE user_id = ? AND title = ? AND authors = ?", userID, book.Title, book.Authors)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO pending_clippings (user_id, title, authors, clippings) VALUES (?, ?, ?, ?)",
+		userID, book.Title, book.Authors, string(data),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetPendingClippings returns the books with highlights waiting for review,
+// by title
+func GetPendingClippings(userID int64) ([]ClippingBook, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, title, authors, clippings, created_at
+		FROM pending_clippings
+		WHERE user_id = ?
+		ORDER BY title COLLATE NOCASE, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var books []ClippingBook
+	for rows.Next() {
+		b, err := scanClippingBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, *b)
+	}
+	return books, rows.Err()
+}
+
+// GetPendingClipping returns one of a user's books waiting for review
+func GetPendingClipping(userID, id int64) (*ClippingBook, error) {
+	return scanClippingBook(database.DB.QueryRow(
+		"SELECT id, title, authors, clippings, created_at FROM pending_clippings WHERE id = ? AND user_id = ?",
+		id, userID,
+	))
+}
+
+func scanClippingBook(scanner interface{ Scan(...interface{}) error }) (*ClippingBook, error) {
+	var b ClippingBook
+	var data string
+	if err := scanner.Scan(&b.ID, &b.Title, &b.Authors, &data, &b.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(data), &b.Clippings); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// DeletePendingClipping removes a book's highlights from review
+func DeletePendingClipping(userID, id int64) error {
+	_, err := database.DB.Exec("DELETE FROM pending_clippings WHERE id = ? AND user_id = ?", id, userID)
+	return err
+}
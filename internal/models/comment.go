@@ -0,0 +1,249 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/nuuner/spines/internal/database"
+)
+
+// maxCommentIndent caps how far replies are indented, so deep threads stay
+// readable on narrow screens
+const maxCommentIndent = 4
+
+// Who removed a comment
+const (
+	RemovedByAuthor = "author"
+	RemovedByAdmin  = "admin"
+)
+
+// ErrInvalidParent is returned when replying to a comment that isn't on the
+// same event or has been removed
+var ErrInvalidParent = errors.New("comment replied to is not on this event")
+
+// Comment is a comment on an event, or a reply to another comment
+type Comment struct {
+	ID        int64
+	EventID   int64
+	UserID    int64
+	ParentID  sql.NullInt64
+	Body      string
+	Removed   sql.NullString
+	CreatedAt time.Time
+	// Joined data
+	User *User
+	// How far the comment is indented in its thread
+	Indent int
+}
+
+// TimeAgo returns a human-readable relative time string
+func (c Comment) TimeAgo() string {
+	return timeAgo(c.CreatedAt)
+}
+
+// RemovedDisplay explains a removed comment in place of its text
+func (c Comment) RemovedDisplay() string {
+	if c.Removed.String == RemovedByAdmin {
+		return "Comment removed by a moderator"
+	}
+	return "Comment deleted"
+}
+
+const commentColumns = `
+	c.id, c.event_id, c.user_id, c.parent_id, c.body, c.removed, c.created_at,
+	u.id, u.username, u.display_name, u.description, u.password_hash, u.profile_picture, COALESCE(u.theme, 'light'), u.created_at`
+
+func scanComments(rows *sql.Rows) ([]Comment, error) {
+	var comments []Comment
+	for rows.Next() {
+		var c Comment
+		var u User
+		if err := rows.Scan(
+			&c.ID, &c.EventID, &c.UserID, &c.ParentID, &c.Body, &c.Removed, &c.CreatedAt,
+			&u.ID, &u.Username, &u.DisplayName, &u.Description, &u.PasswordHash, &u.ProfilePicture, &u.Theme, &u.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		c.User = &u
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+// AddComment adds a user's comment to an event, replying to parentID if it
+// is set, and notifies the event's owner and the author of the comment
+// replied to
+func AddComment(event *Event, userID int64, parentID sql.NullInt64, body string) (int64, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var parentAuthor int64
+	if parentID.Valid {
+		err := tx.QueryRow(
+			"SELECT user_id FROM event_comments WHERE id = ? AND event_id = ? AND removed IS NULL",
+			parentID.Int64, event.ID,
+		).Scan(&parentAuthor)
+		if err == sql.ErrNoRows {
+			return 0, ErrInvalidParent
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO event_comments (event_id, user_id, parent_id, body) VALUES (?, ?, ?, ?)",
+		event.ID, userID, parentID, body,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	// A reply to the event owner's own comment notifies them once, as a reply
+	if parentID.Valid && parentAuthor != userID {
+		if err := createNotification(tx, parentAuthor, userID, NotificationReply, event.ID, id); err != nil {
+			return 0, err
+		}
+	}
+	if event.UserID != userID && !(parentID.Valid && parentAuthor == event.UserID) {
+		if err := createNotification(tx, event.UserID, userID, NotificationComment, event.ID, id); err != nil {
+			return 0, err
+		}
+	}
+
+	return id, tx.Commit()
+}
+
+// GetComment returns a comment by ID
+func GetComment(commentID int64) (*Comment, error) {
+	rows, err := database.DB.Query(`
+		SELECT `+commentColumns+`
+		FROM event_comments c
+		INNER JOIN users u ON c.user_id = u.id
+		WHERE c.id = ?
+	`, commentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments, err := scanComments(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(comments) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &comments[0], nil
+}
+
+// GetEventComments returns an event's comments in thread order: each
+// comment is followed by its replies, oldest first
+func GetEventComments(eventID int64) ([]Comment, error) {
+	rows, err := database.DB.Query(`
+		SELECT `+commentColumns+`
+		FROM event_comments c
+		INNER JOIN users u ON c.user_id = u.id
+		WHERE c.event_id = ?
+		ORDER BY c.id
+	`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments, err := scanComments(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	replies := make(map[int64][]Comment)
+	var roots []Comment
+	for _, c := range comments {
+		if c.ParentID.Valid {
+			replies[c.ParentID.Int64] = append(replies[c.ParentID.Int64], c)
+		} else {
+			roots = append(roots, c)
+		}
+	}
+
+	threaded := make([]Comment, 0, len(comments))
+	var walk func(c Comment, indent int)
+	walk = func(c Comment, indent int) {
+		c.Indent = min(indent, maxCommentIndent)
+		threaded = append(threaded, c)
+		for _, reply := range replies[c.ID] {
+			walk(reply, indent+1)
+		}
+	}
+	for _, c := range roots {
+		walk(c, 0)
+	}
+	return threaded, nil
+}
+
+// GetRecentComments returns the newest comments on all events, for
+// moderation
+func GetRecentComments(limit int) ([]Comment, error) {
+	rows, err := database.DB.Query(`
+		SELECT `+commentColumns+`
+		FROM event_comments c
+		INNER JOIN users u ON c.user_id = u.id
+		ORDER BY c.id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanComments(rows)
+}
+
+// GetUserComments returns every comment a user has written, removed ones
+// included, newest first
+func GetUserComments(userID int64) ([]Comment, error) {
+	rows, err := database.DB.Query(`
+		SELECT `+commentColumns+`
+		FROM event_comments c
+		INNER JOIN users u ON c.user_id = u.id
+		WHERE c.user_id = ?
+		ORDER BY c.id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanComments(rows)
+}
+
+// RemoveComment removes a comment's text, leaving its place in the thread so
+// replies to it still make sense. by is RemovedByAuthor or RemovedByAdmin.
+func RemoveComment(commentID int64, by string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"UPDATE event_comments SET body = '', removed = ? WHERE id = ?",
+		by, commentID,
+	); err != nil {
+		return err
+	}
+	// Nobody needs telling about a comment that's gone
+	if _, err := tx.Exec("DELETE FROM notifications WHERE comment_id = ?", commentID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
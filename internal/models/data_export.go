@@ -0,0 +1,198 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nuuner/spines/internal/database"
+)
+
+// Data export statuses
+const (
+	DataExportPending = "pending"
+	DataExportRunning = "running"
+	DataExportDone    = "done"
+	DataExportFailed  = "failed"
+)
+
+// DataExportLifetime is how long a finished archive can be downloaded before
+// it is deleted
+const DataExportLifetime = 7 * 24 * time.Hour
+
+// DataExport is an archive of everything stored about a user, built in the
+// background at the request of the user or an admin
+type DataExport struct {
+	ID          int64
+	UserID      int64
+	RequestedBy string // "user" or "admin"
+	Status      string
+	// Name of the archive in the export directory, once built
+	Filename   sql.NullString
+	Size       int64
+	Error      sql.NullString
+	CreatedAt  time.Time
+	FinishedAt sql.NullString
+}
+
+const dataExportColumns = "id, user_id, requested_by, status, filename, size, error, created_at, finished_at"
+
+// scanFields returns scan destinations matching dataExportColumns
+func (e *DataExport) scanFields() []interface{} {
+	return []interface{}{&e.ID, &e.UserID, &e.RequestedBy, &e.Status, &e.Filename, &e.Size, &e.Error, &e.CreatedAt, &e.FinishedAt}
+}
+
+// ErrDataExportWorking is returned when queueing an archive for a user who
+// already has one pending or running
+var ErrDataExportWorking = errors.New("an archive is already being built")
+
+// CreateDataExport queues a new archive of a user's data, unless one is
+// already waiting or being built. The check is part of the insert, so two
+// requests at once can't both queue one.
+func CreateDataExport(userID int64, requestedBy string) (int64, error) {
+	result, err := database.DB.Exec(`
+		INSERT INTO data_exports (user_id, requested_by)
+		SELECT ?, ?
+		WHERE NOT EXISTS (SELECT 1 FROM data_exports WHERE user_id = ? AND status IN (?, ?))
+	`, userID, requestedBy, userID, DataExportPending, DataExportRunning)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return 0, err
+	} else if n == 0 {
+		return 0, ErrDataExportWorking
+	}
+	return result.LastInsertId()
+}
+
+// GetDataExport returns an export by ID
+func GetDataExport(id int64) (*DataExport, error) {
+	var e DataExport
+	err := database.DB.QueryRow("SELECT "+dataExportColumns+" FROM data_exports WHERE id = ?", id).Scan(e.scanFields()...)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// GetUserDataExports returns a user's most recent exports, newest first
+func GetUserDataExports(userID int64, limit int) ([]DataExport, error) {
+	return queryDataExports(
+		"SELECT "+dataExportColumns+" FROM data_exports WHERE user_id = ? ORDER BY created_at DESC, id DESC LIMIT ?",
+		userID, limit,
+	)
+}
+
+// GetUnfinishedDataExports returns exports that are waiting or were
+// interrupted, oldest first
+func GetUnfinishedDataExports() ([]DataExport, error) {
+	return queryDataExports("SELECT " + dataExportColumns + " FROM data_exports WHERE status IN ('pending', 'running') ORDER BY id")
+}
+
+// GetAllDataExports returns every export of every user
+func GetAllDataExports() ([]DataExport, error) {
+	return queryDataExports("SELECT " + dataExportColumns + " FROM data_exports ORDER BY id")
+}
+
+func queryDataExports(query string, args ...interface{}) ([]DataExport, error) {
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exports []DataExport
+	for rows.Next() {
+		var e DataExport
+		if err := rows.Scan(e.scanFields()...); err != nil {
+			return nil, err
+		}
+		exports = append(exports, e)
+	}
+	return exports, rows.Err()
+}
+
+// SetDataExportStatus updates an export's status, recording when it finished
+// and why it failed where that applies
+func SetDataExportStatus(id int64, status, errMessage string) error {
+	_, err := database.DB.Exec(`
+		UPDATE data_exports
+		SET status = ?, error = ?,
+			finished_at = CASE WHEN ? IN ('done', 'failed') THEN CURRENT_TIMESTAMP ELSE NULL END
+		WHERE id = ?
+	`, status, sql.NullString{String: errMessage, Valid: errMessage != ""}, status, id)
+	return err
+}
+
+// FinishDataExport records the archive built for an export
+func FinishDataExport(id int64, filename string, size int64) error {
+	_, err := database.DB.Exec(
+		"UPDATE data_exports SET status = 'done', filename = ?, size = ?, error = NULL, finished_at = CURRENT_TIMESTAMP WHERE id = ?",
+		filename, size, id,
+	)
+	return err
+}
+
+// DeleteDataExport removes an export's record. The caller removes its file.
+func DeleteDataExport(id int64) error {
+	_, err := database.DB.Exec("DELETE FROM data_exports WHERE id = ?", id)
+	return err
+}
+
+// IsWorking reports whether the export is queued or being built
+func (e DataExport) IsWorking() bool {
+	return e.Status == DataExportPending || e.Status == DataExportRunning
+}
+
+// IsReady reports whether the archive can be downloaded
+func (e DataExport) IsReady() bool {
+	return e.Status == DataExportDone && e.Filename.Valid && !e.IsExpired()
+}
+
+// finishedAt returns when the export stopped, or the zero time
+func (e DataExport) finishedAt() time.Time {
+	if !e.FinishedAt.Valid {
+		return time.Time{}
+	}
+	t, err := parseDateTime(e.FinishedAt.String)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// ExpiresAt returns when a finished export is deleted
+func (e DataExport) ExpiresAt() time.Time {
+	if finished := e.finishedAt(); !finished.IsZero() {
+		return finished.Add(DataExportLifetime)
+	}
+	return e.CreatedAt.Add(DataExportLifetime)
+}
+
+// IsExpired reports whether a finished export is past its lifetime
+func (e DataExport) IsExpired() bool {
+	return !e.IsWorking() && time.Now().After(e.ExpiresAt())
+}
+
+// CreatedAtDisplay returns when the export was requested, e.g. "Mar 5, 2025 14:02"
+func (e DataExport) CreatedAtDisplay() string {
+	return e.CreatedAt.Local().Format("Jan 2, 2006 15:04")
+}
+
+// ExpiresAtDisplay returns when the export is deleted, e.g. "Mar 12, 2025"
+func (e DataExport) ExpiresAtDisplay() string {
+	return e.ExpiresAt().Local().Format("Jan 2, 2006")
+}
+
+// SizeDisplay returns the archive's size, e.g. "1.4 MB"
+func (e DataExport) SizeDisplay() string {
+	switch {
+	case e.Size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(e.Size)/(1<<20))
+	case e.Size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(e.Size)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", e.Size)
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,6 +16,11 @@ const (
 	EventBookMoved       = "book_moved"
 	EventReadingProgress = "reading_progress"
 	EventBookRemoved     = "book_removed"
+	EventReviewPosted    = "review_posted"
+	EventBookPaused      = "book_paused"
+	EventBookDNF         = "book_dnf"
+	EventBulkUpdate      = "books_bulk_updated"
+	EventGoalReached     = "goal_reached"
 )
 
 // Event represents a user activity event
@@ -30,11 +36,19 @@ type Event struct {
 	// Joined data
 	User *User
 	Book *Book
+	// Filled in by LoadEventCounts
+	ReactionCount int
+	CommentCount  int
 }
 
 // CreateEvent creates a new event record
 func CreateEvent(userID int64, eventType string, bookID sql.NullInt64, shelf, oldValue, newValue sql.NullString) error {
-	_, err := database.DB.Exec(`
+	return createEvent(database.DB, userID, eventType, bookID, shelf, oldValue, newValue)
+}
+
+// createEvent creates a new event record using db, which may be a transaction
+func createEvent(db execer, userID int64, eventType string, bookID sql.NullInt64, shelf, oldValue, newValue sql.NullString) error {
+	_, err := db.Exec(`
 		INSERT INTO events (user_id, event_type, book_id, shelf, old_value, new_value)
 		VALUES (?, ?, ?, ?, ?, ?)
 	`, userID, eventType, bookID, shelf, oldValue, newValue)
@@ -89,6 +103,49 @@ func CreateBookRemovedEvent(userID, bookID int64, shelf string) error {
 	)
 }
 
+// CreateReviewPostedEvent creates an event for when a user publishes a review of a book
+func CreateReviewPostedEvent(userID, bookID int64, shelf string) error {
+	return CreateEvent(
+		userID,
+		EventReviewPosted,
+		sql.NullInt64{Int64: bookID, Valid: true},
+		sql.NullString{String: shelf, Valid: true},
+		sql.NullString{},
+		sql.NullString{},
+	)
+}
+
+// CreateBookStoppedEvent creates an event for when a user puts a book on hold
+// (book_paused) or gives up on it (book_dnf). new_value records how far they got.
+func CreateBookStoppedEvent(userID, bookID int64, oldShelf, newShelf string, page, percent sql.NullInt64) error {
+	eventType := EventBookPaused
+	if newShelf == "did_not_finish" {
+		eventType = EventBookDNF
+	}
+	progress := formatStoppedProgress(page, percent)
+	return CreateEvent(
+		userID,
+		eventType,
+		sql.NullInt64{Int64: bookID, Valid: true},
+		sql.NullString{String: newShelf, Valid: true},
+		sql.NullString{String: oldShelf, Valid: oldShelf != ""},
+		sql.NullString{String: progress, Valid: progress != ""},
+	)
+}
+
+// CreateGoalReachedEvent creates an event for when a user meets their reading
+// goal. old_value records the goal and new_value its year.
+func CreateGoalReachedEvent(userID int64, goal *ReadingGoal) error {
+	return CreateEvent(
+		userID,
+		EventGoalReached,
+		sql.NullInt64{},
+		sql.NullString{},
+		sql.NullString{String: goal.Summary(), Valid: true},
+		sql.NullString{String: strconv.Itoa(goal.Year), Valid: true},
+	)
+}
+
 // GetLatestEventPerUser returns the most recent event for each user
 // This is useful for showing a news feed of recent activity
 func GetLatestEventPerUser(limit int) ([]Event, error) {
@@ -191,6 +248,157 @@ func GetUserEvents(userID int64, limit int) ([]Event, error) {
 	return scanEvents(rows)
 }
 
+// GetUserShelfEvents returns a user's events of books arriving on a shelf,
+// whether added to it or moved there, such as the books they finished
+func GetUserShelfEvents(userID int64, shelf string, limit int) ([]Event, error) {
+	rows, err := database.DB.Query(`
+		SELECT e.id, e.user_id, e.event_type, e.book_id, e.shelf, e.old_value, e.new_value, e.created_at,
+		       u.id, u.username, u.display_name, u.description, u.password_hash, u.profile_picture, COALESCE(u.theme, 'light'), u.created_at,
+		       b.id, b.google_books_id, b.title, b.authors, b.thumbnail_url, b.isbn_13, b.isbn_10, b.page_count, b.created_at
+		FROM events e
+		INNER JOIN users u ON e.user_id = u.id
+		INNER JOIN books b ON e.book_id = b.id
+		WHERE e.user_id = ? AND e.shelf = ? AND e.event_type IN (?, ?)
+		ORDER BY e.created_at DESC
+		LIMIT ?
+	`, userID, shelf, EventBookAdded, EventBookMoved, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanEvents(rows)
+}
+
+// GetUserBookEvents returns a page of a user's events about a book, newest
+// first, older than beforeID unless it is 0
+func GetUserBookEvents(userID, beforeID int64, limit int) ([]Event, error) {
+	rows, err := database.DB.Query(`
+		SELECT e.id, e.user_id, e.event_type, e.book_id, e.shelf, e.old_value, e.new_value, e.created_at,
+		       u.id, u.username, u.display_name, u.description, u.password_hash, u.profile_picture, COALESCE(u.theme, 'light'), u.created_at,
+		       b.id, b.google_books_id, b.title, b.authors, b.thumbnail_url, b.isbn_13, b.isbn_10, b.page_count, b.created_at
+		FROM events e
+		INNER JOIN users u ON e.user_id = u.id
+		INNER JOIN books b ON e.book_id = b.id
+		WHERE e.user_id = ? AND (? = 0 OR e.id < ?)
+		ORDER BY e.id DESC
+		LIMIT ?
+	`, userID, beforeID, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanEvents(rows)
+}
+
+// CountUserBookEvents returns how many of a user's events are about a book
+func CountUserBookEvents(userID int64) (int, error) {
+	var count int
+	err := database.DB.QueryRow(`
+		SELECT COUNT(*) FROM events e
+		INNER JOIN books b ON e.book_id = b.id
+		WHERE e.user_id = ?
+	`, userID).Scan(&count)
+	return count, err
+}
+
+// GetEvent returns an event by ID
+func GetEvent(eventID int64) (*Event, error) {
+	rows, err := database.DB.Query(`
+		SELECT e.id, e.user_id, e.event_type, e.book_id, e.shelf, e.old_value, e.new_value, e.created_at,
+		       u.id, u.username, u.display_name, u.description, u.password_hash, u.profile_picture, COALESCE(u.theme, 'light'), u.created_at,
+		       b.id, b.google_books_id, b.title, b.authors, b.thumbnail_url, b.isbn_13, b.isbn_10, b.page_count, b.created_at
+		FROM events e
+		INNER JOIN users u ON e.user_id = u.id
+		LEFT JOIN books b ON e.book_id = b.id
+		WHERE e.id = ?
+	`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events, err := scanEvents(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &events[0], nil
+}
+
+// GetUserEvent returns one of a user's events
+func GetUserEvent(userID, eventID int64) (*Event, error) {
+	rows, err := database.DB.Query(`
+		SELECT e.id, e.user_id, e.event_type, e.book_id, e.shelf, e.old_value, e.new_value, e.created_at,
+		       u.id, u.username, u.display_name, u.description, u.password_hash, u.profile_picture, COALESCE(u.theme, 'light'), u.created_at,
+		       b.id, b.google_books_id, b.title, b.authors, b.thumbnail_url, b.isbn_13, b.isbn_10, b.page_count, b.created_at
+		FROM events e
+		INNER JOIN users u ON e.user_id = u.id
+		LEFT JOIN books b ON e.book_id = b.id
+		WHERE e.user_id = ? AND e.id = ?
+	`, userID, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events, err := scanEvents(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &events[0], nil
+}
+
+// GetEventsAfter returns events newer than afterID, oldest first
+func GetEventsAfter(afterID int64, limit int) ([]Event, error) {
+	rows, err := database.DB.Query(`
+		SELECT e.id, e.user_id, e.event_type, e.book_id, e.shelf, e.old_value, e.new_value, e.created_at,
+		       u.id, u.username, u.display_name, u.description, u.password_hash, u.profile_picture, COALESCE(u.theme, 'light'), u.created_at,
+		       b.id, b.google_books_id, b.title, b.authors, b.thumbnail_url, b.isbn_13, b.isbn_10, b.page_count, b.created_at
+		FROM events e
+		INNER JOIN users u ON e.user_id = u.id
+		LEFT JOIN books b ON e.book_id = b.id
+		WHERE e.id > ?
+		ORDER BY e.id
+		LIMIT ?
+	`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanEvents(rows)
+}
+
+// GetFollowingEvents returns a page of events from the users a user follows,
+// newest first, older than beforeID unless it is 0
+func GetFollowingEvents(userID, beforeID int64, limit int) ([]Event, error) {
+	rows, err := database.DB.Query(`
+		SELECT e.id, e.user_id, e.event_type, e.book_id, e.shelf, e.old_value, e.new_value, e.created_at,
+		       u.id, u.username, u.display_name, u.description, u.password_hash, u.profile_picture, COALESCE(u.theme, 'light'), u.created_at,
+		       b.id, b.google_books_id, b.title, b.authors, b.thumbnail_url, b.isbn_13, b.isbn_10, b.page_count, b.created_at
+		FROM events e
+		INNER JOIN follows f ON f.followed_id = e.user_id AND f.follower_id = ?
+		INNER JOIN users u ON e.user_id = u.id
+		LEFT JOIN books b ON e.book_id = b.id
+		WHERE ? = 0 OR e.id < ?
+		ORDER BY e.id DESC
+		LIMIT ?
+	`, userID, beforeID, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanEvents(rows)
+}
+
 // GetLatestNEventsPerUser returns the most recent N events per user, sorted by date
 // This is useful for the dashboard news feed showing multiple events per user
 func GetLatestNEventsPerUser(eventsPerUser, totalLimit int) ([]Event, error) {
@@ -340,6 +548,10 @@ func (e Event) ShelfDisplay() string {
 		return "Currently Reading"
 	case "read":
 		return "Read"
+	case "on_hold":
+		return "On Hold"
+	case "did_not_finish":
+		return "Did Not Finish"
 	default:
 		return e.Shelf.String
 	}
@@ -369,6 +581,10 @@ func formatValueDisplay(val string) string {
 		return "Currently Reading"
 	case "read":
 		return "Read"
+	case "on_hold":
+		return "On Hold"
+	case "did_not_finish":
+		return "Did Not Finish"
 	case "just_started":
 		return "Just started"
 	case "25_percent":
@@ -403,15 +619,58 @@ func (e Event) EventDescription() string {
 		return "updated progress on \"" + bookTitle + "\""
 	case EventBookRemoved:
 		return "removed \"" + bookTitle + "\" from " + e.ShelfDisplay()
+	case EventReviewPosted:
+		return "reviewed \"" + bookTitle + "\""
+	case EventBookPaused:
+		if e.NewValue.Valid {
+			return "put \"" + bookTitle + "\" on hold at " + e.NewValue.String
+		}
+		return "put \"" + bookTitle + "\" on hold"
+	case EventBookDNF:
+		if e.NewValue.Valid {
+			return "stopped reading \"" + bookTitle + "\" at " + e.NewValue.String
+		}
+		return "stopped reading \"" + bookTitle + "\""
+	case EventBulkUpdate:
+		return e.bulkDescription()
+	case EventGoalReached:
+		return "reached their " + e.NewValue.String + " reading goal of " + e.OldValue.String
 	default:
 		return "performed an action"
 	}
 }
 
+// bulkDescription describes a bulk update, which records its action in
+// old_value and the number of books in new_value
+func (e Event) bulkDescription() string {
+	books := e.NewValue.String + " books"
+	if e.NewValue.String == "1" {
+		books = "1 book"
+	}
+	switch e.OldValue.String {
+	case BulkMove:
+		return "moved " + books + " to " + e.ShelfDisplay()
+	case BulkRemove:
+		return "removed " + books
+	case BulkTag:
+		return "tagged " + books
+	case BulkDates:
+		return "updated dates on " + books
+	case BulkImport:
+		return "imported " + books
+	default:
+		return "updated " + books
+	}
+}
+
 // TimeAgo returns a human-readable relative time string
 func (e Event) TimeAgo() string {
-	now := time.Now()
-	diff := now.Sub(e.CreatedAt)
+	return timeAgo(e.CreatedAt)
+}
+
+// timeAgo returns how long ago t was, or its date once it's a week old
+func timeAgo(t time.Time) string {
+	diff := time.Since(t)
 
 	switch {
 	case diff < time.Minute:
@@ -435,6 +694,6 @@ func (e Event) TimeAgo() string {
 		}
 		return fmt.Sprintf("%d days ago", days)
 	default:
-		return e.CreatedAt.Format("Jan 2, 2006")
+		return t.Format("Jan 2, 2006")
 	}
 }
@@ -0,0 +1,291 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuuner/spines/internal/services"
+)
+
+// LibraryExportVersion is bumped when the JSON export changes incompatibly
+const LibraryExportVersion = 1
+
+// LibraryExport is everything on a user's shelves, for backing up or moving
+// to another service
+type LibraryExport struct {
+	Version     int            `json:"version"`
+	ExportedAt  time.Time      `json:"exported_at"`
+	Username    string         `json:"username"`
+	DisplayName string         `json:"display_name"`
+	Books       []ExportedBook `json:"books"`
+}
+
+// ExportedBook is one shelved book in a LibraryExport. Unknown values are
+// left out.
+type ExportedBook struct {
+	Shelf          string     `json:"shelf"`
+	SubStatus      string     `json:"sub_status,omitempty"`
+	AddedAt        *time.Time `json:"added_at,omitempty"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+	RatingPoints   int64      `json:"rating_points,omitempty"` // 1-10, see rating.go
+	StoppedAt      *time.Time `json:"stopped_at,omitempty"`
+	StoppedPage    *int64     `json:"stopped_page,omitempty"`
+	StoppedPercent *int64     `json:"stopped_percent,omitempty"`
+	StoppedReason  string     `json:"stopped_reason,omitempty"`
+	Format         string     `json:"format,omitempty"`
+	Owned          *bool      `json:"owned,omitempty"`
+	QueuePosition  *int64     `json:"queue_position,omitempty"`
+	Tags           []string   `json:"tags,omitempty"`
+	Review         string     `json:"review,omitempty"` // Markdown
+	Notes          string     `json:"notes,omitempty"`
+	Book           BookExport `json:"book"`
+}
+
+// BookExport is the catalog metadata of an exported book
+type BookExport struct {
+	ID            int64    `json:"id"`
+	GoogleBooksID string   `json:"google_books_id"`
+	Title         string   `json:"title"`
+	Authors       string   `json:"authors,omitempty"`
+	ISBN13        string   `json:"isbn_13,omitempty"`
+	ISBN10        string   `json:"isbn_10,omitempty"`
+	PageCount     int64    `json:"page_count,omitempty"`
+	Categories    []string `json:"categories,omitempty"`
+	Description   string   `json:"description,omitempty"`
+	ThumbnailURL  string   `json:"thumbnail_url,omitempty"`
+}
+
+// exportShelves are the Goodreads names of our shelves. Goodreads only has
+// the first three built in; the others import as custom exclusive shelves.
+var exportShelves = map[string]string{
+	"want_to_read":      "to-read",
+	"currently_reading": "currently-reading",
+	"read":              "read",
+	"on_hold":           "on-hold",
+	"did_not_finish":    "did-not-finish",
+}
+
+// exportBindings are the Goodreads bindings of our formats
+var exportBindings = map[string]string{
+	"hardcover":    "Hardcover",
+	"paperback":    "Paperback",
+	"ebook":        "Kindle Edition",
+	"audiobook":    "Audiobook",
+	"library_loan": "Library Binding",
+}
+
+// GetLibraryExport gathers a user's shelved books with their reviews and
+// notes, shelf by shelf
+func GetLibraryExport(user *User) (*LibraryExport, error) {
+	shelves, err := GetUserBooks(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// A limit of -1 means no limit in SQLite
+	reviews, err := GetUserReviews(user.ID, -1)
+	if err != nil {
+		return nil, err
+	}
+	reviewByBook := make(map[int64]string, len(reviews))
+	for _, r := range reviews {
+		reviewByBook[r.UserBookID] = r.Body
+	}
+	notes, err := SearchUserNotes(user.ID, "", -1)
+	if err != nil {
+		return nil, err
+	}
+	notesByBook := make(map[int64]string, len(notes))
+	for _, n := range notes {
+		notesByBook[n.UserBookID] = n.Notes
+	}
+
+	export := &LibraryExport{
+		Version:     LibraryExportVersion,
+		ExportedAt:  time.Now().UTC(),
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		Books:       []ExportedBook{},
+	}
+	for _, shelf := range [][]UserBook{shelves.WantToRead, shelves.CurrentlyReading, shelves.Read, shelves.OnHold, shelves.DidNotFinish} {
+		for _, ub := range shelf {
+			book := exportUserBook(ub)
+			book.Review = reviewByBook[ub.ID]
+			book.Notes = notesByBook[ub.ID]
+			export.Books = append(export.Books, book)
+		}
+	}
+	return export, nil
+}
+
+// exportUserBook converts a shelved book, which must have its Book loaded
+func exportUserBook(ub UserBook) ExportedBook {
+	b := ub.Book
+	book := ExportedBook{
+		Shelf:         ub.Shelf,
+		SubStatus:     ub.SubStatus.String,
+		AddedAt:       exportTime(ub.AddedAt),
+		StartedAt:     exportTime(ub.StartedReadingAt),
+		FinishedAt:    exportTime(ub.FinishedReadingAt),
+		RatingPoints:  ub.Rating.Int64,
+		StoppedAt:     exportTime(ub.StoppedAt),
+		StoppedReason: ub.StoppedReason.String,
+		Format:        ub.Format.String,
+		Tags:          ub.Tags(),
+		Book: BookExport{
+			ID:            b.ID,
+			GoogleBooksID: b.GoogleBooksID,
+			Title:         b.Title,
+			Authors:       b.Authors,
+			ISBN13:        b.ISBN13.String,
+			ISBN10:        b.ISBN10.String,
+			PageCount:     b.PageCount.Int64,
+			Description:   b.Description.String,
+			ThumbnailURL:  b.ThumbnailURL,
+		},
+	}
+	if ub.StoppedPage.Valid {
+		book.StoppedPage = &ub.StoppedPage.Int64
+	}
+	if ub.StoppedPercent.Valid {
+		book.StoppedPercent = &ub.StoppedPercent.Int64
+	}
+	if ub.Owned.Valid {
+		book.Owned = &ub.Owned.Bool
+	}
+	if ub.QueuePosition.Valid {
+		book.QueuePosition = &ub.QueuePosition.Int64
+	}
+	if b.Categories.Valid && b.Categories.String != "" {
+		book.Book.Categories = strings.Split(b.Categories.String, services.CategorySeparator)
+	}
+	return book
+}
+
+// exportTime converts a stored date to UTC, or nil if it is unset
+func exportTime(s sql.NullString) *time.Time {
+	if !s.Valid {
+		return nil
+	}
+	t, err := parseDateTime(s.String)
+	if err != nil || t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// WriteGoodreadsCSV writes the export in the column layout of a Goodreads
+// library export, so it can be imported by Goodreads and anything that reads
+// Goodreads exports. Ratings are rounded to whole stars; on hold and did not
+// finish become custom exclusive shelves and tags become shelves.
+func (e *LibraryExport) WriteGoodreadsCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := []string{
+		"Book Id", "Title", "Author", "Author l-f", "Additional Authors", "ISBN", "ISBN13",
+		"My Rating", "Average Rating", "Publisher", "Binding", "Number of Pages", "Year Published",
+		"Original Publication Year", "Date Read", "Date Added", "Bookshelves", "Bookshelves with positions",
+		"Exclusive Shelf", "My Review", "Spoiler", "Private Notes", "Read Count", "Owned Copies",
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, book := range e.Books {
+		authors := strings.Split(book.Book.Authors, ",")
+		for i := range authors {
+			authors[i] = strings.TrimSpace(authors[i])
+		}
+
+		shelf := exportShelves[book.Shelf]
+		shelves := append([]string{shelf}, book.Tags...)
+
+		// Goodreads rates in whole stars, 0 meaning unrated
+		rating := "0"
+		if book.RatingPoints > 0 {
+			rating = strconv.Itoa(int(math.Round(float64(book.RatingPoints) / 2)))
+		}
+
+		readCount, ownedCopies := "0", "0"
+		if book.Shelf == "read" {
+			readCount = "1"
+		}
+		if book.Owned != nil && *book.Owned {
+			ownedCopies = "1"
+		}
+
+		pages := ""
+		if book.Book.PageCount > 0 {
+			pages = strconv.FormatInt(book.Book.PageCount, 10)
+		}
+
+		record := []string{
+			strconv.FormatInt(book.Book.ID, 10),
+			csvText(book.Book.Title),
+			csvText(authors[0]),
+			csvText(authorLastFirst(authors[0])),
+			csvText(strings.Join(authors[1:], ", ")),
+			spreadsheetText(book.Book.ISBN10),
+			spreadsheetText(book.Book.ISBN13),
+			rating,
+			"", "",
+			exportBindings[book.Format],
+			pages,
+			"", "",
+			goodreadsDate(book.FinishedAt),
+			goodreadsDate(book.AddedAt),
+			csvText(strings.Join(shelves, ", ")),
+			"",
+			shelf,
+			csvText(book.Review),
+			"",
+			csvText(book.Notes),
+			readCount,
+			ownedCopies,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// authorLastFirst turns "Frank Herbert" into "Herbert, Frank"
+func authorLastFirst(name string) string {
+	i := strings.LastIndex(name, " ")
+	if i < 0 {
+		return name
+	}
+	return name[i+1:] + ", " + name[:i]
+}
+
+// spreadsheetText wraps a value as ="..." like Goodreads does for ISBNs, so
+// spreadsheets don't turn them into numbers. It is the only formula written.
+func spreadsheetText(s string) string {
+	return `="` + s + `"`
+}
+
+// csvText keeps text a user wrote from being read as a formula by
+// spreadsheets, which treat cells starting with these characters as one,
+// by prefixing it with a quote
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// goodreadsDate formats a date as Goodreads exports do, e.g. "2025/03/31"
+func goodreadsDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Local().Format("2006/01/02")
+}
@@ -0,0 +1,217 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/nuuner/spines/internal/database"
+)
+
+// ActorKey is the key pair a user's ActivityPub actor signs requests with,
+// PEM encoded
+type ActorKey struct {
+	UserID     int64
+	PublicKey  string
+	PrivateKey string
+}
+
+// GetActorKey returns a user's key pair, or sql.ErrNoRows if they have none yet
+func GetActorKey(userID int64) (*ActorKey, error) {
+	k := ActorKey{UserID: userID}
+	err := database.DB.QueryRow(
+		"SELECT public_key, private_key FROM actor_keys WHERE user_id = ?", userID,
+	).Scan(&k.PublicKey, &k.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// SaveActorKey stores a user's key pair unless they already have one, and
+// returns the one kept, so concurrent callers agree on a single key
+func SaveActorKey(userID int64, publicKey, privateKey string) (*ActorKey, error) {
+	_, err := database.DB.Exec(
+		"INSERT OR IGNORE INTO actor_keys (user_id, public_key, private_key) VALUES (?, ?, ?)",
+		userID, publicKey, privateKey,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return GetActorKey(userID)
+}
+
+// RemoteFollower is an actor on another server following a user
+type RemoteFollower struct {
+	ID        int64
+	UserID    int64
+	ActorID   string
+	Inbox     string
+	CreatedAt time.Time
+}
+
+// AddRemoteFollower records that a remote actor follows a user, updating the
+// inbox of one already following
+func AddRemoteFollower(userID int64, actorID, inbox string) error {
+	_, err := database.DB.Exec(`
+		INSERT INTO remote_followers (user_id, actor_id, inbox) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, actor_id) DO UPDATE SET inbox = excluded.inbox
+	`, userID, actorID, inbox)
+	return err
+}
+
+// RemoveRemoteFollower records that a remote actor stopped following a user
+func RemoveRemoteFollower(userID int64, actorID string) error {
+	_, err := database.DB.Exec("DELETE FROM remote_followers WHERE user_id = ? AND actor_id = ?", userID, actorID)
+	return err
+}
+
+// RemoveRemoteFollowersByInbox drops every remote follower delivered to
+// through an inbox, for servers that stopped accepting deliveries
+func RemoveRemoteFollowersByInbox(inbox string) error {
+	_, err := database.DB.Exec("DELETE FROM remote_followers WHERE inbox = ?", inbox)
+	return err
+}
+
+// GetRemoteFollowers returns the remote actors following a user
+func GetRemoteFollowers(userID int64) ([]RemoteFollower, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, user_id, actor_id, inbox, created_at
+		FROM remote_followers
+		WHERE user_id = ?
+		ORDER BY id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var followers []RemoteFollower
+	for rows.Next() {
+		var f RemoteFollower
+		if err := rows.Scan(&f.ID, &f.UserID, &f.ActorID, &f.Inbox, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		followers = append(followers, f)
+	}
+	return followers, rows.Err()
+}
+
+// CountRemoteFollowers returns how many remote actors follow a user
+func CountRemoteFollowers(userID int64) (int, error) {
+	var count int
+	err := database.DB.QueryRow("SELECT COUNT(*) FROM remote_followers WHERE user_id = ?", userID).Scan(&count)
+	return count, err
+}
+
+// GetLastDeliveredEvent returns the newest event sent to remote followers.
+// The first time, delivery starts from the newest event, so the existing
+// history isn't sent.
+func GetLastDeliveredEvent() (int64, error) {
+	var id int64
+	err := database.DB.QueryRow("SELECT last_event_id FROM activitypub_delivery WHERE id = 1").Scan(&id)
+	if err != sql.ErrNoRows {
+		return id, err
+	}
+	if err := database.DB.QueryRow("SELECT COALESCE(MAX(id), 0) FROM events").Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, SetLastDeliveredEvent(id)
+}
+
+// setLastDeliveredEventSQL records the newest event queued for remote followers
+const setLastDeliveredEventSQL = `
+	INSERT INTO activitypub_delivery (id, last_event_id) VALUES (1, ?)
+	ON CONFLICT(id) DO UPDATE SET last_event_id = excluded.last_event_id
+`
+
+// SetLastDeliveredEvent records the newest event sent to remote followers
+func SetLastDeliveredEvent(eventID int64) error {
+	_, err := database.DB.Exec(setLastDeliveredEventSQL, eventID)
+	return err
+}
+
+// OutgoingActivity is an activity waiting to be delivered to a remote inbox,
+// signed as the user it belongs to
+type OutgoingActivity struct {
+	ID       int64
+	Inbox    string
+	UserID   int64
+	Username string
+	Activity string // JSON
+}
+
+// QueueActivities stores activities for delivery and moves the delivery
+// cursor past the events they came from in one transaction, so a restart
+// neither loses them nor queues them twice. The activities are returned with
+// their IDs set.
+func QueueActivities(activities []OutgoingActivity, lastEventID int64) ([]OutgoingActivity, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for i, a := range activities {
+		result, err := tx.Exec(
+			"INSERT INTO activitypub_outbox (inbox, user_id, activity) VALUES (?, ?, ?)",
+			a.Inbox, a.UserID, a.Activity,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if activities[i].ID, err = result.LastInsertId(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(setLastDeliveredEventSQL, lastEventID); err != nil {
+		return nil, err
+	}
+	return activities, tx.Commit()
+}
+
+// GetOutgoingActivities returns every activity waiting for delivery, oldest first
+func GetOutgoingActivities() ([]OutgoingActivity, error) {
+	rows, err := database.DB.Query(`
+		SELECT o.id, o.inbox, o.user_id, u.username, o.activity
+		FROM activitypub_outbox o
+		JOIN users u ON u.id = o.user_id
+		ORDER BY o.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var activities []OutgoingActivity
+	for rows.Next() {
+		var a OutgoingActivity
+		if err := rows.Scan(&a.ID, &a.Inbox, &a.UserID, &a.Username, &a.Activity); err != nil {
+			return nil, err
+		}
+		activities = append(activities, a)
+	}
+	return activities, rows.Err()
+}
+
+// DeleteOutgoingActivities removes activities that were delivered or dropped
+func DeleteOutgoingActivities(ids []int64) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		if _, err := tx.Exec("DELETE FROM activitypub_outbox WHERE id = ?", id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteOutgoingActivitiesForInbox drops everything waiting for an inbox,
+// for servers that stopped accepting deliveries
+func DeleteOutgoingActivitiesForInbox(inbox string) error {
+	_, err := database.DB.Exec("DELETE FROM activitypub_outbox WHERE inbox = ?", inbox)
+	return err
+}
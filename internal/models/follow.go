@@ -0,0 +1,96 @@
+package models
+
+import (
+	"time"
+
+	"github.com/nuuner/spines/internal/database"
+)
+
+// FollowUser makes one user follow another; following someone twice is a no-op
+func FollowUser(followerID, followedID int64) error {
+	_, err := database.DB.Exec(
+		"INSERT OR IGNORE INTO follows (follower_id, followed_id) VALUES (?, ?)",
+		followerID, followedID,
+	)
+	return err
+}
+
+// UnfollowUser stops one user following another
+func UnfollowUser(followerID, followedID int64) error {
+	_, err := database.DB.Exec(
+		"DELETE FROM follows WHERE follower_id = ? AND followed_id = ?",
+		followerID, followedID,
+	)
+	return err
+}
+
+// IsFollowing reports whether one user follows another
+func IsFollowing(followerID, followedID int64) (bool, error) {
+	var exists bool
+	err := database.DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM follows WHERE follower_id = ? AND followed_id = ?)",
+		followerID, followedID,
+	).Scan(&exists)
+	return exists, err
+}
+
+// CountFollowers returns how many users follow a user
+func CountFollowers(userID int64) (int, error) {
+	var count int
+	err := database.DB.QueryRow("SELECT COUNT(*) FROM follows WHERE followed_id = ?", userID).Scan(&count)
+	return count, err
+}
+
+// CountFollowing returns how many users a user follows
+func CountFollowing(userID int64) (int, error) {
+	var count int
+	err := database.DB.QueryRow("SELECT COUNT(*) FROM follows WHERE follower_id = ?", userID).Scan(&count)
+	return count, err
+}
+
+// Follow is a user on the other side of a follow, and when it started
+type Follow struct {
+	UserID    int64
+	Username  string
+	CreatedAt time.Time
+}
+
+// GetFollowing returns the users a user follows, oldest follow first
+func GetFollowing(userID int64) ([]Follow, error) {
+	return getFollows(`
+		SELECT u.id, u.username, f.created_at
+		FROM follows f
+		JOIN users u ON u.id = f.followed_id
+		WHERE f.follower_id = ?
+		ORDER BY f.created_at, u.id
+	`, userID)
+}
+
+// GetFollowers returns the users following a user, oldest follow first
+func GetFollowers(userID int64) ([]Follow, error) {
+	return getFollows(`
+		SELECT u.id, u.username, f.created_at
+		FROM follows f
+		JOIN users u ON u.id = f.follower_id
+		WHERE f.followed_id = ?
+		ORDER BY f.created_at, u.id
+	`, userID)
+}
+
+func getFollows(query string, userID int64) ([]Follow, error) {
+	rows, err := database.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var follows []Follow
+	for rows.Next() {
+		var f Follow
+		if err := rows.Scan(&f.UserID, &f.Username, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		follows = append(follows, f)
+	}
+	return follows, rows.Err()
+}
@@ -0,0 +1,269 @@
+package models
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuuner/spines/internal/database"
+)
+
+// ReadingGoal is a user's target for a year, in books and/or pages. Progress
+// is counted from the books they finished that year.
+type ReadingGoal struct {
+	ID        int64
+	UserID    int64
+	Year      int
+	BooksGoal sql.NullInt64
+	PagesGoal sql.NullInt64
+	ReachedAt sql.NullString
+	// Progress, computed from finished_reading_at in user_books
+	BooksRead int
+	PagesRead int
+}
+
+// GetReadingGoal returns a user's goal for a year with its progress
+func GetReadingGoal(userID int64, year int) (*ReadingGoal, error) {
+	var g ReadingGoal
+	err := database.DB.QueryRow(`
+		SELECT id, user_id, year, books_goal, pages_goal, reached_at
+		FROM reading_goals
+		WHERE user_id = ? AND year = ?
+	`, userID, year).Scan(&g.ID, &g.UserID, &g.Year, &g.BooksGoal, &g.PagesGoal, &g.ReachedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	g.BooksRead, g.PagesRead, err = getYearProgress(database.DB, userID, year)
+	if err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// GetUserReadingGoals returns all of a user's goals, newest year first,
+// without their progress
+func GetUserReadingGoals(userID int64) ([]ReadingGoal, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, user_id, year, books_goal, pages_goal, reached_at
+		FROM reading_goals
+		WHERE user_id = ?
+		ORDER BY year DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var goals []ReadingGoal
+	for rows.Next() {
+		var g ReadingGoal
+		if err := rows.Scan(&g.ID, &g.UserID, &g.Year, &g.BooksGoal, &g.PagesGoal, &g.ReachedAt); err != nil {
+			return nil, err
+		}
+		goals = append(goals, g)
+	}
+	return goals, rows.Err()
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// getYearProgress counts the books and pages a user finished in a year.
+// Books without a known page count add no pages.
+func getYearProgress(db queryRower, userID int64, year int) (books, pages int, err error) {
+	err = db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(b.page_count), 0)
+		FROM user_books ub
+		JOIN books b ON ub.book_id = b.id
+		WHERE ub.user_id = ? AND ub.shelf = 'read' AND strftime('%Y', ub.finished_reading_at) = ?
+	`, userID, strconv.Itoa(year)).Scan(&books, &pages)
+	return books, pages, err
+}
+
+// SetReadingGoal creates or replaces a user's goal for a year. A goal that is
+// already met is marked reached without an event, so only finishing books
+// announces reaching a goal.
+func SetReadingGoal(userID int64, year int, booksGoal, pagesGoal sql.NullInt64) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO reading_goals (user_id, year, books_goal, pages_goal)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id, year) DO UPDATE SET
+			books_goal = excluded.books_goal, pages_goal = excluded.pages_goal, reached_at = NULL
+	`, userID, year, booksGoal, pagesGoal)
+	if err != nil {
+		return err
+	}
+
+	books, pages, err := getYearProgress(tx, userID, year)
+	if err != nil {
+		return err
+	}
+	goal := ReadingGoal{BooksGoal: booksGoal, PagesGoal: pagesGoal, BooksRead: books, PagesRead: pages}
+	if goal.IsReached() {
+		_, err = tx.Exec("UPDATE reading_goals SET reached_at = CURRENT_TIMESTAMP WHERE user_id = ? AND year = ?", userID, year)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteReadingGoal removes a user's goal for a year
+func DeleteReadingGoal(userID int64, year int) error {
+	_, err := database.DB.Exec("DELETE FROM reading_goals WHERE user_id = ? AND year = ?", userID, year)
+	return err
+}
+
+// CheckReadingGoals marks any of the user's goals that have just been met as
+// reached, creating a goal_reached event for each. Call it after books are
+// finished or their finish dates change.
+func CheckReadingGoals(userID int64) error {
+	rows, err := database.DB.Query(`
+		SELECT year FROM reading_goals WHERE user_id = ? AND reached_at IS NULL
+	`, userID)
+	if err != nil {
+		return err
+	}
+	var years []int
+	for rows.Next() {
+		var year int
+		if err := rows.Scan(&year); err != nil {
+			rows.Close()
+			return err
+		}
+		years = append(years, year)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, year := range years {
+		goal, err := GetReadingGoal(userID, year)
+		if err != nil {
+			return err
+		}
+		if !goal.IsReached() {
+			continue
+		}
+		result, err := database.DB.Exec(
+			"UPDATE reading_goals SET reached_at = CURRENT_TIMESTAMP WHERE id = ? AND reached_at IS NULL",
+			goal.ID,
+		)
+		if err != nil {
+			return err
+		}
+		// Another request may have marked it first
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+		if err := CreateGoalReachedEvent(userID, goal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsReached reports whether every target set in the goal has been met
+func (g ReadingGoal) IsReached() bool {
+	if !g.BooksGoal.Valid && !g.PagesGoal.Valid {
+		return false
+	}
+	if g.BooksGoal.Valid && int64(g.BooksRead) < g.BooksGoal.Int64 {
+		return false
+	}
+	if g.PagesGoal.Valid && int64(g.PagesRead) < g.PagesGoal.Int64 {
+		return false
+	}
+	return true
+}
+
+// BooksPercent returns progress towards the books goal (0-100)
+func (g ReadingGoal) BooksPercent() int {
+	return goalPercent(g.BooksRead, g.BooksGoal)
+}
+
+// PagesPercent returns progress towards the pages goal (0-100)
+func (g ReadingGoal) PagesPercent() int {
+	return goalPercent(g.PagesRead, g.PagesGoal)
+}
+
+func goalPercent(done int, goal sql.NullInt64) int {
+	if !goal.Valid || goal.Int64 <= 0 {
+		return 0
+	}
+	percent := int(int64(done) * 100 / goal.Int64)
+	if percent > 100 {
+		return 100
+	}
+	return percent
+}
+
+// yearElapsed returns how much of the goal's year has passed (0-1)
+func (g ReadingGoal) yearElapsed() float64 {
+	now := time.Now()
+	switch {
+	case g.Year < now.Year():
+		return 1
+	case g.Year > now.Year():
+		return 0
+	}
+	start := time.Date(g.Year, 1, 1, 0, 0, 0, 0, now.Location())
+	end := start.AddDate(1, 0, 0)
+	return float64(now.Sub(start)) / float64(end.Sub(start))
+}
+
+// PaceDisplay compares progress with where an even pace through the year would
+// be, e.g. "2 books ahead of schedule". Book goals take precedence over pages.
+func (g ReadingGoal) PaceDisplay() string {
+	if g.IsReached() {
+		return "Goal reached"
+	}
+	done, goal, unit := g.BooksRead, g.BooksGoal, "book"
+	if !goal.Valid {
+		done, goal, unit = g.PagesRead, g.PagesGoal, "page"
+	}
+	if !goal.Valid {
+		return ""
+	}
+
+	diff := int(float64(done) - float64(goal.Int64)*g.yearElapsed())
+	switch {
+	case diff > 0:
+		return pluralize(diff, unit) + " ahead of schedule"
+	case diff < 0:
+		return pluralize(-diff, unit) + " behind schedule"
+	default:
+		return "On track"
+	}
+}
+
+// Summary describes the targets, e.g. "24 books and 8000 pages"
+func (g ReadingGoal) Summary() string {
+	var parts []string
+	if g.BooksGoal.Valid {
+		parts = append(parts, pluralize(int(g.BooksGoal.Int64), "book"))
+	}
+	if g.PagesGoal.Valid {
+		parts = append(parts, pluralize(int(g.PagesGoal.Int64), "page"))
+	}
+	return strings.Join(parts, " and ")
+}
+
+func pluralize(count int, unit string) string {
+	if count == 1 {
+		return "1 " + unit
+	}
+	return strconv.Itoa(count) + " " + unit + "s"
+}
@@ -0,0 +1,418 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/nuuner/spines/internal/database"
+)
+
+// Import job statuses
+const (
+	ImportMapping = "mapping" // uploaded, waiting for the user to map columns
+	ImportPending = "pending"
+	ImportRunning = "running"
+	ImportReview  = "review" // matched and waiting for the user to confirm
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+)
+
+// Import row statuses
+const (
+	ImportRowPending   = "pending"
+	ImportRowMatched   = "matched" // book found, not shelved yet
+	ImportRowImported  = "imported"
+	ImportRowUnmatched = "unmatched"
+	ImportRowSkipped   = "skipped"
+	ImportRowFailed    = "failed"
+)
+
+// ImportSources maps the supported import formats to their display names
+var ImportSources = map[string]string{
+	"goodreads":  "Goodreads",
+	"storygraph": "The StoryGraph",
+	"csv":        "Other CSV file",
+	"calibre":    "Calibre library",
+}
+
+// ImportEntry is a book read from an import file, normalised from whichever
+// format it came in. Dates are stored as "2006-01-02 15:04:05".
+type ImportEntry struct {
+	// Line in the source file, for the report
+	Line         int      `json:"line"`
+	Title        string   `json:"title"`
+	Authors      string   `json:"authors,omitempty"`
+	ISBN13       string   `json:"isbn_13,omitempty"`
+	ISBN10       string   `json:"isbn_10,omitempty"`
+	PageCount    int      `json:"page_count,omitempty"`
+	Shelf        string   `json:"shelf"`
+	Rating       int64    `json:"rating,omitempty"` // in points (see rating.go), 0 for none
+	DateAdded    string   `json:"date_added,omitempty"`
+	DateStarted  string   `json:"date_started,omitempty"`
+	DateFinished string   `json:"date_finished,omitempty"`
+	Format       string   `json:"format,omitempty"`
+	Owned        bool     `json:"owned,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	// Cover image inside a local Calibre library, relative to the library
+	Cover string `json:"cover,omitempty"`
+}
+
+// ShelfDisplay returns the name of the shelf the entry goes on
+func (e ImportEntry) ShelfDisplay() string {
+	for _, shelf := range statsShelves {
+		if shelf.value == e.Shelf {
+			return shelf.label
+		}
+	}
+	return e.Shelf
+}
+
+// RatingDisplay returns the entry's rating in the configured scale, or "" if
+// it has none or won't keep it (only read books are rated)
+func (e ImportEntry) RatingDisplay() string {
+	if e.Shelf != "read" || !IsValidRating(e.Rating) {
+		return ""
+	}
+	return FormatRating(e.Rating)
+}
+
+// FinishedDisplay returns when the entry was finished, e.g. "Mar 5, 2025"
+func (e ImportEntry) FinishedDisplay() string {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", e.DateFinished, time.Local)
+	if err != nil {
+		return ""
+	}
+	return t.Format("Jan 2, 2006")
+}
+
+// ImportJob is a file of books being imported into a user's shelves in the
+// background. Rows are matched to books and shelved one at a time, or, for
+// jobs under review, all matched first and shelved once the user confirms.
+type ImportJob struct {
+	ID         int64
+	UserID     int64
+	Source     string
+	Filename   string
+	Status     string
+	Error      sql.NullString
+	CreatedAt  time.Time
+	FinishedAt sql.NullString
+	// Whether rows are held after matching until the user confirms them
+	Review bool
+	// Whether to skip the activity event for the books imported
+	Quiet bool
+	// Row counts by status
+	Total     int
+	Pending   int
+	Matched   int
+	Imported  int
+	Unmatched int
+	Skipped   int
+	Failed    int
+}
+
+// ImportRow is one entry of an import job and what became of it
+type ImportRow struct {
+	ID      int64
+	JobID   int64
+	Line    int
+	Entry   ImportEntry
+	Status  string
+	BookID  sql.NullInt64
+	Message sql.NullString
+	// The matched book's title, authors and Google Books ID, if any
+	Book *Book
+}
+
+// importJobColumns selects an import job aliased as j with its row counts
+const importJobColumns = `j.id, j.user_id, j.source, j.filename, j.status, j.error, j.created_at, j.finished_at, j.review, j.quiet,
+	(SELECT COUNT(*) FROM import_rows r WHERE r.job_id = j.id),
+	(SELECT COUNT(*) FROM import_rows r WHERE r.job_id = j.id AND r.status = 'pending'),
+	(SELECT COUNT(*) FROM import_rows r WHERE r.job_id = j.id AND r.status = 'matched'),
+	(SELECT COUNT(*) FROM import_rows r WHERE r.job_id = j.id AND r.status = 'imported'),
+	(SELECT COUNT(*) FROM import_rows r WHERE r.job_id = j.id AND r.status = 'unmatched'),
+	(SELECT COUNT(*) FROM import_rows r WHERE r.job_id = j.id AND r.status = 'skipped'),
+	(SELECT COUNT(*) FROM import_rows r WHERE r.job_id = j.id AND r.status = 'failed')`
+
+// scanFields returns scan destinations matching importJobColumns
+func (j *ImportJob) scanFields() []interface{} {
+	return []interface{}{
+		&j.ID, &j.UserID, &j.Source, &j.Filename, &j.Status, &j.Error, &j.CreatedAt, &j.FinishedAt, &j.Review, &j.Quiet,
+		&j.Total, &j.Pending, &j.Matched, &j.Imported, &j.Unmatched, &j.Skipped, &j.Failed,
+	}
+}
+
+// CreateImportJob stores a pending import job with its entries. With review
+// set, the job stops once its rows are matched, see ConfirmImportJob.
+func CreateImportJob(userID int64, source, filename string, entries []ImportEntry, review, quiet bool) (int64, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"INSERT INTO import_jobs (user_id, source, filename, review, quiet) VALUES (?, ?, ?, ?, ?)",
+		userID, source, filename, review, quiet,
+	)
+	if err != nil {
+		return 0, err
+	}
+	jobID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := insertImportRows(tx, jobID, entries); err != nil {
+		return 0, err
+	}
+
+	return jobID, tx.Commit()
+}
+
+// CreateImportUpload stores a file whose columns the user still has to map,
+// see AddImportRows. Its rows are always reviewed before being shelved.
+func CreateImportUpload(userID int64, source, filename, upload string, quiet bool) (int64, error) {
+	result, err := database.DB.Exec(
+		"INSERT INTO import_jobs (user_id, source, filename, status, review, quiet, upload) VALUES (?, ?, ?, 'mapping', 1, ?, ?)",
+		userID, source, filename, quiet, upload,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// GetImportUpload returns the file stored for a job waiting to be mapped
+func GetImportUpload(jobID int64) (string, error) {
+	var upload string
+	err := database.DB.QueryRow(
+		"SELECT upload FROM import_jobs WHERE id = ? AND status = 'mapping' AND upload IS NOT NULL",
+		jobID,
+	).Scan(&upload)
+	return upload, err
+}
+
+// AddImportRows stores the entries read from a mapped upload and queues the
+// job, dropping the upload
+func AddImportRows(jobID int64, entries []ImportEntry) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertImportRows(tx, jobID, entries); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE import_jobs SET status = 'pending', upload = NULL WHERE id = ? AND status = 'mapping'", jobID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertImportRows(tx *sql.Tx, jobID int64, entries []ImportEntry) error {
+	stmt, err := tx.Prepare("INSERT INTO import_rows (job_id, line, data) VALUES (?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(jobID, entry.Line, string(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetImportJob returns one of a user's import jobs
+func GetImportJob(userID, jobID int64) (*ImportJob, error) {
+	var j ImportJob
+	err := database.DB.QueryRow(`
+		SELECT `+importJobColumns+`
+		FROM import_jobs j
+		WHERE j.id = ? AND j.user_id = ?
+	`, jobID, userID).Scan(j.scanFields()...)
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// GetImportJobs returns a user's most recent import jobs, newest first
+func GetImportJobs(userID int64, limit int) ([]ImportJob, error) {
+	return queryImportJobs(`
+		SELECT `+importJobColumns+`
+		FROM import_jobs j
+		WHERE j.user_id = ?
+		ORDER BY j.created_at DESC, j.id DESC
+		LIMIT ?
+	`, userID, limit)
+}
+
+// GetUnfinishedImportJobs returns jobs that are waiting or were interrupted,
+// oldest first, so they can be picked up again after a restart
+func GetUnfinishedImportJobs() ([]ImportJob, error) {
+	return queryImportJobs(`
+		SELECT ` + importJobColumns + `
+		FROM import_jobs j
+		WHERE j.status IN ('pending', 'running')
+		ORDER BY j.id
+	`)
+}
+
+func queryImportJobs(query string, args ...interface{}) ([]ImportJob, error) {
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []ImportJob
+	for rows.Next() {
+		var j ImportJob
+		if err := rows.Scan(j.scanFields()...); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// SetImportJobStatus updates a job's status, recording when it finished and
+// why it failed where that applies
+func SetImportJobStatus(jobID int64, status, errMessage string) error {
+	_, err := database.DB.Exec(`
+		UPDATE import_jobs
+		SET status = ?, error = ?,
+			finished_at = CASE WHEN ? IN ('done', 'failed') THEN CURRENT_TIMESTAMP ELSE NULL END
+		WHERE id = ?
+	`, status, sql.NullString{String: errMessage, Valid: errMessage != ""}, status, jobID)
+	return err
+}
+
+// importRowColumns selects an import row aliased as r with its matched book
+// aliased as b
+const importRowColumns = `r.id, r.job_id, r.line, r.data, r.status, r.book_id, r.message, b.title, b.authors, b.google_books_id`
+
+// scanImportRow reads a row selected with importRowColumns from sql.Row or sql.Rows
+func scanImportRow(scanner interface{ Scan(...interface{}) error }) (*ImportRow, error) {
+	var r ImportRow
+	var data string
+	var title, authors, googleBooksID sql.NullString
+	err := scanner.Scan(&r.ID, &r.JobID, &r.Line, &data, &r.Status, &r.BookID, &r.Message, &title, &authors, &googleBooksID)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(data), &r.Entry); err != nil {
+		return nil, err
+	}
+	if r.BookID.Valid && title.Valid {
+		r.Book = &Book{ID: r.BookID.Int64, Title: title.String, Authors: authors.String, GoogleBooksID: googleBooksID.String}
+	}
+	return &r, nil
+}
+
+// GetImportRows returns a job's rows in file order, only those with the
+// given status unless it is empty
+func GetImportRows(jobID int64, status string) ([]ImportRow, error) {
+	query := "SELECT " + importRowColumns + " FROM import_rows r LEFT JOIN books b ON b.id = r.book_id WHERE r.job_id = ?"
+	args := []interface{}{jobID}
+	if status != "" {
+		query += " AND r.status = ?"
+		args = append(args, status)
+	}
+	rows, err := database.DB.Query(query+" ORDER BY r.line, r.id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []ImportRow
+	for rows.Next() {
+		r, err := scanImportRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *r)
+	}
+	return result, rows.Err()
+}
+
+// GetImportRow returns one row of a job
+func GetImportRow(jobID, rowID int64) (*ImportRow, error) {
+	return scanImportRow(database.DB.QueryRow(
+		"SELECT "+importRowColumns+" FROM import_rows r LEFT JOIN books b ON b.id = r.book_id WHERE r.id = ? AND r.job_id = ?",
+		rowID, jobID,
+	))
+}
+
+// SetImportRowResult records what became of a row
+func SetImportRowResult(rowID int64, status string, bookID sql.NullInt64, message string) error {
+	_, err := database.DB.Exec(
+		"UPDATE import_rows SET status = ?, book_id = ?, message = ? WHERE id = ?",
+		status, bookID, sql.NullString{String: message, Valid: message != ""}, rowID,
+	)
+	return err
+}
+
+// ConfirmImportJob releases a reviewed job's matched rows to be shelved
+func ConfirmImportJob(jobID int64) error {
+	_, err := database.DB.Exec(
+		"UPDATE import_jobs SET status = 'pending', review = 0 WHERE id = ? AND status = 'review'",
+		jobID,
+	)
+	return err
+}
+
+// DeleteImportJob removes one of a user's import jobs and its rows. Books
+// already shelved by it stay on the shelves.
+func DeleteImportJob(userID, jobID int64) error {
+	_, err := database.DB.Exec("DELETE FROM import_jobs WHERE id = ? AND user_id = ?", jobID, userID)
+	return err
+}
+
+// SourceDisplay returns the name of the format the job imports
+func (j ImportJob) SourceDisplay() string {
+	if name, ok := ImportSources[j.Source]; ok {
+		return name
+	}
+	return j.Source
+}
+
+// Processed returns how many rows have been dealt with. For a job under
+// review that means matched; otherwise matched rows are still to be shelved.
+func (j ImportJob) Processed() int {
+	if j.Review {
+		return j.Total - j.Pending
+	}
+	return j.Total - j.Pending - j.Matched
+}
+
+// Percent returns how far through its rows the job is (0-100)
+func (j ImportJob) Percent() int {
+	if j.Total == 0 {
+		return 100
+	}
+	return j.Processed() * 100 / j.Total
+}
+
+// IsFinished reports whether the job has stopped, successfully or not
+func (j ImportJob) IsFinished() bool {
+	return j.Status == ImportDone || j.Status == ImportFailed
+}
+
+// IsWorking reports whether the job is queued or running in the background
+func (j ImportJob) IsWorking() bool {
+	return j.Status == ImportPending || j.Status == ImportRunning
+}
+
+// CreatedAtDisplay returns when the job was started, e.g. "Mar 5, 2025"
+func (j ImportJob) CreatedAtDisplay() string {
+	return j.CreatedAt.Format("Jan 2, 2006")
+}
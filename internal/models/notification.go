@@ -0,0 +1,125 @@
+package models
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/nuuner/spines/internal/database"
+)
+
+// Notification kinds
+const (
+	NotificationReaction = "reaction"
+	NotificationComment  = "comment"
+	NotificationReply    = "reply"
+)
+
+// Notification tells a user someone reacted to or commented on their
+// activity, or replied to their comment
+type Notification struct {
+	ID        int64
+	UserID    int64
+	Kind      string
+	EventID   int64
+	CommentID sql.NullInt64
+	Unread    bool
+	CreatedAt time.Time
+	// Joined data
+	Actor     *User
+	BookTitle sql.NullString
+}
+
+// Text describes what happened, without the actor's name
+func (n Notification) Text() string {
+	var text string
+	switch n.Kind {
+	case NotificationReaction:
+		text = "reacted to your activity"
+	case NotificationComment:
+		text = "commented on your activity"
+	case NotificationReply:
+		text = "replied to your comment"
+	}
+	if n.BookTitle.Valid {
+		text += " about \"" + n.BookTitle.String + "\""
+	}
+	return text
+}
+
+// URL links to where it happened
+func (n Notification) URL() string {
+	url := "/events/" + strconv.FormatInt(n.EventID, 10)
+	if n.CommentID.Valid {
+		url += "#comment-" + strconv.FormatInt(n.CommentID.Int64, 10)
+	}
+	return url
+}
+
+// TimeAgo returns a human-readable relative time string
+func (n Notification) TimeAgo() string {
+	return timeAgo(n.CreatedAt)
+}
+
+// createNotification notifies userID of what actorID did, using db, which
+// may be a transaction. commentID is 0 for reactions.
+func createNotification(db execer, userID, actorID int64, kind string, eventID, commentID int64) error {
+	_, err := db.Exec(
+		"INSERT INTO notifications (user_id, actor_id, kind, event_id, comment_id) VALUES (?, ?, ?, ?, ?)",
+		userID, actorID, kind, eventID, sql.NullInt64{Int64: commentID, Valid: commentID != 0},
+	)
+	return err
+}
+
+// GetNotifications returns a user's newest notifications
+func GetNotifications(userID int64, limit int) ([]Notification, error) {
+	rows, err := database.DB.Query(`
+		SELECT n.id, n.user_id, n.kind, n.event_id, n.comment_id, n.read_at IS NULL, n.created_at,
+		       u.id, u.username, u.display_name, u.description, u.password_hash, u.profile_picture, COALESCE(u.theme, 'light'), u.created_at,
+		       b.title
+		FROM notifications n
+		INNER JOIN users u ON n.actor_id = u.id
+		INNER JOIN events e ON n.event_id = e.id
+		LEFT JOIN books b ON e.book_id = b.id
+		WHERE n.user_id = ?
+		ORDER BY n.id DESC
+		LIMIT ?
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		var n Notification
+		var u User
+		if err := rows.Scan(
+			&n.ID, &n.UserID, &n.Kind, &n.EventID, &n.CommentID, &n.Unread, &n.CreatedAt,
+			&u.ID, &u.Username, &u.DisplayName, &u.Description, &u.PasswordHash, &u.ProfilePicture, &u.Theme, &u.CreatedAt,
+			&n.BookTitle,
+		); err != nil {
+			return nil, err
+		}
+		n.Actor = &u
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// CountUnreadNotifications returns how many notifications a user hasn't seen
+func CountUnreadNotifications(userID int64) (int, error) {
+	var count int
+	err := database.DB.QueryRow(
+		"SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL", userID,
+	).Scan(&count)
+	return count, err
+}
+
+// MarkNotificationsRead marks all of a user's notifications as seen
+func MarkNotificationsRead(userID int64) error {
+	_, err := database.DB.Exec(
+		"UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE user_id = ? AND read_at IS NULL", userID,
+	)
+	return err
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"database/sql"
+
+	"github.com/nuuner/spines/internal/database"
+)
+
+// FormatOption is a book format a user can record for their copy
+type FormatOption struct {
+	Value string
+	Label string
+}
+
+// BookFormats lists the supported formats in display order
+var BookFormats = []FormatOption{
+	{Value: "hardcover", Label: "Hardcover"},
+	{Value: "paperback", Label: "Paperback"},
+	{Value: "ebook", Label: "Ebook"},
+	{Value: "audiobook", Label: "Audiobook"},
+	{Value: "library_loan", Label: "Library loan"},
+}
+
+// IsValidFormat checks that a format is one of BookFormats
+func IsValidFormat(format string) bool {
+	for _, f := range BookFormats {
+		if f.Value == format {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateUserBookOwnership sets the format and ownership of a user's book,
+// independently of the shelf it is on. NULL values mean "not recorded".
+func UpdateUserBookOwnership(userID, bookID int64, format sql.NullString, owned sql.NullBool) error {
+	_, err := database.DB.Exec(
+		"UPDATE user_books SET format = ?, owned = ? WHERE user_id = ? AND book_id = ?",
+		format, owned, userID, bookID,
+	)
+	return err
+}
+
+// FormatDisplay returns a human-readable version of the format
+func (ub UserBook) FormatDisplay() string {
+	if !ub.Format.Valid {
+		return ""
+	}
+	for _, f := range BookFormats {
+		if f.Value == ub.Format.String {
+			return f.Label
+		}
+	}
+	return ub.Format.String
+}
+
+// OwnershipDisplay returns "Owned", "Not owned" or "" when unknown
+func (ub UserBook) OwnershipDisplay() string {
+	if !ub.Owned.Valid {
+		return ""
+	}
+	if ub.Owned.Bool {
+		return "Owned"
+	}
+	return "Not owned"
+}
+
+// OwnedValue returns ownership as a form value: "1", "0" or "" when unknown
+func (ub UserBook) OwnedValue() string {
+	return ShelfFilter{Owned: ub.Owned}.OwnedParam()
+}
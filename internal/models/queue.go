@@ -0,0 +1,84 @@
+package models
+
+import (
+	"github.com/nuuner/spines/internal/database"
+)
+
+// queueOrder sorts user_books aliased as ub by reading queue position.
+// Books without a position (every shelf but want to read) sort last.
+const queueOrder = "ub.queue_position IS NULL, ub.queue_position"
+
+// nextQueuePosition is a subquery giving the position after the last book in
+// a user's reading queue. It takes the user ID as its only argument.
+const nextQueuePosition = `(SELECT COALESCE(MAX(q.queue_position), 0) + 1
+		FROM user_books q WHERE q.user_id = ? AND q.shelf = 'want_to_read')`
+
+// ReorderQueue rewrites the positions of a user's want to read books. The
+// given books come first in that order; any other books in the queue keep
+// their relative order after them. Book IDs not in the queue are ignored.
+func ReorderQueue(userID int64, bookIDs []int64) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT ub.book_id FROM user_books ub
+		WHERE ub.user_id = ? AND ub.shelf = 'want_to_read'
+		ORDER BY `+queueOrder+`, COALESCE(ub.added_at, '1970-01-01') DESC
+	`, userID)
+	if err != nil {
+		return err
+	}
+	var current []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		current = append(current, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	inQueue := make(map[int64]bool, len(current))
+	for _, id := range current {
+		inQueue[id] = true
+	}
+
+	order := make([]int64, 0, len(current))
+	placed := make(map[int64]bool, len(current))
+	for _, id := range bookIDs {
+		if inQueue[id] && !placed[id] {
+			order = append(order, id)
+			placed[id] = true
+		}
+	}
+	for _, id := range current {
+		if !placed[id] {
+			order = append(order, id)
+		}
+	}
+
+	stmt, err := tx.Prepare("UPDATE user_books SET queue_position = ? WHERE user_id = ? AND book_id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for i, id := range order {
+		if _, err := stmt.Exec(i+1, userID, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// MoveToTopOfQueue makes a want to read book the next one in the user's queue
+func MoveToTopOfQueue(userID, bookID int64) error {
+	return ReorderQueue(userID, []int64{bookID})
+}
@@ -0,0 +1,202 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/nuuner/spines/internal/database"
+)
+
+// Quote is a passage a user saved from one of their shelved books
+type Quote struct {
+	ID         int64
+	UserBookID int64
+	Text       string
+	Page       sql.NullInt64
+	Comment    sql.NullString
+	IsPublic   bool
+	CreatedAt  time.Time
+	// Joined data
+	Book *Book
+}
+
+// BookNotes holds a user's private notes for one of their shelved books
+type BookNotes struct {
+	UserBookID int64
+	Notes      string
+	Book       *Book
+}
+
+// GetUserBookNotes returns the private notes for a user's book (empty if none)
+func GetUserBookNotes(userBookID int64) (string, error) {
+	var notes sql.NullString
+	err := database.DB.QueryRow("SELECT notes FROM user_books WHERE id = ?", userBookID).Scan(&notes)
+	if err != nil {
+		return "", err
+	}
+	return notes.String, nil
+}
+
+// UpdateUserBookNotes replaces the private notes for a user's book
+func UpdateUserBookNotes(userID, bookID int64, notes string) error {
+	var nullNotes sql.NullString
+	if notes != "" {
+		nullNotes = sql.NullString{String: notes, Valid: true}
+	}
+	_, err := database.DB.Exec(
+		"UPDATE user_books SET notes = ? WHERE user_id = ? AND book_id = ?",
+		nullNotes, userID, bookID,
+	)
+	return err
+}
+
+// GetBookQuotes returns the quotes saved for a user's book, in page order
+func GetBookQuotes(userBookID int64) ([]Quote, error) {
+	rows, err := database.DB.Query(`
+		SELECT id, user_book_id, text, page, comment, is_public, created_at
+		FROM quotes
+		WHERE user_book_id = ?
+		ORDER BY page IS NULL, page, created_at
+	`, userBookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var quotes []Quote
+	for rows.Next() {
+		var q Quote
+		if err := rows.Scan(&q.ID, &q.UserBookID, &q.Text, &q.Page, &q.Comment, &q.IsPublic, &q.CreatedAt); err != nil {
+			return nil, err
+		}
+		quotes = append(quotes, q)
+	}
+	return quotes, rows.Err()
+}
+
+// CreateQuote saves a new private quote for a user's book
+func CreateQuote(userBookID int64, text string, page sql.NullInt64, comment sql.NullString) (int64, error) {
+	result, err := database.DB.Exec(
+		"INSERT INTO quotes (user_book_id, text, page, comment) VALUES (?, ?, ?, ?)",
+		userBookID, text, page, comment,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// SetQuotePublic shows or hides one of a user's quotes on their public page
+func SetQuotePublic(userID, quoteID int64, public bool) error {
+	_, err := database.DB.Exec(`
+		UPDATE quotes SET is_public = ?
+		WHERE id = ? AND user_book_id IN (SELECT id FROM user_books WHERE user_id = ?)
+	`, public, quoteID, userID)
+	return err
+}
+
+// DeleteQuote removes one of a user's quotes
+func DeleteQuote(userID, quoteID int64) error {
+	_, err := database.DB.Exec(`
+		DELETE FROM quotes
+		WHERE id = ? AND user_book_id IN (SELECT id FROM user_books WHERE user_id = ?)
+	`, quoteID, userID)
+	return err
+}
+
+// SearchUserQuotes finds a user's quotes whose text, comment or book matches the query.
+// An empty query returns the most recently saved quotes.
+func SearchUserQuotes(userID int64, query string, limit int) ([]Quote, error) {
+	pattern := "%" + escapeLike(strings.TrimSpace(query)) + "%"
+	rows, err := database.DB.Query(`
+		SELECT q.id, q.user_book_id, q.text, q.page, q.comment, q.is_public, q.created_at,
+		       `+bookColumns+`
+		FROM quotes q
+		JOIN user_books ub ON q.user_book_id = ub.id
+		JOIN books b ON ub.book_id = b.id
+		WHERE ub.user_id = ?
+		  AND (q.text LIKE ? ESCAPE '\' OR q.comment LIKE ? ESCAPE '\' OR b.title LIKE ? ESCAPE '\' OR b.authors LIKE ? ESCAPE '\')
+		ORDER BY q.created_at DESC
+		LIMIT ?
+	`, userID, pattern, pattern, pattern, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanQuotesWithBooks(rows)
+}
+
+// SearchUserNotes finds a user's books whose private notes match the query
+func SearchUserNotes(userID int64, query string, limit int) ([]BookNotes, error) {
+	pattern := "%" + escapeLike(strings.TrimSpace(query)) + "%"
+	rows, err := database.DB.Query(`
+		SELECT ub.id, ub.notes,
+		       `+bookColumns+`
+		FROM user_books ub
+		JOIN books b ON ub.book_id = b.id
+		WHERE ub.user_id = ? AND ub.notes IS NOT NULL AND ub.notes LIKE ? ESCAPE '\'
+		ORDER BY b.title
+		LIMIT ?
+	`, userID, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []BookNotes
+	for rows.Next() {
+		var n BookNotes
+		var b Book
+		if err := rows.Scan(append([]interface{}{&n.UserBookID, &n.Notes}, b.scanFields()...)...); err != nil {
+			return nil, err
+		}
+		n.Book = &b
+		results = append(results, n)
+	}
+	return results, rows.Err()
+}
+
+// GetPublicQuotes returns the quotes a user chose to show on their public page
+func GetPublicQuotes(userID int64, limit int) ([]Quote, error) {
+	rows, err := database.DB.Query(`
+		SELECT q.id, q.user_book_id, q.text, q.page, q.comment, q.is_public, q.created_at,
+		       `+bookColumns+`
+		FROM quotes q
+		JOIN user_books ub ON q.user_book_id = ub.id
+		JOIN books b ON ub.book_id = b.id
+		WHERE ub.user_id = ? AND q.is_public = 1
+		ORDER BY q.created_at DESC
+		LIMIT ?
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanQuotesWithBooks(rows)
+}
+
+// scanQuotesWithBooks is a helper to scan quote rows joined with their book
+func scanQuotesWithBooks(rows *sql.Rows) ([]Quote, error) {
+	var quotes []Quote
+	for rows.Next() {
+		var q Quote
+		var b Book
+		dest := []interface{}{&q.ID, &q.UserBookID, &q.Text, &q.Page, &q.Comment, &q.IsPublic, &q.CreatedAt}
+		if err := rows.Scan(append(dest, b.scanFields()...)...); err != nil {
+			return nil, err
+		}
+		q.Book = &b
+		quotes = append(quotes, q)
+	}
+	return quotes, rows.Err()
+}
+
+// escapeLike escapes LIKE wildcards so user input matches literally
+func escapeLike(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "%", `\%`)
+	return strings.ReplaceAll(s, "_", `\_`)
+}
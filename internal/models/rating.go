@@ -0,0 +1,69 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Ratings are stored as points from 1 to 10. On the default 5-star scale every
+// point is half a star; instances can instead present them as a 10-point scale.
+const MaxRatingPoints = 10
+
+// RatingScale is the scale ratings are entered and displayed in (5 or 10)
+var RatingScale = 5
+
+// RatingOption is a selectable rating value for forms
+type RatingOption struct {
+	Value int
+	Label string
+}
+
+// SetRatingScale configures the rating scale, falling back to 5 stars for unsupported values
+func SetRatingScale(scale int) {
+	if scale == 10 {
+		RatingScale = 10
+		return
+	}
+	RatingScale = 5
+}
+
+// IsValidRating checks that rating points are within the stored range
+func IsValidRating(points int64) bool {
+	return points >= 1 && points <= MaxRatingPoints
+}
+
+// RatingOptions returns all selectable ratings in the configured scale, lowest first
+func RatingOptions() []RatingOption {
+	options := make([]RatingOption, 0, MaxRatingPoints)
+	for points := 1; points <= MaxRatingPoints; points++ {
+		options = append(options, RatingOption{Value: points, Label: FormatRating(int64(points))})
+	}
+	return options
+}
+
+// FormatRating returns rating points as text in the configured scale
+func FormatRating(points int64) string {
+	if RatingScale == 10 {
+		return fmt.Sprintf("%d/10", points)
+	}
+	if points == 2 {
+		return "1 star"
+	}
+	return strconv.FormatFloat(float64(points)/2, 'f', -1, 64) + " stars"
+}
+
+// ratingStars splits rating points into five star states: "full", "half" or "empty"
+func ratingStars(points int64) []string {
+	stars := make([]string, 5)
+	for i := range stars {
+		switch {
+		case points >= int64(i+1)*2:
+			stars[i] = "full"
+		case points == int64(i)*2+1:
+			stars[i] = "half"
+		default:
+			stars[i] = "empty"
+		}
+	}
+	return stars
+}
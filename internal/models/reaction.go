@@ -0,0 +1,195 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/nuuner/spines/internal/database"
+)
+
+// ReactionEmoji lists the reactions offered on events, the first being a
+// plain like
+var ReactionEmoji = []string{"❤️", "👍", "😂", "😮", "📚"}
+
+// IsReactionEmoji reports whether emoji is one of the offered reactions
+func IsReactionEmoji(emoji string) bool {
+	for _, e := range ReactionEmoji {
+		if e == emoji {
+			return true
+		}
+	}
+	return false
+}
+
+// ReactionCount is how many users reacted to an event with an emoji
+type ReactionCount struct {
+	Emoji string
+	Count int
+	// Whether the current user is one of them
+	Reacted bool
+}
+
+// Reaction is a user's reaction to an event
+type Reaction struct {
+	EventID   int64
+	Emoji     string
+	CreatedAt time.Time
+}
+
+// ToggleReaction adds a user's reaction to an event, or takes it back if
+// they already reacted with that emoji. The event's owner is notified the
+// first time a user reacts to it.
+func ToggleReaction(event *Event, userID int64, emoji string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var before int
+	if err := tx.QueryRow(
+		"SELECT COUNT(*) FROM event_reactions WHERE event_id = ? AND user_id = ?", event.ID, userID,
+	).Scan(&before); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(
+		"DELETE FROM event_reactions WHERE event_id = ? AND user_id = ? AND emoji = ?",
+		event.ID, userID, emoji,
+	)
+	if err != nil {
+		return err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if removed == 0 {
+		if _, err := tx.Exec(
+			"INSERT INTO event_reactions (event_id, user_id, emoji) VALUES (?, ?, ?)",
+			event.ID, userID, emoji,
+		); err != nil {
+			return err
+		}
+		if before == 0 && event.UserID != userID {
+			if err := createNotification(tx, event.UserID, userID, NotificationReaction, event.ID, 0); err != nil {
+				return err
+			}
+		}
+	} else if before == 1 {
+		// Taking back their only reaction takes back the notification too,
+		// unless it has been seen
+		if _, err := tx.Exec(`
+			DELETE FROM notifications
+			WHERE user_id = ? AND actor_id = ? AND kind = ? AND event_id = ? AND read_at IS NULL
+		`, event.UserID, userID, NotificationReaction, event.ID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetEventReactions returns the count of each offered reaction on an event,
+// in offered order, marking those userID reacted with
+func GetEventReactions(eventID, userID int64) ([]ReactionCount, error) {
+	rows, err := database.DB.Query(`
+		SELECT emoji, COUNT(*), MAX(user_id = ?)
+		FROM event_reactions
+		WHERE event_id = ?
+		GROUP BY emoji
+	`, userID, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[string]ReactionCount)
+	for rows.Next() {
+		var r ReactionCount
+		if err := rows.Scan(&r.Emoji, &r.Count, &r.Reacted); err != nil {
+			return nil, err
+		}
+		found[r.Emoji] = r
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	reactions := make([]ReactionCount, len(ReactionEmoji))
+	for i, emoji := range ReactionEmoji {
+		reactions[i] = found[emoji]
+		reactions[i].Emoji = emoji
+	}
+	return reactions, nil
+}
+
+// LoadEventCounts fills in the reaction and comment counts of events, for
+// showing in activity feeds
+func LoadEventCounts(events []Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, len(events))
+	args := make([]interface{}, len(events))
+	index := make(map[int64]int, len(events))
+	for i, ev := range events {
+		placeholders[i] = "?"
+		args[i] = ev.ID
+		index[ev.ID] = i
+	}
+	in := strings.Join(placeholders, ",")
+
+	rows, err := database.DB.Query(`
+		SELECT event_id, 'reaction', COUNT(*) FROM event_reactions
+		WHERE event_id IN (`+in+`) GROUP BY event_id
+		UNION ALL
+		SELECT event_id, 'comment', COUNT(*) FROM event_comments
+		WHERE event_id IN (`+in+`) AND removed IS NULL GROUP BY event_id
+	`, append(args, args...)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var eventID int64
+		var kind string
+		var count int
+		if err := rows.Scan(&eventID, &kind, &count); err != nil {
+			return err
+		}
+		if kind == "reaction" {
+			events[index[eventID]].ReactionCount = count
+		} else {
+			events[index[eventID]].CommentCount = count
+		}
+	}
+	return rows.Err()
+}
+
+// GetUserReactions returns every reaction a user has given, newest first
+func GetUserReactions(userID int64) ([]Reaction, error) {
+	rows, err := database.DB.Query(`
+		SELECT event_id, emoji, created_at
+		FROM event_reactions
+		WHERE user_id = ?
+		ORDER BY created_at DESC, event_id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reactions []Reaction
+	for rows.Next() {
+		var r Reaction
+		if err := rows.Scan(&r.EventID, &r.Emoji, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		reactions = append(reactions, r)
+	}
+	return reactions, rows.Err()
+}
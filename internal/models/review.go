@@ -0,0 +1,106 @@
+package models
+
+import (
+	"html/template"
+	"time"
+
+	"github.com/nuuner/spines/internal/database"
+	"github.com/nuuner/spines/internal/markdown"
+)
+
+// Review is a user's written review of a shelved book, stored as Markdown
+type Review struct {
+	ID         int64
+	UserBookID int64
+	Body       string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	// Joined data
+	UserBook *UserBook
+}
+
+// GetReview returns the review for a user's book
+func GetReview(userBookID int64) (*Review, error) {
+	var r Review
+	err := database.DB.QueryRow(`
+		SELECT id, user_book_id, body, created_at, updated_at
+		FROM reviews
+		WHERE user_book_id = ?
+	`, userBookID).Scan(&r.ID, &r.UserBookID, &r.Body, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// SaveReview creates or updates the review for a user's book.
+// Returns true if a new review was created. Only the save that inserts the
+// row reports it, so of two saved at once only one counts as new.
+func SaveReview(userBookID int64, body string) (bool, error) {
+	result, err := database.DB.Exec(`
+		INSERT INTO reviews (user_book_id, body) VALUES (?, ?)
+		ON CONFLICT(user_book_id) DO NOTHING
+	`, userBookID, body)
+	if err != nil {
+		return false, err
+	}
+	if created, err := result.RowsAffected(); err != nil || created > 0 {
+		return created > 0, err
+	}
+
+	_, err = database.DB.Exec(
+		"UPDATE reviews SET body = ?, updated_at = CURRENT_TIMESTAMP WHERE user_book_id = ?",
+		body, userBookID,
+	)
+	return false, err
+}
+
+// DeleteReview removes the review for a user's book
+func DeleteReview(userBookID int64) error {
+	_, err := database.DB.Exec("DELETE FROM reviews WHERE user_book_id = ?", userBookID)
+	return err
+}
+
+// GetUserReviews returns a user's most recently written reviews with their books
+func GetUserReviews(userID int64, limit int) ([]Review, error) {
+	rows, err := database.DB.Query(`
+		SELECT r.id, r.user_book_id, r.body, r.created_at, r.updated_at,
+		       `+userBookColumns+`, `+bookColumns+`
+		FROM reviews r
+		JOIN user_books ub ON r.user_book_id = ub.id
+		JOIN books b ON ub.book_id = b.id
+		WHERE ub.user_id = ?
+		ORDER BY r.created_at DESC
+		LIMIT ?
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reviews []Review
+	for rows.Next() {
+		var r Review
+		var ub UserBook
+		var b Book
+		dest := []interface{}{&r.ID, &r.UserBookID, &r.Body, &r.CreatedAt, &r.UpdatedAt}
+		dest = append(dest, ub.scanFields()...)
+		if err := rows.Scan(append(dest, b.scanFields()...)...); err != nil {
+			return nil, err
+		}
+		ub.Book = &b
+		r.UserBook = &ub
+		reviews = append(reviews, r)
+	}
+	return reviews, rows.Err()
+}
+
+// BodyHTML returns the review rendered from Markdown, with spoilers hidden
+func (r Review) BodyHTML() template.HTML {
+	return markdown.Render(r.Body)
+}
+
+// CreatedAtDisplay returns the review date formatted for display (e.g., "Jan 15, 2026")
+func (r Review) CreatedAtDisplay() string {
+	return r.CreatedAt.Format("Jan 2, 2006")
+}
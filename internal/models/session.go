@@ -132,3 +132,26 @@ func IsValidUserSession(token string) (int64, bool) {
 	}
 	return session.UserID.Int64, true
 }
+
+// GetUserSessions returns a user's sessions, newest first. Tokens are left
+// empty so they can't leak from wherever the sessions are shown.
+func GetUserSessions(userID int64) ([]Session, error) {
+	rows, err := database.DB.Query(
+		"SELECT id, user_id, session_type, expires_at FROM sessions WHERE user_id = ? ORDER BY id DESC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.UserID, &s.SessionType, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
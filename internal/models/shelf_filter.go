@@ -0,0 +1,165 @@
+package models
+
+import (
+	"database/sql"
+	"html/template"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nuuner/spines/internal/database"
+)
+
+// SortOption is a way of ordering the books on a shelf
+type SortOption struct {
+	Value   string
+	Label   string
+	orderBy string // ORDER BY expressions for user_books ub joined with books b
+}
+
+// ShelfSorts lists the supported shelf orders in display order
+var ShelfSorts = []SortOption{
+	{Value: "title", Label: "Title (A-Z)", orderBy: "b.title COLLATE NOCASE, b.id"},
+	{Value: "author", Label: "Author (A-Z)", orderBy: "b.authors = '', b.authors COLLATE NOCASE, b.title COLLATE NOCASE"},
+	{Value: "rating", Label: "Highest rated", orderBy: "ub.rating_points IS NULL, ub.rating_points DESC, b.title COLLATE NOCASE"},
+	{Value: "added", Label: "Recently added", orderBy: "COALESCE(ub.added_at, '1970-01-01') DESC"},
+	{Value: "added_asc", Label: "First added", orderBy: "COALESCE(ub.added_at, '9999-12-31'), ub.id"},
+	{Value: "finished", Label: "Recently finished", orderBy: "ub.finished_reading_at IS NULL, ub.finished_reading_at DESC"},
+	{Value: "pages", Label: "Shortest first", orderBy: "b.page_count IS NULL, b.page_count, b.title COLLATE NOCASE"},
+	{Value: "pages_desc", Label: "Longest first", orderBy: "b.page_count IS NULL, b.page_count DESC, b.title COLLATE NOCASE"},
+}
+
+// IsValidSort checks that a sort is one of ShelfSorts
+func IsValidSort(sort string) bool {
+	for _, s := range ShelfSorts {
+		if s.Value == sort {
+			return true
+		}
+	}
+	return false
+}
+
+// ShelfFilter narrows and orders the books listed on a user's shelves.
+// The zero value lists every book in each shelf's default order.
+type ShelfFilter struct {
+	Format    string       // one of BookFormats, or "" for any
+	Owned     sql.NullBool // unset for any
+	MinRating int          // minimum rating points, or 0 for any
+	Year      int          // year finished, or 0 for any
+	Tag       string       // normalized tag, or "" for any
+	Query     string       // text matched against title and authors
+	Sort      string       // one of ShelfSorts, or "" for the shelf's default order
+}
+
+// IsEmpty reports whether the filter lets every book through in the default order
+func (f ShelfFilter) IsEmpty() bool {
+	return f == ShelfFilter{}
+}
+
+// OwnedParam returns the ownership filter as a query value: "1", "0" or "" for any
+func (f ShelfFilter) OwnedParam() string {
+	if !f.Owned.Valid {
+		return ""
+	}
+	if f.Owned.Bool {
+		return "1"
+	}
+	return "0"
+}
+
+// QueryString returns the filter as URL query parameters, for links that keep it
+func (f ShelfFilter) QueryString() template.URL {
+	values := url.Values{}
+	if f.Format != "" {
+		values.Set("format", f.Format)
+	}
+	if f.Owned.Valid {
+		values.Set("owned", f.OwnedParam())
+	}
+	if f.MinRating > 0 {
+		values.Set("rating", strconv.Itoa(f.MinRating))
+	}
+	if f.Year > 0 {
+		values.Set("year", strconv.Itoa(f.Year))
+	}
+	if f.Tag != "" {
+		values.Set("tag", f.Tag)
+	}
+	if f.Query != "" {
+		values.Set("q", f.Query)
+	}
+	if f.Sort != "" {
+		values.Set("sort", f.Sort)
+	}
+	return template.URL(values.Encode())
+}
+
+// where returns SQL conditions (each prefixed with " AND ") and their arguments
+// for filtering user_books aliased as ub joined with books aliased as b
+func (f ShelfFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if f.Format != "" {
+		conditions = append(conditions, " AND ub.format = ?")
+		args = append(args, f.Format)
+	}
+	if f.Owned.Valid {
+		conditions = append(conditions, " AND ub.owned = ?")
+		args = append(args, f.Owned.Bool)
+	}
+	if f.MinRating > 0 {
+		conditions = append(conditions, " AND ub.rating_points >= ?")
+		args = append(args, f.MinRating)
+	}
+	if f.Year > 0 {
+		conditions = append(conditions, " AND strftime('%Y', ub.finished_reading_at) = ?")
+		args = append(args, strconv.Itoa(f.Year))
+	}
+	if f.Tag != "" {
+		conditions = append(conditions, " AND EXISTS (SELECT 1 FROM user_book_tags t WHERE t.user_book_id = ub.id AND t.tag = ?)")
+		args = append(args, f.Tag)
+	}
+	if f.Query != "" {
+		pattern := "%" + escapeLike(f.Query) + "%"
+		conditions = append(conditions, ` AND (b.title LIKE ? ESCAPE '\' OR b.authors LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern)
+	}
+	return strings.Join(conditions, ""), args
+}
+
+// orderBy returns the ORDER BY expressions for the chosen sort, or "" for the
+// shelf's default order
+func (f ShelfFilter) orderBy() string {
+	for _, s := range ShelfSorts {
+		if s.Value == f.Sort {
+			return s.orderBy
+		}
+	}
+	return ""
+}
+
+// GetFinishedYears returns the years in which a user finished books, newest first
+func GetFinishedYears(userID int64) ([]int, error) {
+	rows, err := database.DB.Query(`
+		SELECT DISTINCT CAST(strftime('%Y', finished_reading_at) AS INTEGER) AS year
+		FROM user_books
+		WHERE user_id = ? AND finished_reading_at IS NOT NULL
+		ORDER BY year DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var years []int
+	for rows.Next() {
+		var year sql.NullInt64
+		if err := rows.Scan(&year); err != nil {
+			return nil, err
+		}
+		if year.Valid {
+			years = append(years, int(year.Int64))
+		}
+	}
+	return years, rows.Err()
+}
@@ -0,0 +1,405 @@
+package models
+
+import (
+	"database/sql"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nuuner/spines/internal/database"
+	"github.com/nuuner/spines/internal/services"
+)
+
+// Number of entries kept in each top list
+const statsTopLimit = 10
+
+// statsShelf is a shelf counted in ReadingStats
+type statsShelf struct {
+	value   string
+	label   string
+	private bool
+}
+
+// statsShelves lists the shelves in display order. Private shelves are only
+// counted in a user's own stats.
+var statsShelves = []statsShelf{
+	{value: "want_to_read", label: "Want to Read"},
+	{value: "currently_reading", label: "Currently Reading"},
+	{value: "read", label: "Read"},
+	{value: "on_hold", label: "On Hold", private: true},
+	{value: "did_not_finish", label: "Did Not Finish", private: true},
+}
+
+// ReadingStats summarises a user's reading, computed from their user_books and
+// the books on them. Everything but Private is safe to show publicly.
+type ReadingStats struct {
+	BooksRead int `json:"books_read"`
+	PagesRead int `json:"pages_read"`
+	// Finished books per year ("2024") and month ("2024-03"), oldest first.
+	// Books without a finish date are left out.
+	ByYear  []PeriodCount `json:"by_year"`
+	ByMonth []PeriodCount `json:"by_month"`
+	// Ratings are in points (1-10), see rating.go
+	RatedBooks          int           `json:"rated_books"`
+	AverageRatingPoints float64       `json:"average_rating_points"`
+	RatingDistribution  []RatingCount `json:"rating_distribution"`
+	// Averages over read books with a page count, and with both a start and finish date
+	AveragePages        int         `json:"average_pages"`
+	AverageDaysToFinish float64     `json:"average_days_to_finish"`
+	TopAuthors          []NameCount `json:"top_authors"`
+	TopGenres           []NameCount `json:"top_genres"`
+	ShelfSizes          []NameCount `json:"shelf_sizes"`
+	// Only filled in for the user's own stats
+	Private *PrivateStats `json:"private,omitempty"`
+}
+
+// PrivateStats are the parts of a user's stats only they can see
+type PrivateStats struct {
+	TotalBooks int         `json:"total_books"`
+	Formats    []NameCount `json:"formats"`
+	Owned      int         `json:"owned"`
+	NotOwned   int         `json:"not_owned"`
+	TopTags    []NameCount `json:"top_tags"`
+}
+
+// PeriodCount is the books and pages finished in a year or month
+type PeriodCount struct {
+	Period string `json:"period"`
+	Books  int    `json:"books"`
+	Pages  int    `json:"pages"`
+	// Books as a percentage of the busiest period, for drawing bars
+	Percent int `json:"-"`
+}
+
+// RatingCount is the number of books given a rating
+type RatingCount struct {
+	Points  int    `json:"points"`
+	Label   string `json:"label"`
+	Count   int    `json:"count"`
+	Percent int    `json:"-"`
+}
+
+// NameCount is the number of books for an author, genre, shelf, format or tag.
+// Key is the stored value where it differs from the display name.
+type NameCount struct {
+	Key     string `json:"key,omitempty"`
+	Name    string `json:"name"`
+	Count   int    `json:"count"`
+	Percent int    `json:"-"`
+}
+
+// GetReadingStats computes a user's reading stats. Unless includePrivate is
+// set, only the public shelves are counted and Private is left nil.
+func GetReadingStats(userID int64, includePrivate bool) (*ReadingStats, error) {
+	stats := &ReadingStats{}
+	if err := stats.loadReadBooks(userID); err != nil {
+		return nil, err
+	}
+	if err := stats.loadShelfSizes(userID, includePrivate); err != nil {
+		return nil, err
+	}
+	if includePrivate {
+		private, err := getPrivateStats(userID)
+		if err != nil {
+			return nil, err
+		}
+		stats.Private = private
+	}
+	return stats, nil
+}
+
+// loadReadBooks fills in everything computed from the read shelf
+func (s *ReadingStats) loadReadBooks(userID int64) error {
+	rows, err := database.DB.Query(`
+		SELECT ub.started_reading_at, ub.finished_reading_at, ub.rating_points,
+			b.page_count, b.authors, b.categories
+		FROM user_books ub
+		JOIN books b ON ub.book_id = b.id
+		WHERE ub.user_id = ? AND ub.shelf = 'read'
+	`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	years := make(map[string]*PeriodCount)
+	months := make(map[string]*PeriodCount)
+	ratings := make(map[int]int)
+	authors := make(map[string]int)
+	genres := make(map[string]int)
+	ratingTotal, pagedBooks, timedBooks := 0, 0, 0
+	var days float64
+
+	for rows.Next() {
+		var started, finished, authorList, categories sql.NullString
+		var rating, pages sql.NullInt64
+		if err := rows.Scan(&started, &finished, &rating, &pages, &authorList, &categories); err != nil {
+			return err
+		}
+
+		s.BooksRead++
+		if pages.Valid && pages.Int64 > 0 {
+			s.PagesRead += int(pages.Int64)
+			pagedBooks++
+		}
+		if rating.Valid && IsValidRating(rating.Int64) {
+			ratings[int(rating.Int64)]++
+			ratingTotal += int(rating.Int64)
+			s.RatedBooks++
+		}
+		for _, author := range splitList(authorList.String, ", ") {
+			authors[author]++
+		}
+		for _, genre := range splitList(categories.String, services.CategorySeparator) {
+			genres[genre]++
+		}
+
+		if !finished.Valid {
+			continue
+		}
+		finishedAt, _ := parseDateTime(finished.String)
+		if finishedAt.IsZero() {
+			continue
+		}
+		addToPeriod(years, finishedAt.Format("2006"), pages)
+		addToPeriod(months, finishedAt.Format("2006-01"), pages)
+
+		if started.Valid {
+			startedAt, _ := parseDateTime(started.String)
+			if !startedAt.IsZero() && !finishedAt.Before(startedAt) {
+				days += finishedAt.Sub(startedAt).Hours() / 24
+				timedBooks++
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.ByYear = sortedPeriods(years)
+	s.ByMonth = sortedPeriods(months)
+	if s.RatedBooks > 0 {
+		s.AverageRatingPoints = round1(float64(ratingTotal) / float64(s.RatedBooks))
+	}
+	if pagedBooks > 0 {
+		s.AveragePages = s.PagesRead / pagedBooks
+	}
+	if timedBooks > 0 {
+		s.AverageDaysToFinish = round1(days / float64(timedBooks))
+	}
+
+	s.RatingDistribution = make([]RatingCount, 0, MaxRatingPoints)
+	most := 0
+	for _, count := range ratings {
+		most = max(most, count)
+	}
+	for points := MaxRatingPoints; points >= 1; points-- {
+		s.RatingDistribution = append(s.RatingDistribution, RatingCount{
+			Points:  points,
+			Label:   FormatRating(int64(points)),
+			Count:   ratings[points],
+			Percent: percentOf(ratings[points], most),
+		})
+	}
+
+	s.TopAuthors = topCounts(authors)
+	s.TopGenres = topCounts(genres)
+	return nil
+}
+
+// loadShelfSizes counts the books on each shelf, skipping private shelves
+// unless asked for
+func (s *ReadingStats) loadShelfSizes(userID int64, includePrivate bool) error {
+	counts, err := countBy(`
+		SELECT shelf, COUNT(*) FROM user_books WHERE user_id = ? GROUP BY shelf
+	`, userID)
+	if err != nil {
+		return err
+	}
+
+	s.ShelfSizes = []NameCount{}
+	most := 0
+	for _, shelf := range statsShelves {
+		if shelf.private && !includePrivate {
+			continue
+		}
+		s.ShelfSizes = append(s.ShelfSizes, NameCount{Key: shelf.value, Name: shelf.label, Count: counts[shelf.value]})
+		most = max(most, counts[shelf.value])
+	}
+	setPercents(s.ShelfSizes, most)
+	return nil
+}
+
+// getPrivateStats counts a user's books by format, ownership and tag
+func getPrivateStats(userID int64) (*PrivateStats, error) {
+	p := &PrivateStats{Formats: []NameCount{}}
+
+	err := database.DB.QueryRow(`
+		SELECT COUNT(*),
+			COALESCE(SUM(CASE WHEN owned = 1 THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN owned = 0 THEN 1 ELSE 0 END), 0)
+		FROM user_books WHERE user_id = ?
+	`, userID).Scan(&p.TotalBooks, &p.Owned, &p.NotOwned)
+	if err != nil {
+		return nil, err
+	}
+
+	formats, err := countBy(`
+		SELECT format, COUNT(*) FROM user_books
+		WHERE user_id = ? AND format IS NOT NULL
+		GROUP BY format
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	most := 0
+	for _, f := range BookFormats {
+		if formats[f.Value] == 0 {
+			continue
+		}
+		p.Formats = append(p.Formats, NameCount{Key: f.Value, Name: f.Label, Count: formats[f.Value]})
+		most = max(most, formats[f.Value])
+	}
+	setPercents(p.Formats, most)
+
+	tags, err := countBy(`
+		SELECT t.tag, COUNT(*) FROM user_book_tags t
+		JOIN user_books ub ON t.user_book_id = ub.id
+		WHERE ub.user_id = ?
+		GROUP BY t.tag
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	p.TopTags = topCounts(tags)
+
+	return p, nil
+}
+
+// AverageRatingDisplay returns the average rating in the configured scale,
+// e.g. "3.8 stars"
+func (s ReadingStats) AverageRatingDisplay() string {
+	if s.RatedBooks == 0 {
+		return ""
+	}
+	if RatingScale == 10 {
+		return strconv.FormatFloat(s.AverageRatingPoints, 'f', 1, 64) + "/10"
+	}
+	return strconv.FormatFloat(s.AverageRatingPoints/2, 'f', 1, 64) + " stars"
+}
+
+// AverageDaysDisplay returns the average time from starting to finishing a book
+func (s ReadingStats) AverageDaysDisplay() string {
+	if s.AverageDaysToFinish == 0 {
+		return ""
+	}
+	if s.AverageDaysToFinish < 1 {
+		return "Under a day"
+	}
+	return pluralize(int(math.Round(s.AverageDaysToFinish)), "day")
+}
+
+// countBy runs a query returning (name, count) rows and collects them in a map
+func countBy(query string, args ...interface{}) (map[string]int, error) {
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var name string
+		var count int
+		if err := rows.Scan(&name, &count); err != nil {
+			return nil, err
+		}
+		counts[name] = count
+	}
+	return counts, rows.Err()
+}
+
+// splitList splits a stored list such as authors or categories, dropping blanks
+func splitList(s, sep string) []string {
+	var items []string
+	for _, item := range strings.Split(s, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func addToPeriod(periods map[string]*PeriodCount, period string, pages sql.NullInt64) {
+	p, ok := periods[period]
+	if !ok {
+		p = &PeriodCount{Period: period}
+		periods[period] = p
+	}
+	p.Books++
+	if pages.Valid && pages.Int64 > 0 {
+		p.Pages += int(pages.Int64)
+	}
+}
+
+// sortedPeriods returns periods oldest first with their bar percentages set
+func sortedPeriods(periods map[string]*PeriodCount) []PeriodCount {
+	sorted := make([]PeriodCount, 0, len(periods))
+	most := 0
+	for _, p := range periods {
+		sorted = append(sorted, *p)
+		most = max(most, p.Books)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Period < sorted[j].Period })
+	for i := range sorted {
+		sorted[i].Percent = percentOf(sorted[i].Books, most)
+	}
+	return sorted
+}
+
+// topCounts returns the statsTopLimit most common names, ties broken by name
+func topCounts(counts map[string]int) []NameCount {
+	top := make([]NameCount, 0, len(counts))
+	for name, count := range counts {
+		top = append(top, NameCount{Name: name, Count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return strings.ToLower(top[i].Name) < strings.ToLower(top[j].Name)
+	})
+	if len(top) > statsTopLimit {
+		top = top[:statsTopLimit]
+	}
+	if len(top) > 0 {
+		setPercents(top, top[0].Count)
+	}
+	return top
+}
+
+func setPercents(counts []NameCount, most int) {
+	for i := range counts {
+		counts[i].Percent = percentOf(counts[i].Count, most)
+	}
+}
+
+func percentOf(n, most int) int {
+	if most == 0 {
+		return 0
+	}
+	return n * 100 / most
+}
+
+func round1(f float64) float64 {
+	return math.Round(f*10) / 10
+}
+
+// RecentMonths returns the last twelve months with finished books
+func (s ReadingStats) RecentMonths() []PeriodCount {
+	if len(s.ByMonth) > 12 {
+		return s.ByMonth[len(s.ByMonth)-12:]
+	}
+	return s.ByMonth
+}
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/nuuner/spines/internal/database"
+)
+
+// Limits on the tags a user can put on a single book
+const (
+	MaxTagLength   = 40
+	MaxTagsPerBook = 20
+)
+
+// NormalizeTag lowercases a tag and collapses its whitespace. Commas are
+// removed as they separate tags in forms and in TagList.
+func NormalizeTag(tag string) string {
+	tag = strings.ReplaceAll(tag, ",", " ")
+	tag = strings.Join(strings.Fields(strings.ToLower(tag)), " ")
+	if runes := []rune(tag); len(runes) > MaxTagLength {
+		tag = strings.TrimSpace(string(runes[:MaxTagLength]))
+	}
+	return tag
+}
+
+// ParseTags splits comma-separated tags, normalizing them and dropping
+// empty and duplicate tags
+func ParseTags(input string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(input, ",") {
+		tag := NormalizeTag(part)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+		if len(tags) == MaxTagsPerBook {
+			break
+		}
+	}
+	return tags
+}
+
+// Tags returns the book's tags in alphabetical order
+func (ub UserBook) Tags() []string {
+	if !ub.TagList.Valid || ub.TagList.String == "" {
+		return nil
+	}
+	tags := strings.Split(ub.TagList.String, ",")
+	sort.Strings(tags)
+	return tags
+}
+
+// SetUserBookTags replaces the tags on a user's book
+func SetUserBookTags(userID, bookID int64, tags []string) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userBookID int64
+	err = tx.QueryRow("SELECT id FROM user_books WHERE user_id = ? AND book_id = ?", userID, bookID).Scan(&userBookID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM user_book_tags WHERE user_book_id = ?", userBookID); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.Exec("INSERT INTO user_book_tags (user_book_id, tag) VALUES (?, ?)", userBookID, tag); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetUserTags returns every tag a user has used, in alphabetical order
+func GetUserTags(userID int64) ([]string, error) {
+	rows, err := database.DB.Query(`
+		SELECT DISTINCT t.tag
+		FROM user_book_tags t
+		JOIN user_books ub ON t.user_book_id = ub.id
+		WHERE ub.user_id = ?
+		ORDER BY t.tag
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
@@ -43,6 +43,13 @@ func (u *User) CheckPassword(password string) bool {
 	return err == nil
 }
 
+// UploadsDir holds everything users upload, served under /static/uploads
+const UploadsDir = "./web/static/uploads"
+
+// AvatarDir is where uploaded profile pictures are stored, served under
+// /static/uploads/avatars
+const AvatarDir = UploadsDir + "/avatars"
+
 // GetProfilePictureURL returns the URL for the user's profile picture or default
 func (u *User) GetProfilePictureURL() string {
 	if u.ProfilePicture.Valid && u.ProfilePicture.String != "" {
@@ -220,6 +227,20 @@ func UpdateUserTheme(userID int64, theme string) error {
 	return err
 }
 
+// IsCatalogPublic reports whether a user offers their shelves as an OPDS
+// catalog, which they do unless they turned it off
+func IsCatalogPublic(userID int64) (bool, error) {
+	var public bool
+	err := database.DB.QueryRow("SELECT catalog_public FROM users WHERE id = ?", userID).Scan(&public)
+	return public, err
+}
+
+// SetCatalogPublic sets whether a user's shelves are offered as an OPDS catalog
+func SetCatalogPublic(userID int64, public bool) error {
+	_, err := database.DB.Exec("UPDATE users SET catalog_public = ? WHERE id = ?", public, userID)
+	return err
+}
+
 // IsValidTheme checks if a theme value is valid
 func IsValidTheme(theme string) bool {
 	_, ok := ValidThemes[theme]
@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,8 +19,37 @@ type UserBook struct {
 	AddedAt           sql.NullString
 	StartedReadingAt  sql.NullString
 	FinishedReadingAt sql.NullString
-	Rating            sql.NullInt64
-	Book              *Book
+	Rating            sql.NullInt64 // rating points, 1-10 (see rating.go)
+	// Where reading stopped, for books on hold or not finished
+	StoppedAt      sql.NullString
+	StoppedPage    sql.NullInt64
+	StoppedPercent sql.NullInt64
+	StoppedReason  sql.NullString
+	// Copy details, independent of the shelf (see ownership.go)
+	Format sql.NullString
+	Owned  sql.NullBool
+	// Position in the reading queue, only set on the want to read shelf (see queue.go)
+	QueuePosition sql.NullInt64
+	// Comma-separated tags (see tag.go)
+	TagList sql.NullString
+	Book    *Book
+}
+
+// userBookColumns lists the user_books columns read by UserBook.scanFields
+const userBookColumns = `ub.id, ub.user_id, ub.book_id, ub.shelf, ub.sub_status,
+		       ub.added_at, ub.started_reading_at, ub.finished_reading_at, ub.rating_points,
+		       ub.stopped_at, ub.stopped_page, ub.stopped_percent, ub.stopped_reason,
+		       ub.format, ub.owned, ub.queue_position,
+		       (SELECT GROUP_CONCAT(t.tag) FROM user_book_tags t WHERE t.user_book_id = ub.id)`
+
+// scanFields returns scan destinations matching userBookColumns
+func (ub *UserBook) scanFields() []interface{} {
+	return []interface{}{
+		&ub.ID, &ub.UserID, &ub.BookID, &ub.Shelf, &ub.SubStatus,
+		&ub.AddedAt, &ub.StartedReadingAt, &ub.FinishedReadingAt, &ub.Rating,
+		&ub.StoppedAt, &ub.StoppedPage, &ub.StoppedPercent, &ub.StoppedReason,
+		&ub.Format, &ub.Owned, &ub.QueuePosition, &ub.TagList,
+	}
 }
 
 // parseDateTime parses a SQLite datetime string into time.Time
@@ -44,18 +74,34 @@ type ShelfBooks struct {
 	WantToRead       []UserBook
 	CurrentlyReading []UserBook
 	Read             []UserBook
+	OnHold           []UserBook
+	DidNotFinish     []UserBook
+}
+
+// IsStoppedShelf reports whether a shelf holds books the user stopped reading
+// (paused or abandoned). These never count as read.
+func IsStoppedShelf(shelf string) bool {
+	return shelf == "on_hold" || shelf == "did_not_finish"
 }
 
 func GetUserBooks(userID int64) (*ShelfBooks, error) {
+	return GetUserBooksFiltered(userID, ShelfFilter{})
+}
+
+// GetUserBooksFiltered returns a user's books grouped by shelf, limited to those matching the filter
+func GetUserBooksFiltered(userID int64, filter ShelfFilter) (*ShelfBooks, error) {
+	conditions, filterArgs := filter.where()
+	orderBy := filter.orderBy()
+	if orderBy == "" {
+		orderBy = queueOrder + ", COALESCE(ub.added_at, '1970-01-01') DESC"
+	}
 	rows, err := database.DB.Query(`
-		SELECT ub.id, ub.user_id, ub.book_id, ub.shelf, ub.sub_status,
-		       ub.added_at, ub.started_reading_at, ub.finished_reading_at, ub.rating,
-		       b.id, b.google_books_id, b.title, b.authors, b.description, b.thumbnail_url, b.isbn_13, b.isbn_10, b.page_count, b.created_at
+		SELECT `+userBookColumns+`, `+bookColumns+`
 		FROM user_books ub
 		JOIN books b ON ub.book_id = b.id
-		WHERE ub.user_id = ?
-		ORDER BY COALESCE(ub.added_at, '1970-01-01') DESC
-	`, userID)
+		WHERE ub.user_id = ?`+conditions+`
+		ORDER BY `+orderBy+`
+	`, append([]interface{}{userID}, filterArgs...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -65,11 +111,7 @@ func GetUserBooks(userID int64) (*ShelfBooks, error) {
 	for rows.Next() {
 		var ub UserBook
 		var b Book
-		if err := rows.Scan(
-			&ub.ID, &ub.UserID, &ub.BookID, &ub.Shelf, &ub.SubStatus,
-			&ub.AddedAt, &ub.StartedReadingAt, &ub.FinishedReadingAt, &ub.Rating,
-			&b.ID, &b.GoogleBooksID, &b.Title, &b.Authors, &b.Description, &b.ThumbnailURL, &b.ISBN13, &b.ISBN10, &b.PageCount, &b.CreatedAt,
-		); err != nil {
+		if err := rows.Scan(append(ub.scanFields(), b.scanFields()...)...); err != nil {
 			return nil, err
 		}
 		ub.Book = &b
@@ -81,10 +123,17 @@ func GetUserBooks(userID int64) (*ShelfBooks, error) {
 			shelves.CurrentlyReading = append(shelves.CurrentlyReading, ub)
 		case "read":
 			shelves.Read = append(shelves.Read, ub)
+		case "on_hold":
+			shelves.OnHold = append(shelves.OnHold, ub)
+		case "did_not_finish":
+			shelves.DidNotFinish = append(shelves.DidNotFinish, ub)
 		}
 	}
 
-	// Sort read shelf by finished_reading_at DESC (most recently read first)
+	// Unless sorted otherwise, sort read shelf by finished_reading_at DESC (most recently read first)
+	if filter.Sort != "" {
+		return shelves, rows.Err()
+	}
 	sort.Slice(shelves.Read, func(i, j int) bool {
 		iTime := "1970-01-01"
 		jTime := "1970-01-01"
@@ -115,9 +164,7 @@ func GetRandomCurrentlyReadingByUserIDs(userIDs []int64) (map[int64]*UserBook, e
 	}
 
 	query := `
-		SELECT ub.id, ub.user_id, ub.book_id, ub.shelf, ub.sub_status,
-		       ub.added_at, ub.started_reading_at, ub.finished_reading_at, ub.rating,
-		       b.id, b.google_books_id, b.title, b.authors, b.description, b.thumbnail_url, b.isbn_13, b.isbn_10, b.page_count, b.created_at
+		SELECT ` + userBookColumns + `, ` + bookColumns + `
 		FROM user_books ub
 		JOIN books b ON ub.book_id = b.id
 		WHERE ub.shelf = 'currently_reading' AND ub.user_id IN (` + strings.Join(placeholders, ",") + `)
@@ -134,11 +181,7 @@ func GetRandomCurrentlyReadingByUserIDs(userIDs []int64) (map[int64]*UserBook, e
 	for rows.Next() {
 		var ub UserBook
 		var b Book
-		if err := rows.Scan(
-			&ub.ID, &ub.UserID, &ub.BookID, &ub.Shelf, &ub.SubStatus,
-			&ub.AddedAt, &ub.StartedReadingAt, &ub.FinishedReadingAt, &ub.Rating,
-			&b.ID, &b.GoogleBooksID, &b.Title, &b.Authors, &b.Description, &b.ThumbnailURL, &b.ISBN13, &b.ISBN10, &b.PageCount, &b.CreatedAt,
-		); err != nil {
+		if err := rows.Scan(append(ub.scanFields(), b.scanFields()...)...); err != nil {
 			return nil, err
 		}
 		ub.Book = &b
@@ -151,38 +194,48 @@ func GetRandomCurrentlyReadingByUserIDs(userIDs []int64) (map[int64]*UserBook, e
 }
 
 // GetShelfBooksPaginated returns books for a specific shelf with pagination
-// Returns the books slice, total count for that shelf, and any error
-func GetShelfBooksPaginated(userID int64, shelf string, offset, limit int) ([]UserBook, int, error) {
+// Returns the books slice, total count for that shelf (after filtering), and any error
+func GetShelfBooksPaginated(userID int64, shelf string, filter ShelfFilter, offset, limit int) ([]UserBook, int, error) {
+	conditions, filterArgs := filter.where()
+	args := append([]interface{}{userID, shelf}, filterArgs...)
+
 	// Get total count for this shelf
 	var total int
 	err := database.DB.QueryRow(`
-		SELECT COUNT(*) FROM user_books WHERE user_id = ? AND shelf = ?
-	`, userID, shelf).Scan(&total)
+		SELECT COUNT(*) FROM user_books ub JOIN books b ON ub.book_id = b.id
+		WHERE ub.user_id = ? AND ub.shelf = ?`+conditions,
+		args...).Scan(&total)
 	if err != nil {
 		return nil, 0, err
 	}
 
-	// Determine ORDER BY clause based on shelf
+	// Determine ORDER BY clause based on the chosen sort, or else on the shelf
 	// For "read" shelf: sort by finished_reading_at DESC (most recently read first)
+	// For "on_hold" and "did_not_finish": sort by stopped_at DESC
+	// For "want_to_read": sort by the user's queue order
 	// For other shelves: sort by added_at DESC (newest first)
 	var orderBy string
-	if shelf == "read" {
+	if sortOrder := filter.orderBy(); sortOrder != "" {
+		orderBy = "ORDER BY " + sortOrder
+	} else if shelf == "read" {
 		orderBy = "ORDER BY COALESCE(ub.finished_reading_at, '1970-01-01') DESC"
+	} else if IsStoppedShelf(shelf) {
+		orderBy = "ORDER BY COALESCE(ub.stopped_at, '1970-01-01') DESC"
+	} else if shelf == "want_to_read" {
+		orderBy = "ORDER BY " + queueOrder + ", COALESCE(ub.added_at, '1970-01-01') DESC"
 	} else {
 		orderBy = "ORDER BY COALESCE(ub.added_at, '1970-01-01') DESC"
 	}
 
 	// Get paginated books
 	rows, err := database.DB.Query(`
-		SELECT ub.id, ub.user_id, ub.book_id, ub.shelf, ub.sub_status,
-		       ub.added_at, ub.started_reading_at, ub.finished_reading_at, ub.rating,
-		       b.id, b.google_books_id, b.title, b.authors, b.description, b.thumbnail_url, b.isbn_13, b.isbn_10, b.page_count, b.created_at
+		SELECT `+userBookColumns+`, `+bookColumns+`
 		FROM user_books ub
 		JOIN books b ON ub.book_id = b.id
-		WHERE ub.user_id = ? AND ub.shelf = ?
+		WHERE ub.user_id = ? AND ub.shelf = ?`+conditions+`
 		`+orderBy+`
 		LIMIT ? OFFSET ?
-	`, userID, shelf, limit, offset)
+	`, append(args, limit, offset)...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -192,11 +245,7 @@ func GetShelfBooksPaginated(userID int64, shelf string, offset, limit int) ([]Us
 	for rows.Next() {
 		var ub UserBook
 		var b Book
-		if err := rows.Scan(
-			&ub.ID, &ub.UserID, &ub.BookID, &ub.Shelf, &ub.SubStatus,
-			&ub.AddedAt, &ub.StartedReadingAt, &ub.FinishedReadingAt, &ub.Rating,
-			&b.ID, &b.GoogleBooksID, &b.Title, &b.Authors, &b.Description, &b.ThumbnailURL, &b.ISBN13, &b.ISBN10, &b.PageCount, &b.CreatedAt,
-		); err != nil {
+		if err := rows.Scan(append(ub.scanFields(), b.scanFields()...)...); err != nil {
 			return nil, 0, err
 		}
 		ub.Book = &b
@@ -208,12 +257,10 @@ func GetShelfBooksPaginated(userID int64, shelf string, offset, limit int) ([]Us
 func GetUserBook(userID, bookID int64) (*UserBook, error) {
 	var ub UserBook
 	err := database.DB.QueryRow(`
-		SELECT ub.id, ub.user_id, ub.book_id, ub.shelf, ub.sub_status,
-		       ub.added_at, ub.started_reading_at, ub.finished_reading_at, ub.rating
+		SELECT `+userBookColumns+`
 		FROM user_books ub
 		WHERE ub.user_id = ? AND ub.book_id = ?
-	`, userID, bookID).Scan(&ub.ID, &ub.UserID, &ub.BookID, &ub.Shelf, &ub.SubStatus,
-		&ub.AddedAt, &ub.StartedReadingAt, &ub.FinishedReadingAt, &ub.Rating)
+	`, userID, bookID).Scan(ub.scanFields()...)
 	if err != nil {
 		return nil, err
 	}
@@ -230,53 +277,107 @@ func AddBookToShelf(userID, bookID int64, shelf string, subStatus sql.NullString
 		_, err := database.DB.Exec(query, userID, bookID, shelf, subStatus)
 		return err
 	case "read":
-		query = `INSERT INTO user_books (user_id, book_id, shelf, sub_status, rating, added_at, started_reading_at, finished_reading_at)
+		query = `INSERT INTO user_books (user_id, book_id, shelf, sub_status, rating_points, added_at, started_reading_at, finished_reading_at)
 		         VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`
 		_, err := database.DB.Exec(query, userID, bookID, shelf, subStatus, rating)
 		return err
+	case "on_hold", "did_not_finish":
+		// When reading started isn't known, so it is left for the user to fill in
+		query = `INSERT INTO user_books (user_id, book_id, shelf, sub_status, added_at, stopped_at)
+		         VALUES (?, ?, ?, NULL, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`
+		_, err := database.DB.Exec(query, userID, bookID, shelf)
+		return err
 	default:
-		query = `INSERT INTO user_books (user_id, book_id, shelf, sub_status, added_at)
-		         VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`
-		_, err := database.DB.Exec(query, userID, bookID, shelf, subStatus)
+		// New books join the end of the reading queue
+		query = `INSERT INTO user_books (user_id, book_id, shelf, sub_status, added_at, queue_position)
+		         VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, ` + nextQueuePosition + `)`
+		_, err := database.DB.Exec(query, userID, bookID, shelf, subStatus, userID)
 		return err
 	}
 }
 
 func UpdateUserBook(userID, bookID int64, shelf string, subStatus sql.NullString, rating sql.NullInt64) error {
+	return updateUserBook(database.DB, userID, bookID, shelf, subStatus, rating)
+}
+
+// updateUserBook moves a book between shelves using db, which may be a transaction
+func updateUserBook(db execer, userID, bookID int64, shelf string, subStatus sql.NullString, rating sql.NullInt64) error {
 	switch shelf {
 	case "want_to_read":
-		// Moving backward: clear both timestamps and rating
-		_, err := database.DB.Exec(`UPDATE user_books
-		         SET shelf = ?, sub_status = ?, rating = NULL, started_reading_at = NULL, finished_reading_at = NULL
-		         WHERE user_id = ? AND book_id = ?`, shelf, subStatus, userID, bookID)
+		// Moving backward: clear both timestamps and rating, and join the end of the
+		// reading queue unless already in it
+		_, err := db.Exec(`UPDATE user_books
+		         SET shelf = ?, sub_status = ?, rating_points = NULL, started_reading_at = NULL, finished_reading_at = NULL,
+		             stopped_at = NULL, stopped_page = NULL, stopped_percent = NULL, stopped_reason = NULL,
+		             queue_position = CASE
+		                 WHEN shelf = 'want_to_read' THEN queue_position
+		                 ELSE `+nextQueuePosition+`
+		             END
+		         WHERE user_id = ? AND book_id = ?`, shelf, subStatus, userID, userID, bookID)
 		return err
 	case "currently_reading":
 		// If finished_reading_at is set (re-read scenario), start fresh with new started_reading_at
-		// Otherwise, preserve existing started_reading_at or set it if NULL. Clear rating.
-		_, err := database.DB.Exec(`UPDATE user_books
-		         SET shelf = ?, sub_status = ?, rating = NULL,
+		// Otherwise, preserve existing started_reading_at or set it if NULL (e.g. resuming a
+		// paused book). Clear rating and where reading stopped.
+		_, err := db.Exec(`UPDATE user_books
+		         SET shelf = ?, sub_status = ?, rating_points = NULL,
 		             started_reading_at = CASE
 		                 WHEN finished_reading_at IS NOT NULL THEN CURRENT_TIMESTAMP
 		                 ELSE COALESCE(started_reading_at, CURRENT_TIMESTAMP)
 		             END,
-		             finished_reading_at = NULL
+		             finished_reading_at = NULL,
+		             stopped_at = NULL, stopped_page = NULL, stopped_percent = NULL, stopped_reason = NULL,
+		             queue_position = NULL
 		         WHERE user_id = ? AND book_id = ?`, shelf, subStatus, userID, bookID)
 		return err
 	case "read":
-		// Set finished_reading_at, preserve or set started_reading_at, set rating
-		_, err := database.DB.Exec(`UPDATE user_books
-		         SET shelf = ?, sub_status = ?, rating = ?,
+		// Set finished_reading_at (kept when already read, e.g. when only the rating changes),
+		// preserve or set started_reading_at, set rating
+		_, err := db.Exec(`UPDATE user_books
+		         SET shelf = ?, sub_status = ?, rating_points = ?,
 		             started_reading_at = COALESCE(started_reading_at, CURRENT_TIMESTAMP),
-		             finished_reading_at = CURRENT_TIMESTAMP
+		             finished_reading_at = CASE
+		                 WHEN shelf = 'read' THEN COALESCE(finished_reading_at, CURRENT_TIMESTAMP)
+		                 ELSE CURRENT_TIMESTAMP
+		             END,
+		             stopped_at = NULL, stopped_page = NULL, stopped_percent = NULL, stopped_reason = NULL,
+		             queue_position = NULL
 		         WHERE user_id = ? AND book_id = ?`, shelf, subStatus, rating, userID, bookID)
 		return err
+	case "on_hold", "did_not_finish":
+		// Keep where reading stopped when only switching between the two, and
+		// when reading started only if it is known; see StopReading
+		_, err := db.Exec(`UPDATE user_books
+		         SET shelf = ?, sub_status = NULL, rating_points = NULL, finished_reading_at = NULL, queue_position = NULL,
+		             stopped_at = CASE
+		                 WHEN shelf IN ('on_hold', 'did_not_finish') THEN COALESCE(stopped_at, CURRENT_TIMESTAMP)
+		                 ELSE CURRENT_TIMESTAMP
+		             END
+		         WHERE user_id = ? AND book_id = ?`, shelf, userID, bookID)
+		return err
 	default:
-		_, err := database.DB.Exec(`UPDATE user_books SET shelf = ?, sub_status = ? WHERE user_id = ? AND book_id = ?`,
+		_, err := db.Exec(`UPDATE user_books SET shelf = ?, sub_status = ? WHERE user_id = ? AND book_id = ?`,
 			shelf, subStatus, userID, bookID)
 		return err
 	}
 }
 
+// StopReading moves a book to the "on_hold" or "did_not_finish" shelf, recording
+// how far the user got (page and/or percent) and an optional reason. When
+// reading started is left as it was, unknown for a book never started.
+func StopReading(userID, bookID int64, shelf string, page, percent sql.NullInt64, reason sql.NullString) error {
+	_, err := database.DB.Exec(`UPDATE user_books
+	         SET shelf = ?, sub_status = NULL, rating_points = NULL, finished_reading_at = NULL, queue_position = NULL,
+	             stopped_at = CASE
+	                 WHEN shelf IN ('on_hold', 'did_not_finish') THEN COALESCE(stopped_at, CURRENT_TIMESTAMP)
+	                 ELSE CURRENT_TIMESTAMP
+	             END,
+	             stopped_page = ?, stopped_percent = ?, stopped_reason = ?
+	         WHERE user_id = ? AND book_id = ?`,
+		shelf, page, percent, reason, userID, bookID)
+	return err
+}
+
 func RemoveBookFromShelf(userID, bookID int64) error {
 	_, err := database.DB.Exec(
 		"DELETE FROM user_books WHERE user_id = ? AND book_id = ?",
@@ -301,10 +402,6 @@ func (ub UserBook) SubStatusDisplay() string {
 		return "75%"
 	case "almost_finished":
 		return "Almost finished"
-	case "need_to_buy":
-		return "Do not own"
-	case "already_own":
-		return "Already own"
 	default:
 		return ub.SubStatus.String
 	}
@@ -414,22 +511,84 @@ func (ub UserBook) FinishedReadingAtDisplay() string {
 	return t.Format("Jan 2, 2006")
 }
 
-// RatingDisplay returns the rating as a string of star characters
+// RatingDisplay returns the rating as text in the configured scale (e.g., "3.5 stars" or "7/10")
 func (ub UserBook) RatingDisplay() string {
 	if !ub.Rating.Valid {
 		return ""
 	}
-	stars := ""
-	for i := int64(0); i < ub.Rating.Int64; i++ {
-		stars += "*"
+	return FormatRating(ub.Rating.Int64)
+}
+
+// RatingStars returns the state of each of the five stars in the rating widget
+func (ub UserBook) RatingStars() []string {
+	if !ub.Rating.Valid {
+		return nil
 	}
-	return stars
+	return ratingStars(ub.Rating.Int64)
 }
 
-// RatingValue returns the rating as an int (0 if not set)
+// RatingValue returns the rating points as an int (0 if not set)
 func (ub UserBook) RatingValue() int {
 	if !ub.Rating.Valid {
 		return 0
 	}
 	return int(ub.Rating.Int64)
 }
+
+// StoppedAtDisplay returns when reading stopped formatted for display (e.g., "Jan 15, 2026")
+func (ub UserBook) StoppedAtDisplay() string {
+	if !ub.StoppedAt.Valid {
+		return ""
+	}
+	t, err := parseDateTime(ub.StoppedAt.String)
+	if err != nil || t.IsZero() {
+		return ""
+	}
+	return t.Format("Jan 2, 2006")
+}
+
+// StoppedProgressDisplay returns how far the user got before stopping
+// (e.g., "page 120 (45%)"), or "" if not recorded
+func (ub UserBook) StoppedProgressDisplay() string {
+	return formatStoppedProgress(ub.StoppedPage, ub.StoppedPercent)
+}
+
+// formatStoppedProgress describes a stopping point by page and/or percent
+func formatStoppedProgress(page, percent sql.NullInt64) string {
+	switch {
+	case page.Valid && percent.Valid:
+		return "page " + strconv.FormatInt(page.Int64, 10) + " (" + strconv.FormatInt(percent.Int64, 10) + "%)"
+	case page.Valid:
+		return "page " + strconv.FormatInt(page.Int64, 10)
+	case percent.Valid:
+		return strconv.FormatInt(percent.Int64, 10) + "%"
+	default:
+		return ""
+	}
+}
+
+// EditFormData returns the values the My Books edit dialog is opened with
+func (ub UserBook) EditFormData() map[string]interface{} {
+	data := map[string]interface{}{
+		"bookId":         ub.BookID,
+		"shelf":          ub.Shelf,
+		"subStatus":      ub.SubStatus.String,
+		"addedAt":        ub.AddedAtFormatted(),
+		"startedAt":      ub.StartedReadingAtFormatted(),
+		"finishedAt":     ub.FinishedReadingAtFormatted(),
+		"rating":         ub.RatingValue(),
+		"stoppedPage":    "",
+		"stoppedPercent": "",
+		"stoppedReason":  ub.StoppedReason.String,
+		"format":         ub.Format.String,
+		"owned":          ub.OwnedValue(),
+		"tags":           strings.Join(ub.Tags(), ", "),
+	}
+	if ub.StoppedPage.Valid {
+		data["stoppedPage"] = ub.StoppedPage.Int64
+	}
+	if ub.StoppedPercent.Valid {
+		data["stoppedPercent"] = ub.StoppedPercent.Int64
+	}
+	return data
+}
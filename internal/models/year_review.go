@@ -0,0 +1,111 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/nuuner/spines/internal/database"
+)
+
+// YearReview is a recap of the books a user finished in a year
+type YearReview struct {
+	Year int
+	// Books finished that year, in the order they were finished
+	Books      []UserBook
+	TotalPages int
+	// Highlights, nil when no book qualifies (e.g. none have a page count)
+	First        *UserBook
+	Last         *UserBook
+	Longest      *UserBook
+	Shortest     *UserBook
+	HighestRated *UserBook
+	// Books finished in each month of the year, January first
+	Months []PeriodCount
+}
+
+// GetYearReview builds the recap of the books a user finished in a year
+func GetYearReview(userID int64, year int) (*YearReview, error) {
+	rows, err := database.DB.Query(`
+		SELECT `+userBookColumns+`, `+bookColumns+`
+		FROM user_books ub
+		JOIN books b ON ub.book_id = b.id
+		WHERE ub.user_id = ? AND ub.shelf = 'read' AND strftime('%Y', ub.finished_reading_at) = ?
+		ORDER BY ub.finished_reading_at, ub.id
+	`, userID, strconv.Itoa(year))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	r := &YearReview{Year: year}
+	for rows.Next() {
+		var ub UserBook
+		var b Book
+		if err := rows.Scan(append(ub.scanFields(), b.scanFields()...)...); err != nil {
+			return nil, err
+		}
+		ub.Book = &b
+		r.Books = append(r.Books, ub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	months := make([]PeriodCount, 12)
+	for i := range months {
+		months[i].Period = time.Month(i + 1).String()
+	}
+	for i := range r.Books {
+		ub := &r.Books[i]
+		if finished, _ := parseDateTime(ub.FinishedReadingAt.String); !finished.IsZero() {
+			m := &months[finished.Month()-1]
+			m.Books++
+			if ub.Book.PageCount.Valid {
+				m.Pages += int(ub.Book.PageCount.Int64)
+			}
+		}
+
+		if ub.Book.PageCount.Valid && ub.Book.PageCount.Int64 > 0 {
+			pages := ub.Book.PageCount.Int64
+			r.TotalPages += int(pages)
+			if r.Longest == nil || pages > r.Longest.Book.PageCount.Int64 {
+				r.Longest = ub
+			}
+			if r.Shortest == nil || pages < r.Shortest.Book.PageCount.Int64 {
+				r.Shortest = ub
+			}
+		}
+		if ub.Rating.Valid && (r.HighestRated == nil || ub.Rating.Int64 > r.HighestRated.Rating.Int64) {
+			r.HighestRated = ub
+		}
+	}
+	if len(r.Books) > 0 {
+		r.First = &r.Books[0]
+		r.Last = &r.Books[len(r.Books)-1]
+	}
+
+	most := 0
+	for _, m := range months {
+		most = max(most, m.Books)
+	}
+	for i := range months {
+		months[i].Percent = percentOf(months[i].Books, most)
+	}
+	r.Months = months
+
+	return r, nil
+}
+
+// BusiestMonth returns the month the most books were finished in, or "" if none were
+func (r YearReview) BusiestMonth() string {
+	busiest := -1
+	for i, m := range r.Months {
+		if m.Books > 0 && (busiest < 0 || m.Books > r.Months[busiest].Books) {
+			busiest = i
+		}
+	}
+	if busiest < 0 {
+		return ""
+	}
+	return r.Months[busiest].Period
+}
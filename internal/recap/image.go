@@ -0,0 +1,178 @@
+package recap
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"sync"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Image size, matching what link previews expect
+const (
+	Width  = 1200
+	Height = 630
+)
+
+// Cover wall layout on the right of the image
+const (
+	coverWidth   = 110
+	coverHeight  = 165
+	coverGap     = 12
+	coverColumns = 4
+	coverRows    = 3
+	// MaxCovers is how many covers fit on the wall
+	MaxCovers = coverColumns * coverRows
+)
+
+const margin = 60
+
+var (
+	background = color.RGBA{0x1f, 0x1b, 0x18, 0xff}
+	foreground = color.RGBA{0xf5, 0xf0, 0xe8, 0xff}
+	muted      = color.RGBA{0xb3, 0xa9, 0x9c, 0xff}
+	accent     = color.RGBA{0xe0, 0x9f, 0x3e, 0xff}
+	blank      = color.RGBA{0x3a, 0x34, 0x2e, 0xff}
+)
+
+// Stat is a headline number, e.g. {"24", "books"}
+type Stat struct {
+	Value string
+	Label string
+}
+
+// Card is the content of a shareable recap image
+type Card struct {
+	Title string
+	Stats []Stat
+	// Highlights are drawn one per line below the stats
+	Highlights []string
+	// Covers for the wall, in order. Nil entries are drawn as blank covers.
+	Covers []image.Image
+}
+
+// Faces are not safe for concurrent use, so renders take turns
+var (
+	renderMu  sync.Mutex
+	facesOnce sync.Once
+	facesErr  error
+	titleFace font.Face
+	statFace  font.Face
+	textFace  font.Face
+)
+
+// loadFaces parses the bundled Go fonts once
+func loadFaces() error {
+	facesOnce.Do(func() {
+		bold, err := opentype.Parse(gobold.TTF)
+		if err != nil {
+			facesErr = err
+			return
+		}
+		regular, err := opentype.Parse(goregular.TTF)
+		if err != nil {
+			facesErr = err
+			return
+		}
+		if titleFace, err = opentype.NewFace(bold, &opentype.FaceOptions{Size: 40, DPI: 72, Hinting: font.HintingFull}); err != nil {
+			facesErr = err
+			return
+		}
+		if statFace, err = opentype.NewFace(bold, &opentype.FaceOptions{Size: 56, DPI: 72, Hinting: font.HintingFull}); err != nil {
+			facesErr = err
+			return
+		}
+		textFace, facesErr = opentype.NewFace(regular, &opentype.FaceOptions{Size: 24, DPI: 72, Hinting: font.HintingFull})
+	})
+	return facesErr
+}
+
+// Render draws the card and writes it as a PNG
+func Render(w io.Writer, card Card) error {
+	renderMu.Lock()
+	defer renderMu.Unlock()
+	if err := loadFaces(); err != nil {
+		return err
+	}
+
+	img := imaging.New(Width, Height, background)
+
+	wallWidth := coverColumns*coverWidth + (coverColumns-1)*coverGap
+	wallX := Width - margin - wallWidth
+	wallY := (Height - (coverRows*coverHeight + (coverRows-1)*coverGap)) / 2
+	textWidth := wallX - 2*margin
+
+	y := margin + 40
+	drawText(img, titleFace, foreground, margin, y, truncate(titleFace, card.Title, textWidth))
+
+	// Stats side by side, value above label
+	y += 100
+	x := margin
+	for _, stat := range card.Stats {
+		drawText(img, statFace, accent, x, y, stat.Value)
+		drawText(img, textFace, muted, x, y+34, stat.Label)
+		valueWidth := font.MeasureString(statFace, stat.Value).Ceil()
+		labelWidth := font.MeasureString(textFace, stat.Label).Ceil()
+		x += max(valueWidth, labelWidth) + 48
+	}
+
+	y += 100
+	for _, line := range card.Highlights {
+		if y > Height-margin {
+			break
+		}
+		drawText(img, textFace, foreground, margin, y, truncate(textFace, line, textWidth))
+		y += 40
+	}
+
+	for i := 0; i < MaxCovers; i++ {
+		cx := wallX + (i%coverColumns)*(coverWidth+coverGap)
+		cy := wallY + (i/coverColumns)*(coverHeight+coverGap)
+		var cover image.Image
+		if i < len(card.Covers) {
+			cover = card.Covers[i]
+		}
+		if cover == nil {
+			img = imaging.Paste(img, imaging.New(coverWidth, coverHeight, blank), image.Pt(cx, cy))
+			continue
+		}
+		cover = imaging.Fill(cover, coverWidth, coverHeight, imaging.Center, imaging.Lanczos)
+		img = imaging.Paste(img, cover, image.Pt(cx, cy))
+	}
+
+	return png.Encode(w, img)
+}
+
+// drawText draws s with its baseline at y
+func drawText(dst *image.NRGBA, face font.Face, c color.Color, x, y int, s string) {
+	d := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(s)
+}
+
+// truncate shortens s with an ellipsis so it fits in width pixels
+func truncate(face font.Face, s string, width int) string {
+	if font.MeasureString(face, s).Ceil() <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		candidate := string(runes) + "…"
+		if font.MeasureString(face, candidate).Ceil() <= width {
+			return candidate
+		}
+	}
+	return ""
+}
@@ -33,6 +33,7 @@ type GoogleVolumeInfo struct {
 	PageCount           int                  `json:"pageCount"`
 	PublishedDate       string               `json:"publishedDate"`
 	Language            string               `json:"language"`
+	Categories          []string             `json:"categories"`
 }
 
 type IndustryIdentifier struct {
@@ -56,8 +57,12 @@ type BookSearchResult struct {
 	PageCount     int
 	PublishedYear string
 	Language      string
+	Categories    string // separated by CategorySeparator
 }
 
+// CategorySeparator joins a book's categories, which may themselves contain commas
+const CategorySeparator = "; "
+
 func SearchBooks(query string, apiKey string) ([]BookSearchResult, error) {
 	// Check cache first
 	if cached, found := searchCache.Get(query); found {
@@ -113,6 +118,7 @@ func SearchBooks(query string, apiKey string) ([]BookSearchResult, error) {
 		if item.VolumeInfo.Authors != nil {
 			book.Authors = strings.Join(item.VolumeInfo.Authors, ", ")
 		}
+		book.Categories = strings.Join(item.VolumeInfo.Categories, CategorySeparator)
 
 		// Extract ISBNs from IndustryIdentifiers
 		for _, identifier := range item.VolumeInfo.IndustryIdentifiers {
@@ -227,6 +233,7 @@ func GetBookByISBN(isbn13, isbn10, apiKey string) (*BookSearchResult, error) {
 		if item.VolumeInfo.Authors != nil {
 			book.Authors = strings.Join(item.VolumeInfo.Authors, ", ")
 		}
+		book.Categories = strings.Join(item.VolumeInfo.Categories, CategorySeparator)
 
 		// Extract ISBNs from IndustryIdentifiers
 		for _, identifier := range item.VolumeInfo.IndustryIdentifiers {
@@ -253,3 +260,43 @@ func GetBookByISBN(isbn13, isbn10, apiKey string) (*BookSearchResult, error) {
 	return nil, nil
 }
 
+
+// volumeCategoriesCache stores the categories of volumes looked up by ID for 8 hours
+var volumeCategoriesCache = cache.New(8 * time.Hour)
+
+// GetVolumeCategories fetches a volume's categories (genres) from Google
+// Books, joined by CategorySeparator. Volumes Google doesn't know, or knows
+// no categories for, return "".
+func GetVolumeCategories(googleBooksID, apiKey string) (string, error) {
+	if cached, found := volumeCategoriesCache.Get(googleBooksID); found {
+		return cached.(string), nil
+	}
+
+	params := url.Values{}
+	params.Set("fields", "volumeInfo/categories")
+	if apiKey != "" {
+		params.Set("key", apiKey)
+	}
+
+	resp, err := http.Get("https://www.googleapis.com/books/v1/volumes/" + url.PathEscape(googleBooksID) + "?" + params.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		volumeCategoriesCache.Set(googleBooksID, "")
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("google books API returned status %d", resp.StatusCode)
+	}
+
+	var item GoogleBookItem
+	if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+		return "", err
+	}
+	categories := strings.Join(item.VolumeInfo.Categories, CategorySeparator)
+	volumeCategoriesCache.Set(googleBooksID, categories)
+	return categories, nil
+}